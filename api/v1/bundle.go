@@ -0,0 +1,268 @@
+package v1
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ivanilves/lstags/api/v1/collection"
+	dockerclient "github.com/ivanilves/lstags/docker/client"
+	"github.com/ivanilves/lstags/oci/bundle"
+	"github.com/ivanilves/lstags/oci/layout"
+	"github.com/ivanilves/lstags/repository"
+	"github.com/ivanilves/lstags/tag"
+	"github.com/ivanilves/lstags/util/fix"
+)
+
+// stagingLayout gets OCI layout to stage bundle images in: either the configured one or a temporary one
+// NB! Returned cleanup function must be called, when layout is not needed anymore
+func (api *API) stagingLayout() (*layout.Layout, func(), error) {
+	if api.layout != nil {
+		return api.layout, func() {}, nil
+	}
+
+	dir, err := ioutil.TempDir("", "lstags-bundle-")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	l, err := layout.Open(dir)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+
+	return l, cleanup, nil
+}
+
+// ExportTags pulls all images of the collection passed, which are present in the registry,
+// and writes them into an "air-gapped" bundle file (tar archive with OCI image layout and a manifest of refs)
+func (api *API) ExportTags(cn *collection.Collection, fileName string) error {
+	log.Debugf(
+		"%s collection: %+v (%d repos / %d tags)",
+		fn(), cn, cn.RepoCount(), cn.TagCount(),
+	)
+
+	l, cleanup, err := api.stagingLayout()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	refNames := make([]string, 0)
+
+	for _, ref := range cn.Refs() {
+		repo := cn.Repo(ref)
+
+		for _, tg := range cn.Tags(ref) {
+			if tg.GetState() == "LOCAL_ONLY" || tg.GetState() == "NOT_FOUND" {
+				continue
+			}
+
+			refName := layout.RefName(repo.Name(), tg.Name())
+
+			log.Infof("[EXPORT] PULLING %s", refName)
+			if api.config.DryRun {
+				log.Infof("[DRY-RUN] EXPORTED %s", refName)
+				continue
+			}
+
+			if err := api.pullToLayout(l, repo, tg); err != nil {
+				return err
+			}
+
+			refNames = append(refNames, refName)
+		}
+	}
+
+	if api.config.DryRun {
+		return nil
+	}
+
+	f, err := os.Create(fix.Path(fileName))
+	if err != nil {
+		return err
+	}
+
+	refs, err := bundle.Export(f, l, refNames)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	log.Infof("[EXPORT] WRITTEN %d images to %s", len(refs), fileName)
+
+	return f.Close()
+}
+
+// ImportTags reads "air-gapped" bundle file and pushes images it carries to the registry,
+// using push configuration passed (incl. path and tag templates), repository overrides are used for images
+// their references match (e.g. "quay.io/coreos/flannel~/^v0\./" override is used for "quay.io/coreos/flannel:v0.11.0")
+// NB! Images are pushed and verified the same way PushTags does it: tags already pushed are skipped
+// (changed ones are only updated, if configured so), protected tags having different digest in the registry
+// are never overwritten: they are reported and *ConflictError is returned after the rest of images are imported.
+func (api *API) ImportTags(fileName string, push PushConfig) error {
+	log.Debugf("%s push config: %+v", fn(), push)
+
+	if push.Registry == "" && len(push.RepoOverrides) == 0 {
+		return fmt.Errorf("no push registry configured to import bundle into")
+	}
+
+	f, err := os.Open(fix.Path(fileName))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	l, cleanup, err := api.stagingLayout()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	refs, err := bundle.Import(f, l)
+	if err != nil {
+		return fmt.Errorf("unable to read bundle '%s': %s", fileName, err.Error())
+	}
+
+	conflicts := make([]Conflict, 0)
+
+	for _, ref := range refs {
+		name, tagName, err := layout.SplitRefName(ref.Name)
+		if err != nil {
+			return err
+		}
+
+		push, repo, err := push.forImage(name, tagName)
+		if err != nil {
+			return err
+		}
+
+		if push.Registry == "" {
+			log.Warnf("[IMPORT] SKIPPED %s: no push registry configured", ref.Name)
+			continue
+		}
+
+		makeDstRef, err := makePushRefMaker(push)
+		if err != nil {
+			return err
+		}
+
+		d, err := l.Resolve(ref.Name)
+		if err != nil {
+			return err
+		}
+
+		ctx, err := layoutTemplateContext(l, repo, tagName, *d)
+		if err != nil {
+			return err
+		}
+
+		dstRef, err := makeDstRef(ctx)
+		if err != nil {
+			return err
+		}
+
+		pushDockerClient, err := api.pushDockerClient(push)
+		if err != nil {
+			return err
+		}
+
+		tg, err := api.joinImportTag(repo, tagName, *d, dstRef, pushDockerClient, push)
+		if err != nil {
+			return err
+		}
+
+		if tg.IsConflict() {
+			conflicts = append(conflicts, api.reportConflictAt(repo, tg, dstRef))
+
+			continue
+		}
+
+		if !tg.NeedsPush(push.UpdateChanged) {
+			log.Infof("[IMPORT] SKIPPED %s => %s: %s", ref.Name, dstRef, tg.GetState())
+			continue
+		}
+
+		log.Infof("[IMPORT] PUSHING %s => %s", ref.Name, dstRef)
+		if api.config.DryRun {
+			log.Infof("[DRY-RUN] PUSHED %s => %s", ref.Name, dstRef)
+			continue
+		}
+
+		if err := api.pushFromLayout(l, ref.Name, dstRef, push); err != nil {
+			return fmt.Errorf("PUSH %s => %s failed: '%w'", ref.Name, dstRef, err)
+		}
+
+		dstDigest, platform, err := api.verifyDigest(dstRef, pushDockerClient, d.Digest, func() (map[string]string, error) {
+			return layoutPlatforms(l, *d)
+		})
+		if err != nil {
+			return fmt.Errorf("VERIFY %s => %s failed: %w", ref.Name, dstRef, err)
+		}
+
+		if platform != "" {
+			log.Infof("[IMPORT] VERIFIED %s => %s (%s: %s)", ref.Name, dstRef, platform, dstDigest)
+		} else {
+			log.Infof("[IMPORT] VERIFIED %s => %s (%s)", ref.Name, dstRef, dstDigest)
+		}
+	}
+
+	if len(conflicts) != 0 {
+		return &ConflictError{Conflicts: conflicts}
+	}
+
+	return nil
+}
+
+// joinImportTag joins bundle image (tag) with the one already pushed to the destination reference passed,
+// so the tag gets its state relative to the "push" registry (e.g. "ABSENT", "CHANGED" or "CONFLICT")
+func (api *API) joinImportTag(
+	repo *repository.Repository,
+	tagName string,
+	d layout.Descriptor,
+	dstRef string,
+	pushDockerClient *dockerclient.DockerClient,
+	push PushConfig,
+) (*tag.Tag, error) {
+	tg, err := tag.New(tagName, tag.Options{Digest: d.Digest})
+	if err != nil {
+		return nil, err
+	}
+
+	dstRepo, err := repository.ParseRef(dstRef)
+	if err != nil {
+		return nil, err
+	}
+
+	// tags are joined (and protected) by the name they are pushed with
+	pushTagName := dstRepo.Tags()[0]
+
+	pushedTags := make(map[string]*tag.Tag)
+
+	dstDigest, err := api.pushedDigest(dstRef, pushDockerClient)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+
+		log.Debugf("%s not pushed yet: %s", fn(repo.Ref()), dstRef)
+	} else {
+		pushedTags[pushTagName], err = tag.New(pushTagName, tag.Options{Digest: dstDigest})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, _, joinedTags := tag.Join(map[string]*tag.Tag{pushTagName: tg}, pushedTags, nil)
+
+	if err := protectTags(joinedTags, push); err != nil {
+		return nil, err
+	}
+
+	return joinedTags[pushTagName], nil
+}
@@ -31,6 +31,12 @@ func contains(slice []string, str string) bool {
 
 // New creates a collection of API resources from passed repository references and tags
 func New(refs []string, tags map[string][]*tag.Tag) (*Collection, error) {
+	return NewWithErrors(refs, tags, nil)
+}
+
+// NewWithErrors creates a (partial) collection of API resources from passed repository references and tags,
+// along with errors happened while collecting tags for some of the references (keyed by reference)
+func NewWithErrors(refs []string, tags map[string][]*tag.Tag, errs map[string]error) (*Collection, error) {
 	repos := make(map[string]*repository.Repository)
 
 	for _, ref := range refs {
@@ -52,14 +58,26 @@ func New(refs []string, tags map[string][]*tag.Tag) (*Collection, error) {
 		}
 	}
 
-	return &Collection{refs: refs, repos: repos, tags: tags}, nil
+	refErrors := make(map[string]error)
+	for ref, err := range errs {
+		if !contains(refs, ref) {
+			return nil, fmt.Errorf("repository has error, but not referenced: %s", ref)
+		}
+
+		if err != nil {
+			refErrors[ref] = err
+		}
+	}
+
+	return &Collection{refs: refs, repos: repos, tags: tags, errors: refErrors}, nil
 }
 
 // Collection of API resources received from a registry or Docker daemon query
 type Collection struct {
-	refs  []string
-	repos map[string]*repository.Repository
-	tags  map[string][]*tag.Tag
+	refs   []string
+	repos  map[string]*repository.Repository
+	tags   map[string][]*tag.Tag
+	errors map[string]error
 }
 
 // Refs returns all repository references from collection
@@ -114,6 +132,29 @@ func (cn *Collection) TagMap(ref string) map[string]*tag.Tag {
 	return tagMap
 }
 
+// Err returns error happened while collecting tags for the repository reference passed (nil if none)
+func (cn *Collection) Err(ref string) error {
+	return cn.errors[ref]
+}
+
+// Errors returns all errors happened while collecting tags (keyed by repository reference)
+func (cn *Collection) Errors() map[string]error {
+	return cn.errors
+}
+
+// FailedRefs returns repository references we failed to collect tags for (in order of references)
+func (cn *Collection) FailedRefs() []string {
+	failedRefs := make([]string, 0)
+
+	for _, ref := range cn.Refs() {
+		if cn.Err(ref) != nil {
+			failedRefs = append(failedRefs, ref)
+		}
+	}
+
+	return failedRefs
+}
+
 // RepoCount counts total repo number inside the collection
 func (cn *Collection) RepoCount() int {
 	return len(cn.refs)
@@ -1,6 +1,7 @@
 package collection
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -262,3 +263,35 @@ func TestTaggedRefs(t *testing.T) {
 
 	assert.Equal(t, taggedRefs, cn.TaggedRefs())
 }
+
+func TestNewWithErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	var refs = []string{"nginx", "debian", "alpine"}
+	var refTags = makeRefTags("nginx", "alpine")
+	refTags["debian"] = []*tag.Tag{}
+
+	cn, err := NewWithErrors(refs, refTags, map[string]error{"debian": fmt.Errorf("unreachable"), "alpine": nil})
+
+	assert.Nil(err)
+	assert.Equal(refs, cn.Refs())
+	assert.Equal(4, cn.TagCount())
+	assert.Nil(cn.Err("nginx"))
+	assert.Nil(cn.Err("alpine"))
+	assert.EqualError(cn.Err("debian"), "unreachable")
+	assert.Equal([]string{"debian"}, cn.FailedRefs())
+	assert.Len(cn.Errors(), 1)
+
+	_, err = NewWithErrors(refs, refTags, map[string]error{"busybox": fmt.Errorf("unreachable")})
+
+	assert.NotNil(err, "should not accept errors for repositories not referenced")
+}
+
+func TestNewWithNoErrors(t *testing.T) {
+	var refs = []string{"nginx", "debian"}
+
+	cn, _ := New(refs, makeRefTags(refs...))
+
+	assert.Empty(t, cn.FailedRefs())
+	assert.Empty(t, cn.Errors())
+}
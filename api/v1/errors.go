@@ -0,0 +1,131 @@
+package v1
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ivanilves/lstags/api/v1/plan"
+	"github.com/ivanilves/lstags/api/v1/registry/client/request"
+)
+
+// Registry request errors to check API errors against with errors.Is(), e.g.:
+//
+//	if errors.Is(err, v1.ErrNotFound) { ... }
+var (
+	// ErrNotFound means registry has no such repository, tag or blob (HTTP 404)
+	ErrNotFound = request.ErrNotFound
+	// ErrUnauthorized means we are not (or not properly) authenticated against the registry (HTTP 401)
+	ErrUnauthorized = request.ErrUnauthorized
+	// ErrForbidden means we are authenticated, but have no permission to do what we want (HTTP 403)
+	ErrForbidden = request.ErrForbidden
+	// ErrRateLimited means we sent too much requests to the registry (HTTP 429)
+	ErrRateLimited = request.ErrRateLimited
+	// ErrServer means registry failed to process our request (HTTP 5xx)
+	ErrServer = request.ErrServer
+	// ErrTransport means we were unable to communicate the registry at all (network, TLS etc)
+	ErrTransport = request.ErrTransport
+)
+
+// RegistryError is an error returned when registry responds with unexpected HTTP status
+// (use errors.As() to get status code, request URL and errors reported by registry)
+type RegistryError = request.Error
+
+// TransportError is an error returned when registry request could not be completed at all
+type TransportError = request.TransportError
+
+// CollectError is returned (along with the partial collection) when we failed to collect tags for some repositories
+type CollectError struct {
+	// Refs are repository references we failed to collect tags for
+	Refs []string
+	// Errors are errors happened while collecting tags (keyed by repository reference)
+	Errors map[string]error
+}
+
+func (e *CollectError) Error() string {
+	messages := make([]string, len(e.Refs))
+	for i, ref := range e.Refs {
+		messages[i] = ref + ": " + e.Errors[ref].Error()
+	}
+
+	return fmt.Sprintf("failed to collect tags for %d repositories:\n%s", len(e.Refs), strings.Join(messages, "\n"))
+}
+
+// Is makes error match target error, if any of the repository errors matches it, e.g. errors.Is(err, v1.ErrNotFound)
+func (e *CollectError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ErrDigestMismatch means digest of the image pushed does not match digest of the source image (nor any of its platforms)
+var ErrDigestMismatch = errors.New("pushed image digest does not match source digest")
+
+// ErrDigestMoved means source image digest has moved since sync plan was made, so plan entry could not be applied
+var ErrDigestMoved = errors.New("source digest moved since planning")
+
+// ApplyError is returned when some sync plan entries were refused to be applied (the rest of them are still applied)
+type ApplyError struct {
+	// Refused are plan entries refused to be applied, because their source digest has moved since planning
+	Refused []plan.Entry
+	// Err is an error happened while applying the rest of the plan (if any), incl. errors of collecting source tags
+	Err error
+}
+
+func (e *ApplyError) Error() string {
+	messages := make([]string, len(e.Refused))
+	for i, entry := range e.Refused {
+		messages[i] = entry.SourceRef + " => " + entry.DestinationRef
+	}
+
+	msg := fmt.Sprintf("refused %d sync plan entries (%s):\n%s", len(e.Refused), ErrDigestMoved.Error(), strings.Join(messages, "\n"))
+	if e.Err != nil {
+		msg += "\n" + e.Err.Error()
+	}
+
+	return msg
+}
+
+// Unwrap gets an error happened while applying the rest of the plan (if any)
+func (e *ApplyError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes error match ErrDigestMoved (if there are refused entries)
+func (e *ApplyError) Is(target error) bool {
+	return target == ErrDigestMoved && len(e.Refused) != 0
+}
+
+// ErrTagConflict means protected tag has different digest in "push" registry, so we refuse to overwrite it
+var ErrTagConflict = errors.New("protected tag has different digest in destination")
+
+// Conflict is a protected tag having different digest in "push" registry
+type Conflict struct {
+	SourceRef      string
+	SourceDigest   string
+	DestinationRef string
+}
+
+// ConflictError is returned (along with the "push" collection) when some protected tags have different digest in "push" registry
+type ConflictError struct {
+	// Conflicts are protected tags we refuse to overwrite
+	Conflicts []Conflict
+}
+
+func (e *ConflictError) Error() string {
+	messages := make([]string, len(e.Conflicts))
+	for i, c := range e.Conflicts {
+		messages[i] = c.SourceRef + " => " + c.DestinationRef
+	}
+
+	return fmt.Sprintf("refused to overwrite %d protected tags (%s):\n%s", len(e.Conflicts), ErrTagConflict.Error(), strings.Join(messages, "\n"))
+}
+
+// Is makes error match ErrTagConflict
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrTagConflict
+}
@@ -0,0 +1,156 @@
+package v1
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// EventType is a type of event emitted by API
+type EventType string
+
+// Types of events emitted by API
+const (
+	// EventAnalyzeStart is emitted when we start to analyze (fetch tags of) the repository
+	EventAnalyzeStart EventType = "analyze-start"
+	// EventAnalyzeFinish is emitted when we finished to analyze the repository successfully
+	EventAnalyzeFinish EventType = "analyze-finish"
+	// EventTagDiscovered is emitted for every tag discovered while analyzing the repository
+	EventTagDiscovered EventType = "tag-discovered"
+	// EventPullStart is emitted when we start to pull the image
+	EventPullStart EventType = "pull-start"
+	// EventPullProgress is emitted on every image pull progress update (reported by Docker daemon)
+	EventPullProgress EventType = "pull-progress"
+	// EventPullFinish is emitted when we finished to pull the image successfully
+	EventPullFinish EventType = "pull-finish"
+	// EventPushStart is emitted when we start to [pull and] push the image
+	EventPushStart EventType = "push-start"
+	// EventPushProgress is emitted on every image [pull and] push progress update (reported by Docker daemon)
+	EventPushProgress EventType = "push-progress"
+	// EventPushFinish is emitted when we finished to push the image successfully
+	EventPushFinish EventType = "push-finish"
+	// EventPushVerified is emitted when digest of the image pushed is verified to match the source one
+	EventPushVerified EventType = "push-verified"
+	// EventRetry is emitted when failed registry request is going to be retried
+	EventRetry EventType = "retry"
+	// EventError is emitted when we failed to analyze the repository or to pull/push the image
+	EventError EventType = "error"
+)
+
+// Event is a single typed event emitted by API (only fields relevant for the event type are set)
+type Event struct {
+	// Type is a type of the event
+	Type EventType
+	// Time is a time event happened
+	Time time.Time
+	// Ref is either a repository reference (analysis events) or an image reference, i.e. "REPOSITORY:TAG" (pull/push events)
+	Ref string
+	// Tag is a tag name
+	Tag string
+	// Digest is an image digest
+	Digest string
+	// State is a tag state, e.g. "ABSENT" or "CHANGED"
+	State string
+	// Destination is an image reference we push to (or "push" repository reference we analyze),
+	// it is empty for progress of the source image pull, as the image is pulled once for all push targets
+	Destination string
+	// DestinationDigest is a digest of the image pushed (as resolved in the "push" registry)
+	DestinationDigest string
+	// Platform is a platform of the source image pushed image matches, e.g. "linux/amd64" (if source image is an index)
+	Platform string
+	// Status is a progress status reported by Docker daemon, e.g. "Downloading"
+	Status string
+	// Layer is an ID of the image layer progress is reported for
+	Layer string
+	// Current is a number of bytes transferred (for the layer)
+	Current int64
+	// Total is a total number of bytes to transfer (for the layer)
+	Total int64
+	// URL is a registry request URL (with all secrets redacted)
+	URL string
+	// Attempt is a number of failed attempt to perform registry request
+	Attempt int
+	// Delay is a delay before the next attempt to perform registry request
+	Delay time.Duration
+	// Err is an error happened
+	Err error
+}
+
+// withType makes a copy of the event with the type passed
+func (e Event) withType(t EventType) Event {
+	e.Type = t
+
+	return e
+}
+
+// Handler handles events emitted by API
+// NB! Handlers are called synchronously from concurrent workers, so they should be fast and concurrency-safe
+type Handler func(Event)
+
+// subscribers hold event handlers subscribed to the API instance
+type subscribers struct {
+	handlers map[int]Handler
+	next     int
+	mux      sync.RWMutex
+}
+
+func newSubscribers() *subscribers {
+	return &subscribers{handlers: make(map[int]Handler)}
+}
+
+func (s *subscribers) add(handler Handler) func() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	id := s.next
+	s.handlers[id] = handler
+	s.next++
+
+	return func() {
+		s.mux.Lock()
+		defer s.mux.Unlock()
+
+		delete(s.handlers, id)
+	}
+}
+
+func (s *subscribers) emit(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	// handlers are called with no lock held, so they are free to (un)subscribe and do not block other emitters
+	for _, handler := range s.list() {
+		handler(e)
+	}
+}
+
+// list gets handlers subscribed (in order of their subscription)
+func (s *subscribers) list() []Handler {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	ids := make([]int, 0, len(s.handlers))
+	for id := range s.handlers {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	handlers := make([]Handler, len(ids))
+	for i, id := range ids {
+		handlers[i] = s.handlers[id]
+	}
+
+	return handlers
+}
+
+// Subscribe subscribes handler passed to all events emitted by API instance (progress, retries, errors etc),
+// returned function unsubscribes it (handlers are free to subscribe or unsubscribe, even themselves, while handling events)
+func (api *API) Subscribe(handler Handler) (unsubscribe func()) {
+	return api.subscribers.add(handler)
+}
+
+// emit emits event to all handlers subscribed
+func (api *API) emit(e Event) {
+	api.subscribers.emit(e)
+}
@@ -0,0 +1,201 @@
+package v1
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ivanilves/lstags/api/v1/registry/client"
+	"github.com/ivanilves/lstags/oci/layout"
+)
+
+// eventRecorder records events received to check them later
+type eventRecorder struct {
+	events []Event
+	mux    sync.Mutex
+}
+
+func (r *eventRecorder) handle(e Event) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.events = append(r.events, e)
+}
+
+func (r *eventRecorder) ofType(t EventType) []Event {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	events := make([]Event, 0)
+	for _, e := range r.events {
+		if e.Type == t {
+			events = append(events, e)
+		}
+	}
+
+	return events
+}
+
+func TestSubscribe(t *testing.T) {
+	assert := assert.New(t)
+
+	api := &API{subscribers: newSubscribers()}
+
+	var first, second eventRecorder
+
+	unsubscribeFirst := api.Subscribe(first.handle)
+	api.Subscribe(second.handle)
+
+	api.emit(Event{Type: EventAnalyzeStart, Ref: "alpine"})
+
+	unsubscribeFirst()
+
+	api.emit(Event{Type: EventAnalyzeFinish, Ref: "alpine"})
+
+	assert.Len(first.events, 1)
+	assert.Len(second.events, 2)
+	assert.Equal(EventAnalyzeStart, first.events[0].Type)
+	assert.False(first.events[0].Time.IsZero(), "should set event time")
+	assert.Equal(EventAnalyzeFinish, second.events[1].Type)
+}
+
+func TestSubscribe_UnsubscribeFromHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	api := &API{subscribers: newSubscribers()}
+
+	var once eventRecorder
+	var unsubscribe func()
+
+	unsubscribe = api.Subscribe(func(e Event) {
+		once.handle(e)
+
+		unsubscribe()
+		api.Subscribe(func(Event) {})
+	})
+
+	done := make(chan struct{})
+	go func() {
+		api.emit(Event{Type: EventAnalyzeStart, Ref: "alpine"})
+		api.emit(Event{Type: EventAnalyzeFinish, Ref: "alpine"})
+
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("should not deadlock, if handler (un)subscribes")
+	}
+
+	assert.Len(once.events, 1)
+}
+
+func seedFakeRegistry(t *testing.T, registry string) {
+	assert := assert.New(t)
+
+	cli, err := client.New(registry, client.Config{IsInsecure: true})
+	assert.Nil(err)
+	assert.Nil(cli.Login("", ""))
+
+	src, err := layout.Open("../../fixtures/oci/layout")
+	assert.Nil(err)
+
+	for _, refName := range []string{"quay.io/coreos/flannel:v0.10.0", "quay.io/coreos/flannel:v0.11.0"} {
+		_, tagName, _ := layout.SplitRefName(refName)
+
+		d, err := src.Resolve(refName)
+		assert.Nil(err, refName)
+		assert.Nil(copyFromLayout(src, *d, cli, "lstags/flannel", tagName), refName)
+	}
+}
+
+func TestEvents_CollectAndPull(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(newFakeRegistry())
+	defer server.Close()
+
+	registry := strings.TrimPrefix(server.URL, "http://")
+
+	seedFakeRegistry(t, registry)
+
+	dir, err := ioutil.TempDir("", "lstags-oci-layout-")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	api, err := New(Config{OCILayoutDir: dir})
+	assert.Nil(err)
+
+	var recorder eventRecorder
+	api.Subscribe(recorder.handle)
+
+	presentRef := registry + "/lstags/flannel"
+	missingRef := registry + "/lstags/missing"
+
+	cn, _ := api.CollectTags(presentRef, missingRef)
+
+	assert.Len(recorder.ofType(EventAnalyzeStart), 2)
+	assert.Len(recorder.ofType(EventAnalyzeFinish), 1)
+	assert.Len(recorder.ofType(EventTagDiscovered), 2)
+	for _, e := range recorder.ofType(EventTagDiscovered) {
+		assert.Equal(presentRef, e.Ref)
+		assert.Equal("ABSENT", e.State)
+		assert.NotEmpty(e.Digest)
+	}
+
+	errorEvents := recorder.ofType(EventError)
+	if assert.Len(errorEvents, 1) {
+		assert.Equal(missingRef, errorEvents[0].Ref)
+		assert.True(errors.Is(errorEvents[0].Err, ErrNotFound))
+	}
+
+	assert.Nil(api.PullTags(cn))
+
+	assert.Len(recorder.ofType(EventPullStart), 2)
+	for _, e := range recorder.ofType(EventPullFinish) {
+		assert.True(strings.HasPrefix(e.Ref, presentRef+":"))
+		assert.Contains([]string{"v0.10.0", "v0.11.0"}, e.Tag)
+	}
+	assert.Len(recorder.ofType(EventPullFinish), 2)
+}
+
+func TestEvents_Retry(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/tags/list") {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	api, err := New(Config{RetryRequests: 2, RetryDelay: time.Millisecond})
+	assert.Nil(err)
+
+	var recorder eventRecorder
+	api.Subscribe(recorder.handle)
+
+	ref := strings.TrimPrefix(server.URL, "http://") + "/lstags/unavailable"
+
+	_, err = api.CollectTags(ref)
+	assert.True(errors.Is(err, ErrServer))
+
+	retryEvents := recorder.ofType(EventRetry)
+	if assert.Len(retryEvents, 2) {
+		assert.Equal(1, retryEvents[0].Attempt)
+		assert.Equal(2, retryEvents[1].Attempt)
+		assert.Equal(server.URL+"/v2/lstags/unavailable/tags/list", retryEvents[0].URL)
+		assert.True(errors.Is(retryEvents[0].Err, ErrServer))
+	}
+
+	assert.Len(recorder.ofType(EventError), 1)
+}
@@ -0,0 +1,169 @@
+package v1
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ivanilves/lstags/api/v1/registry/client"
+	"github.com/ivanilves/lstags/oci/layout"
+	"github.com/ivanilves/lstags/repository"
+	"github.com/ivanilves/lstags/tag"
+)
+
+// copyToLayout copies manifest (or index) with all blobs it references from registry to the OCI layout
+// NB! Manifest blob is written only after all its children, so its presence means image is complete
+func copyToLayout(cli *client.RegistryClient, repoPath, reference string, l *layout.Layout) (layout.Descriptor, error) {
+	mediaType, digest, data, err := cli.Manifest(repoPath, reference)
+	if err != nil {
+		return layout.Descriptor{}, err
+	}
+
+	d := layout.Descriptor{MediaType: mediaType, Digest: digest, Size: int64(len(data))}
+
+	if l.HasBlob(digest) {
+		return d, nil
+	}
+
+	children, err := layout.Children(mediaType, data)
+	if err != nil {
+		return layout.Descriptor{}, err
+	}
+
+	for _, child := range children {
+		if layout.IsIndex(child.MediaType) || layout.IsManifest(child.MediaType) {
+			if _, err := copyToLayout(cli, repoPath, child.Digest, l); err != nil {
+				return layout.Descriptor{}, err
+			}
+
+			continue
+		}
+
+		if l.HasBlob(child.Digest) {
+			continue
+		}
+
+		blob, err := cli.Blob(repoPath, child.Digest)
+		if err != nil {
+			return layout.Descriptor{}, err
+		}
+
+		err = l.WriteBlob(child.Digest, blob)
+		blob.Close()
+		if err != nil {
+			return layout.Descriptor{}, err
+		}
+	}
+
+	if err := l.WriteBlob(digest, bytes.NewReader(data)); err != nil {
+		return layout.Descriptor{}, err
+	}
+
+	return d, nil
+}
+
+// copyFromLayout copies manifest (or index) described with all blobs it references from the OCI layout to registry
+func copyFromLayout(l *layout.Layout, d layout.Descriptor, cli *client.RegistryClient, repoPath, reference string) error {
+	data, err := l.ReadBlobBytes(d.Digest)
+	if err != nil {
+		return err
+	}
+
+	children, err := layout.Children(d.MediaType, data)
+	if err != nil {
+		return err
+	}
+
+	for _, child := range children {
+		if layout.IsIndex(child.MediaType) || layout.IsManifest(child.MediaType) {
+			if err := copyFromLayout(l, child, cli, repoPath, child.Digest); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		exists, err := cli.HasBlob(repoPath, child.Digest)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+
+		blob, err := l.ReadBlob(child.Digest)
+		if err != nil {
+			return err
+		}
+
+		err = cli.PutBlob(repoPath, child.Digest, child.Size, blob)
+		blob.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return cli.PutManifest(repoPath, reference, d.MediaType, data)
+}
+
+// pullToLayout pulls image tagged from registry into the OCI layout passed, unless layout already has it
+func (api *API) pullToLayout(l *layout.Layout, repo *repository.Repository, tg *tag.Tag) error {
+	refName := layout.RefName(repo.Name(), tg.Name())
+
+	if d, err := l.Resolve(refName); err == nil && d.Digest == tg.GetDigest() {
+		log.Debugf("%s already present in OCI layout: %s", fn(), refName)
+
+		return nil
+	}
+
+	username, password := api.credentials(repo.Registry(), api.dockerClient.Config())
+
+	cli, err := api.remote.NewClient(repo, username, password)
+	if err != nil {
+		return err
+	}
+
+	d, err := copyToLayout(cli, repo.Path(), tg.Name(), l)
+	if err != nil {
+		return err
+	}
+
+	var created time.Time
+	if tg.GetCreated() > 0 {
+		created = time.Unix(tg.GetCreated(), 0)
+	}
+
+	return l.Tag(refName, d, created)
+}
+
+// pushFromLayout pushes image from the OCI layout passed to the destination reference (REGISTRY/PATH:TAG)
+func (api *API) pushFromLayout(l *layout.Layout, srcRef, dstRef string, push PushConfig) error {
+	d, err := l.Resolve(srcRef)
+	if err != nil {
+		return err
+	}
+
+	dstRepo, err := repository.ParseRef(dstRef)
+	if err != nil {
+		return err
+	}
+	if !dstRepo.IsSingle() || len(dstRepo.Tags()) != 1 {
+		return fmt.Errorf("invalid push destination: %s", dstRef)
+	}
+
+	pushDockerClient, err := api.pushDockerClient(push)
+	if err != nil {
+		return err
+	}
+
+	username, password := api.credentials(dstRepo.Registry(), pushDockerClient.Config())
+
+	cli, err := api.remote.NewClient(dstRepo, username, password)
+	if err != nil {
+		return err
+	}
+
+	return copyFromLayout(l, *d, cli, dstRepo.Path(), dstRepo.Tags()[0])
+}
@@ -0,0 +1,469 @@
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ivanilves/lstags/api/v1/registry/client"
+	"github.com/ivanilves/lstags/oci/bundle"
+	"github.com/ivanilves/lstags/oci/layout"
+)
+
+// fakeRegistry is a minimal in-memory Docker registry (V2 API) to test image transfers against
+type fakeRegistry struct {
+	manifests map[string][]byte
+	types     map[string]string
+	blobs     map[string][]byte
+	// do not send "Docker-Content-Digest" header (make client calculate digest by itself)
+	noDigestHeader bool
+	// tag pushed manifests with altered content (as if tags were overwritten right after push)
+	overwriteTags bool
+	// serve manifests only of media types client accepts (as registries do for Docker manifest lists e.g.)
+	negotiate bool
+	// manifest GET and HEAD requests served (and "304 Not Modified" responses)
+	manifestGets  int
+	manifestHeads int
+	notModified   int
+	mux           sync.Mutex
+}
+
+func newFakeRegistry() *fakeRegistry {
+	return &fakeRegistry{
+		manifests: make(map[string][]byte),
+		types:     make(map[string]string),
+		blobs:     make(map[string][]byte),
+	}
+}
+
+func (fr *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fr.mux.Lock()
+	defer fr.mux.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	if path == "" {
+		return
+	}
+
+	switch {
+	case strings.HasSuffix(path, "/tags/list"):
+		repo := strings.TrimSuffix(path, "/tags/list")
+
+		tagNames := make([]string, 0)
+		for key := range fr.manifests {
+			fields := strings.SplitN(key, "@", 2)
+			if fields[0] == repo && !strings.HasPrefix(fields[1], "sha256:") {
+				tagNames = append(tagNames, fields[1])
+			}
+		}
+
+		if len(tagNames) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[{"code":"NAME_UNKNOWN","message":"repository name not known to registry"}]}`))
+			return
+		}
+
+		json.NewEncoder(w).Encode(struct {
+			Name string   `json:"name"`
+			Tags []string `json:"tags"`
+		}{repo, tagNames})
+	case strings.Contains(path, "/manifests/"):
+		key := strings.Replace(path, "/manifests/", "@", 1)
+		repo := strings.Split(key, "@")[0]
+
+		if r.Method == "PUT" {
+			data, _ := ioutil.ReadAll(r.Body)
+			digest := layout.Digest(data)
+
+			for _, k := range []string{key, repo + "@" + digest} {
+				fr.manifests[k] = data
+				fr.types[k] = r.Header.Get("Content-Type")
+			}
+
+			if fr.overwriteTags && !strings.Contains(key, "@sha256:") {
+				fr.manifests[key] = append(data, '\n')
+			}
+
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+
+		switch r.Method {
+		case "GET":
+			fr.manifestGets++
+		case "HEAD":
+			fr.manifestHeads++
+		}
+
+		data, defined := fr.manifests[key]
+		if !defined || (fr.negotiate && !strings.Contains(strings.Join(r.Header["Accept"], ","), fr.types[key])) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		etag := `"` + layout.Digest(data) + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			fr.notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("Content-Type", fr.types[key])
+		if !fr.noDigestHeader {
+			w.Header().Set("Docker-Content-Digest", layout.Digest(data))
+		}
+		w.Header().Set("ETag", etag)
+		w.Write(data)
+	case strings.HasSuffix(path, "/blobs/uploads/"):
+		w.Header().Set("Location", "/v2/"+path+"some-upload-id")
+		w.WriteHeader(http.StatusAccepted)
+	case strings.Contains(path, "/blobs/uploads/"):
+		data, _ := ioutil.ReadAll(r.Body)
+		digest := r.URL.Query().Get("digest")
+
+		if layout.Digest(data) != digest {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		fr.blobs[digest] = data
+		w.WriteHeader(http.StatusCreated)
+	case strings.Contains(path, "/blobs/"):
+		fields := strings.Split(path, "/")
+
+		data, defined := fr.blobs[fields[len(fields)-1]]
+		if !defined {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if r.Method != "HEAD" {
+			w.Write(data)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestLayoutTransfer(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(newFakeRegistry())
+	defer server.Close()
+
+	cli, err := client.New(strings.TrimPrefix(server.URL, "http://"), client.Config{IsInsecure: true})
+	assert.Nil(err)
+	assert.Nil(cli.Login("", ""))
+
+	src, err := layout.Open("../../fixtures/oci/layout")
+	assert.Nil(err)
+
+	dir, err := ioutil.TempDir("", "lstags-oci-layout-")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	dst, err := layout.Open(dir)
+	assert.Nil(err)
+
+	for _, refName := range []string{"quay.io/coreos/flannel:v0.10.0", "quay.io/coreos/flannel:v0.11.0"} {
+		_, tagName, _ := layout.SplitRefName(refName)
+
+		d, err := src.Resolve(refName)
+		assert.Nil(err, refName)
+
+		err = copyFromLayout(src, *d, cli, "lstags/flannel", tagName)
+		assert.Nil(err, refName)
+
+		copied, err := copyToLayout(cli, "lstags/flannel", tagName, dst)
+		assert.Nil(err, refName)
+		assert.Equal(d.Digest, copied.Digest, refName)
+		assert.Equal(d.MediaType, copied.MediaType, refName)
+		assert.Equal(d.Size, copied.Size, refName)
+		assert.True(dst.HasBlob(copied.Digest), refName)
+	}
+
+	_, err = copyToLayout(cli, "lstags/flannel", "nonexistent", dst)
+	assert.NotNil(err)
+}
+
+func TestImportTags(t *testing.T) {
+	assert := assert.New(t)
+
+	registry := newFakeRegistry()
+
+	server := httptest.NewServer(registry)
+	defer server.Close()
+
+	src, err := layout.Open("../../fixtures/oci/layout")
+	assert.Nil(err)
+
+	f, err := ioutil.TempFile("", "lstags-bundle-")
+	assert.Nil(err)
+	defer os.Remove(f.Name())
+
+	_, err = bundle.Export(f, src, []string{"quay.io/coreos/flannel:v0.11.0", "alpine:3.7"})
+	assert.Nil(err)
+	f.Close()
+
+	api, err := New(Config{})
+	assert.Nil(err)
+
+	push := PushConfig{
+		Registry:      strings.TrimPrefix(server.URL, "http://"),
+		Prefix:        "/mirror/",
+		PathSeparator: "/",
+		PathTemplate:  "{{ .Prefix }}{{ .Path }}",
+		TagTemplate:   "{{ .Tag }}-imported",
+	}
+
+	assert.Nil(api.ImportTags(f.Name(), push))
+
+	for key, refName := range map[string]string{
+		"mirror/coreos/flannel@v0.11.0-imported": "quay.io/coreos/flannel:v0.11.0",
+		"mirror/library/alpine@3.7-imported":     "alpine:3.7",
+	} {
+		d, err := src.Resolve(refName)
+		assert.Nil(err, refName)
+
+		data, defined := registry.manifests[key]
+		if assert.True(defined, key) {
+			assert.Equal(d.Digest, layout.Digest(data), key)
+		}
+	}
+
+	assert.NotNil(api.ImportTags("/i/do/not/exist/sorry", push))
+	assert.NotNil(api.ImportTags(f.Name(), PushConfig{}))
+}
+
+func TestImportTags_ProtectedTags(t *testing.T) {
+	assert := assert.New(t)
+
+	registry := newFakeRegistry()
+
+	server := httptest.NewServer(registry)
+	defer server.Close()
+
+	cli, err := client.New(strings.TrimPrefix(server.URL, "http://"), client.Config{IsInsecure: true})
+	assert.Nil(err)
+	assert.Nil(cli.Login("", ""))
+
+	src, err := layout.Open("../../fixtures/oci/layout")
+	assert.Nil(err)
+
+	f, err := ioutil.TempFile("", "lstags-bundle-")
+	assert.Nil(err)
+	defer os.Remove(f.Name())
+
+	_, err = bundle.Export(f, src, []string{"quay.io/coreos/flannel:v0.10.0", "quay.io/coreos/flannel:v0.11.0"})
+	assert.Nil(err)
+	f.Close()
+
+	// both tags are already pushed, but have different images there
+	other, err := src.Resolve("alpine:3.7")
+	assert.Nil(err)
+	for _, tagName := range []string{"v0.10.0", "v0.11.0"} {
+		assert.Nil(copyFromLayout(src, *other, cli, "mirror/coreos/flannel", tagName))
+	}
+
+	api, err := New(Config{})
+	assert.Nil(err)
+
+	recorder := &eventRecorder{}
+	api.Subscribe(recorder.handle)
+
+	push := PushConfig{
+		Registry:      strings.TrimPrefix(server.URL, "http://"),
+		Prefix:        "/mirror/",
+		PathSeparator: "/",
+		PathTemplate:  "{{ .Prefix }}{{ .Path }}",
+		TagTemplate:   "{{ .Tag }}",
+		UpdateChanged: true,
+		ProtectedTags: `^v0\.10\.`,
+	}
+
+	pushedDigest := func(tagName string) string {
+		digest, err := cli.Digest("mirror/coreos/flannel", tagName)
+		assert.Nil(err, tagName)
+
+		return digest
+	}
+
+	err = api.ImportTags(f.Name(), push)
+	assert.True(errors.Is(err, ErrTagConflict), "%v", err)
+
+	var conflictErr *ConflictError
+	if assert.True(errors.As(err, &conflictErr)) && assert.Equal(1, len(conflictErr.Conflicts)) {
+		assert.Equal("quay.io/coreos/flannel:v0.10.0", conflictErr.Conflicts[0].SourceRef)
+	}
+	assert.Equal(1, len(recorder.ofType(EventError)))
+
+	assert.Equal(other.Digest, pushedDigest("v0.10.0"), "should not overwrite protected tag")
+
+	d, err := src.Resolve("quay.io/coreos/flannel:v0.11.0")
+	assert.Nil(err)
+	assert.Equal(d.Digest, pushedDigest("v0.11.0"), "should update changed tag, if it is not protected")
+
+	// changed tags are not updated, unless we are told to do so
+	assert.Nil(copyFromLayout(src, *other, cli, "mirror/coreos/flannel", "v0.11.0"))
+
+	push.ProtectedTags = ""
+	push.UpdateChanged = false
+
+	assert.Nil(api.ImportTags(f.Name(), push))
+	assert.Equal(other.Digest, pushedDigest("v0.11.0"))
+	assert.Equal(other.Digest, pushedDigest("v0.10.0"))
+}
+
+func TestImportTags_ProtectedTags_TagTemplate(t *testing.T) {
+	assert := assert.New(t)
+
+	registry := newFakeRegistry()
+
+	server := httptest.NewServer(registry)
+	defer server.Close()
+
+	cli, err := client.New(strings.TrimPrefix(server.URL, "http://"), client.Config{IsInsecure: true})
+	assert.Nil(err)
+	assert.Nil(cli.Login("", ""))
+
+	src, err := layout.Open("../../fixtures/oci/layout")
+	assert.Nil(err)
+
+	f, err := ioutil.TempFile("", "lstags-bundle-")
+	assert.Nil(err)
+	defer os.Remove(f.Name())
+
+	_, err = bundle.Export(f, src, []string{"quay.io/coreos/flannel:v0.11.0"})
+	assert.Nil(err)
+	f.Close()
+
+	other, err := src.Resolve("alpine:3.7")
+	assert.Nil(err)
+	assert.Nil(copyFromLayout(src, *other, cli, "mirror/coreos/flannel", "release-v0.11.0"))
+
+	api, err := New(Config{})
+	assert.Nil(err)
+
+	push := PushConfig{
+		Registry:      strings.TrimPrefix(server.URL, "http://"),
+		Prefix:        "/mirror/",
+		PathSeparator: "/",
+		PathTemplate:  "{{ .Prefix }}{{ .Path }}",
+		TagTemplate:   "release-{{ .Tag }}",
+		UpdateChanged: true,
+		ProtectedTags: `^release-`,
+	}
+
+	err = api.ImportTags(f.Name(), push)
+	assert.True(errors.Is(err, ErrTagConflict), "should match protected tags expression against tags pushed: %v", err)
+
+	digest, err := cli.Digest("mirror/coreos/flannel", "release-v0.11.0")
+	assert.Nil(err)
+	assert.Equal(other.Digest, digest, "should not overwrite protected tag")
+}
+
+func TestImportTags_VerificationFailed(t *testing.T) {
+	assert := assert.New(t)
+
+	registry := newFakeRegistry()
+	registry.overwriteTags = true
+
+	server := httptest.NewServer(registry)
+	defer server.Close()
+
+	src, err := layout.Open("../../fixtures/oci/layout")
+	assert.Nil(err)
+
+	f, err := ioutil.TempFile("", "lstags-bundle-")
+	assert.Nil(err)
+	defer os.Remove(f.Name())
+
+	_, err = bundle.Export(f, src, []string{"alpine:3.7"})
+	assert.Nil(err)
+	f.Close()
+
+	api, err := New(Config{})
+	assert.Nil(err)
+
+	push := PushConfig{
+		Registry:      strings.TrimPrefix(server.URL, "http://"),
+		Prefix:        "/mirror/",
+		PathSeparator: "/",
+		PathTemplate:  "{{ .Prefix }}{{ .Path }}",
+		TagTemplate:   "{{ .Tag }}",
+	}
+
+	err = api.ImportTags(f.Name(), push)
+	assert.True(errors.Is(err, ErrDigestMismatch), "%v", err)
+}
+
+func TestImportTags_RepoOverrides(t *testing.T) {
+	assert := assert.New(t)
+
+	registry, overrideRegistry := newFakeRegistry(), newFakeRegistry()
+
+	server := httptest.NewServer(registry)
+	defer server.Close()
+
+	overrideServer := httptest.NewServer(overrideRegistry)
+	defer overrideServer.Close()
+
+	src, err := layout.Open("../../fixtures/oci/layout")
+	assert.Nil(err)
+
+	f, err := ioutil.TempFile("", "lstags-bundle-")
+	assert.Nil(err)
+	defer os.Remove(f.Name())
+
+	_, err = bundle.Export(f, src, []string{"quay.io/coreos/flannel:v0.10.0", "quay.io/coreos/flannel:v0.11.0", "alpine:3.7"})
+	assert.Nil(err)
+	f.Close()
+
+	api, err := New(Config{})
+	assert.Nil(err)
+
+	override := func(tagTemplate string) PushConfig {
+		return PushConfig{
+			Registry:      strings.TrimPrefix(overrideServer.URL, "http://"),
+			Prefix:        "/mirror/",
+			PathSeparator: "/",
+			PathTemplate:  "{{ .Prefix }}{{ .Path }}",
+			TagTemplate:   tagTemplate,
+		}
+	}
+
+	push := PushConfig{
+		Registry:      strings.TrimPrefix(server.URL, "http://"),
+		Prefix:        "/mirror/",
+		PathSeparator: "/",
+		PathTemplate:  "{{ .Prefix }}{{ .Path }}",
+		TagTemplate:   "{{ .Tag }}",
+		RepoOverrides: map[string]PushConfig{
+			"quay.io/coreos/flannel=v0.11.0":                    override("{{ .Tag }}-pinned"),
+			`quay.io/coreos/flannel~/^v0\.(?P<minor>[0-9]+)\./`: override(`minor-{{ .NamedCaptures.minor }}`),
+		},
+	}
+
+	assert.Nil(api.ImportTags(f.Name(), push))
+
+	for fr, keys := range map[*fakeRegistry][]string{
+		overrideRegistry: {"mirror/coreos/flannel@v0.11.0-pinned", "mirror/coreos/flannel@minor-10"},
+		registry:         {"mirror/library/alpine@3.7"},
+	} {
+		for _, key := range keys {
+			_, defined := fr.manifests[key]
+			assert.True(defined, key)
+		}
+	}
+
+	_, defined := registry.manifests["mirror/coreos/flannel@v0.10.0"]
+	assert.False(defined, "should not push image to the global push registry, if repository override matches it")
+}
@@ -0,0 +1,234 @@
+package v1
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ivanilves/lstags/api/v1/collection"
+	"github.com/ivanilves/lstags/api/v1/plan"
+	"github.com/ivanilves/lstags/repository"
+	"github.com/ivanilves/lstags/tag"
+	"github.com/ivanilves/lstags/util/pool"
+)
+
+// planEntry makes sync plan entry for the tag passed (tag state is expected to be relative to the "push" registry)
+func planEntry(srcRef, dstRef string, tg *tag.Tag, updateChanged bool) plan.Entry {
+	e := plan.Entry{SourceRef: srcRef, SourceDigest: tg.GetDigest(), DestinationRef: dstRef}
+
+	switch tg.GetState() {
+	case "ABSENT":
+		e.Action, e.Reason = plan.Push, "absent in destination"
+	case "CHANGED":
+		if updateChanged {
+			e.Action, e.Reason = plan.Update, "changed in destination"
+		} else {
+			e.Action, e.Reason = plan.Skip, "changed in destination, but update is not enabled"
+		}
+	case "CONFLICT":
+		e.Action, e.Reason = plan.Conflict, "protected tag changed in destination"
+	case "PRESENT":
+		e.Action, e.Reason = plan.Skip, "present in destination"
+	default:
+		e.SourceDigest = ""
+		e.Action, e.Reason = plan.Skip, "not found in source"
+	}
+
+	return e
+}
+
+// MakePlan compares images of the collection passed with the ones of all "push" registries (targets)
+// and makes a sync plan of what would be pushed (and what would not, with reasons) without pushing anything
+func (api *API) MakePlan(cn *collection.Collection, pushes []PushConfig) (*plan.Plan, error) {
+	entries := make([]plan.Entry, 0)
+
+	for _, push := range pushes {
+		_, joined, err := api.joinPushTags(cn, push)
+		if err != nil {
+			return nil, fmt.Errorf("push target '%s': %s", push.Registry, err.Error())
+		}
+
+		for _, j := range joined {
+			if cn.Err(j.ref) != nil {
+				continue
+			}
+
+			repo := cn.Repo(j.ref)
+			push := push.ForRef(j.ref)
+
+			makeDstRef, err := makePushRefMaker(push)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, tg := range j.tags {
+				if tg.GetState() == "LOCAL_ONLY" {
+					continue
+				}
+
+				dstRef, err := makeDstRef(api.templateContext(repo, tg))
+				if err != nil {
+					return nil, err
+				}
+
+				entries = append(entries, planEntry(repo.Name()+":"+tg.Name(), dstRef, tg, push.UpdateChanged))
+			}
+		}
+	}
+
+	return plan.New(entries), nil
+}
+
+// pushConfigFor gets push configuration for the destination registry passed from the ones passed
+// (including per-repository overrides), defaults to the bare configuration with no extra settings
+func pushConfigFor(pushes []PushConfig, registry string) PushConfig {
+	for _, push := range pushes {
+		if push.Registry == registry {
+			return push
+		}
+
+		for _, override := range push.RepoOverrides {
+			if override.Registry == registry {
+				return override
+			}
+		}
+	}
+
+	return PushConfig{Registry: registry}
+}
+
+// ApplyPlan executes sync plan passed: pushes exactly the images planned to exactly the destinations planned,
+// refusing entries whose source digest has moved since planning (push configurations are used for credentials and cleanup)
+// NB! If some entries are refused, the rest of them are still applied, but *ApplyError is returned.
+// Entries we failed to collect source tags for are not applied, collect errors are returned along with push ones.
+func (api *API) ApplyPlan(p *plan.Plan, pushes []PushConfig) error {
+	srcTagNames := make(map[string][]string)
+	srcNames := make([]string, 0)
+
+	entries := make([]plan.Entry, 0)
+	for _, e := range p.Entries {
+		if !e.IsActionable() {
+			continue
+		}
+
+		repo, err := repository.ParseRef(e.SourceRef)
+		if err != nil {
+			return err
+		}
+		if !repo.IsSingle() || len(repo.Tags()) != 1 {
+			return fmt.Errorf("invalid source of sync plan entry: %s", e.SourceRef)
+		}
+
+		if _, defined := srcTagNames[repo.Name()]; !defined {
+			srcNames = append(srcNames, repo.Name())
+		}
+		srcTagNames[repo.Name()] = append(srcTagNames[repo.Name()], repo.Tags()[0])
+
+		entries = append(entries, e)
+	}
+
+	if len(entries) == 0 {
+		log.Infof("%s Nothing to apply", fn())
+		return nil
+	}
+
+	refs := make([]string, len(srcNames))
+	for i, name := range srcNames {
+		refs[i] = name + "=" + strings.Join(srcTagNames[name], ",")
+	}
+
+	cn, err := api.CollectTags(refs...)
+	if cn == nil {
+		return err
+	}
+
+	refByName := make(map[string]string)
+	for _, ref := range cn.Refs() {
+		refByName[cn.Repo(ref).Name()] = ref
+	}
+
+	sources := make(map[string]*pushSource)
+	srcs := make([]*pushSource, 0)
+	refused := make([]plan.Entry, 0)
+	errs := make(pool.Errors, 0)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	for _, e := range entries {
+		repo, _ := repository.ParseRef(e.SourceRef)
+		ref := refByName[repo.Name()]
+
+		if collectErr := cn.Err(ref); collectErr != nil {
+			log.Warnf("[APPLY] FAILED %s => %s: %s", e.SourceRef, e.DestinationRef, collectErr.Error())
+
+			continue
+		}
+
+		tg := cn.TagMap(ref)[repo.Tags()[0]]
+
+		digest := "none"
+		if tg != nil && tg.GetState() != "NOT_FOUND" && tg.GetState() != "LOCAL_ONLY" {
+			digest = tg.GetDigest()
+		}
+
+		if digest != e.SourceDigest {
+			log.Warnf("[APPLY] REFUSED %s => %s: source digest moved (planned: %s, got: %s)", e.SourceRef, e.DestinationRef, e.SourceDigest, digest)
+
+			api.emit(Event{
+				Type:        EventError,
+				Ref:         e.SourceRef,
+				Tag:         repo.Tags()[0],
+				Digest:      e.SourceDigest,
+				Destination: e.DestinationRef,
+				Err:         fmt.Errorf("%w (planned: %s, got: %s)", ErrDigestMoved, e.SourceDigest, digest),
+			})
+
+			refused = append(refused, e)
+
+			continue
+		}
+
+		dstRepo, err := repository.ParseRef(e.DestinationRef)
+		if err != nil {
+			return err
+		}
+
+		push := pushConfigFor(pushes, dstRepo.Registry())
+
+		pushDockerClient, err := api.pushDockerClient(push)
+		if err != nil {
+			return err
+		}
+
+		src, defined := sources[e.SourceRef]
+		if !defined {
+			src = &pushSource{repo: cn.Repo(ref), tag: tg}
+
+			sources[e.SourceRef] = src
+			srcs = append(srcs, src)
+		}
+
+		src.dsts = append(src.dsts, pushDestination{ref: e.DestinationRef, tag: tg, push: push, dockerClient: pushDockerClient})
+	}
+
+	if err := api.pushSources(srcs); err != nil {
+		errs = append(errs, err)
+	}
+
+	switch len(errs) {
+	case 0:
+		err = nil
+	case 1:
+		err = errs[0]
+	default:
+		err = errs
+	}
+
+	if len(refused) != 0 {
+		return &ApplyError{Refused: refused, Err: err}
+	}
+
+	return err
+}
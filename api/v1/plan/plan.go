@@ -0,0 +1,110 @@
+// Package plan provides sync plans: machine-readable lists of images to be pushed (and not to be pushed, with reasons),
+// so mirror changes could be reviewed (e.g. in a pull request) before they are applied.
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/ivanilves/lstags/util/fix"
+)
+
+// Version is a version of the sync plan format
+const Version = 1
+
+// Action is an action to be done with the image
+type Action string
+
+// Actions to be done with images
+const (
+	// Push means image is absent in the destination and will be pushed
+	Push Action = "push"
+	// Update means image is changed in the destination and will be re-pushed (overwritten)
+	Update Action = "update"
+	// Skip means image will not be pushed (see reason)
+	Skip Action = "skip"
+	// Conflict means image will not be pushed, because tag is protected, but has different digest in the destination
+	Conflict Action = "conflict"
+)
+
+// Entry is a single image (source tag) to be synced to a single destination
+type Entry struct {
+	SourceRef      string `json:"source_ref"`
+	SourceDigest   string `json:"source_digest"`
+	DestinationRef string `json:"destination_ref"`
+	Action         Action `json:"action"`
+	Reason         string `json:"reason"`
+}
+
+// IsActionable tells us if entry requires any action (push or update)
+func (e Entry) IsActionable() bool {
+	return e.Action == Push || e.Action == Update
+}
+
+// Plan is a sync plan
+type Plan struct {
+	Version int     `json:"version"`
+	Entries []Entry `json:"entries"`
+}
+
+// New creates new sync plan with entries passed
+func New(entries []Entry) *Plan {
+	if entries == nil {
+		entries = []Entry{}
+	}
+
+	return &Plan{Version: Version, Entries: entries}
+}
+
+// Count gets number of entries with action passed
+func (p *Plan) Count(action Action) int {
+	count := 0
+
+	for _, e := range p.Entries {
+		if e.Action == action {
+			count++
+		}
+	}
+
+	return count
+}
+
+// Save writes plan into the file passed (as an indented JSON, to be human-readable and diff-friendly)
+func (p *Plan) Save(fileName string) error {
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(fix.Path(fileName), append(b, '\n'), 0644)
+}
+
+// Load loads plan from the file passed
+func Load(fileName string) (*Plan, error) {
+	b, err := ioutil.ReadFile(fix.Path(fileName))
+	if err != nil {
+		return nil, err
+	}
+
+	var p Plan
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("invalid sync plan '%s': %s", fileName, err.Error())
+	}
+
+	if p.Version != Version {
+		return nil, fmt.Errorf("unsupported sync plan version: %d (expected: %d)", p.Version, Version)
+	}
+
+	for i, e := range p.Entries {
+		if e.Action != Push && e.Action != Update && e.Action != Skip && e.Action != Conflict {
+			return nil, fmt.Errorf("invalid action of sync plan entry #%d: %s", i+1, e.Action)
+		}
+
+		if e.IsActionable() && (e.SourceRef == "" || e.SourceDigest == "" || e.DestinationRef == "") {
+			return nil, fmt.Errorf("sync plan entry #%d should have source reference, source digest and destination reference", i+1)
+		}
+	}
+
+	return &p, nil
+}
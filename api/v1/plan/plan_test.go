@@ -0,0 +1,68 @@
+package plan
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaveAndLoad(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "lstags-plan-")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "plan.json")
+
+	p := New([]Entry{
+		{"alpine:3.7", "sha256:abc", "registry.company.io/library/alpine:3.7", Push, "absent in destination"},
+		{"alpine:3.8", "sha256:def", "registry.company.io/library/alpine:3.8", Update, "changed in destination"},
+		{"alpine:3.9", "sha256:123", "registry.company.io/library/alpine:3.9", Skip, "present in destination"},
+	})
+
+	assert.Equal(1, p.Count(Push))
+	assert.Equal(1, p.Count(Update))
+	assert.Equal(1, p.Count(Skip))
+
+	assert.Nil(p.Save(fileName))
+
+	loaded, err := Load(fileName)
+	assert.Nil(err)
+	assert.Equal(p, loaded)
+
+	assert.True(loaded.Entries[0].IsActionable())
+	assert.True(loaded.Entries[1].IsActionable())
+	assert.False(loaded.Entries[2].IsActionable())
+}
+
+func TestLoadInvalid(t *testing.T) {
+	var testCases = map[string]string{
+		"not a JSON":     `not a JSON`,
+		"wrong version":  `{"version": 42, "entries": []}`,
+		"unknown action": `{"version": 1, "entries": [{"source_ref": "alpine:3.7", "source_digest": "sha256:abc", "destination_ref": "registry.company.io/alpine:3.7", "action": "delete"}]}`,
+		"no digest":      `{"version": 1, "entries": [{"source_ref": "alpine:3.7", "destination_ref": "registry.company.io/alpine:3.7", "action": "push"}]}`,
+	}
+
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "lstags-plan-")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	for name, data := range testCases {
+		fileName := filepath.Join(dir, "plan.json")
+
+		assert.Nil(ioutil.WriteFile(fileName, []byte(data), 0644))
+
+		p, err := Load(fileName)
+		assert.Nil(p, name)
+		assert.NotNil(err, name)
+	}
+
+	_, err = Load(filepath.Join(dir, "nonexistent.json"))
+	assert.NotNil(err)
+}
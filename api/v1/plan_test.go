@@ -0,0 +1,212 @@
+package v1
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ivanilves/lstags/api/v1/plan"
+)
+
+func TestPlanAndApply(t *testing.T) {
+	assert := assert.New(t)
+
+	src := newFakeRegistry()
+
+	server := httptest.NewServer(src)
+	defer server.Close()
+
+	registry := strings.TrimPrefix(server.URL, "http://")
+
+	seedFakeRegistry(t, registry)
+
+	dst := newFakeRegistry()
+
+	dstServer := httptest.NewServer(dst)
+	defer dstServer.Close()
+
+	push := PushConfig{
+		Registry:      strings.TrimPrefix(dstServer.URL, "http://"),
+		Prefix:        "/mirror",
+		PathSeparator: "/",
+		PathTemplate:  "{{ .Prefix }}{{ .Path }}",
+		TagTemplate:   "{{ .Tag }}",
+	}
+
+	dir, err := ioutil.TempDir("", "lstags-plan-")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	api, err := New(Config{OCILayoutDir: filepath.Join(dir, "oci")})
+	assert.Nil(err)
+
+	ref := registry + "/lstags/flannel"
+	dstRepoRef := push.Registry + "/mirror/lstags/flannel"
+
+	makePlan := func(push PushConfig) *plan.Plan {
+		cn, err := api.CollectTags(ref)
+		assert.Nil(err)
+
+		p, err := api.MakePlan(cn, []PushConfig{push})
+		assert.Nil(err)
+
+		return p
+	}
+
+	p := makePlan(push)
+	if assert.Len(p.Entries, 2) {
+		for i, tagName := range []string{"v0.10.0", "v0.11.0"} {
+			assert.Equal(ref+":"+tagName, p.Entries[i].SourceRef)
+			assert.Equal(dstRepoRef+":"+tagName, p.Entries[i].DestinationRef)
+			assert.Equal(plan.Push, p.Entries[i].Action)
+			assert.NotEmpty(p.Entries[i].SourceDigest)
+		}
+	}
+
+	planFile := filepath.Join(dir, "plan.json")
+	assert.Nil(p.Save(planFile))
+
+	p, err = plan.Load(planFile)
+	assert.Nil(err)
+
+	// move "v0.11.0" tag in the source registry after planning
+	src.mux.Lock()
+	original := src.manifests["lstags/flannel@v0.11.0"]
+	src.manifests["lstags/flannel@v0.11.0"] = src.manifests["lstags/flannel@v0.10.0"]
+	src.mux.Unlock()
+
+	err = api.ApplyPlan(p, []PushConfig{push})
+
+	assert.True(errors.Is(err, ErrDigestMoved), "%v", err)
+
+	var applyErr *ApplyError
+	if assert.True(errors.As(err, &applyErr)) {
+		if assert.Len(applyErr.Refused, 1) {
+			assert.Equal(ref+":v0.11.0", applyErr.Refused[0].SourceRef)
+		}
+		assert.Nil(applyErr.Err)
+	}
+
+	dst.mux.Lock()
+	_, isPushed := dst.manifests["mirror/lstags/flannel@v0.10.0"]
+	assert.True(isPushed, "should apply entries with source digest unchanged")
+	_, isPushed = dst.manifests["mirror/lstags/flannel@v0.11.0"]
+	assert.False(isPushed, "should refuse entries with source digest moved")
+	dst.mux.Unlock()
+
+	p = makePlan(push)
+	if assert.Len(p.Entries, 2) {
+		assert.Equal(plan.Skip, p.Entries[0].Action)
+		assert.Equal("present in destination", p.Entries[0].Reason)
+		assert.Equal(plan.Push, p.Entries[1].Action)
+	}
+
+	assert.Nil(api.ApplyPlan(p, []PushConfig{push}))
+
+	// move "v0.11.0" tag back, so it becomes changed in destination
+	src.mux.Lock()
+	src.manifests["lstags/flannel@v0.11.0"] = original
+	src.mux.Unlock()
+
+	p = makePlan(push)
+	if assert.Len(p.Entries, 2) {
+		assert.Equal(plan.Skip, p.Entries[1].Action)
+		assert.Equal("changed in destination, but update is not enabled", p.Entries[1].Reason)
+		assert.Equal(2, p.Count(plan.Skip))
+	}
+
+	push.UpdateChanged = true
+
+	p = makePlan(push)
+	if assert.Len(p.Entries, 2) {
+		assert.Equal(plan.Update, p.Entries[1].Action)
+	}
+}
+
+func TestApplyPlan_CollectErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	src := newFakeRegistry()
+
+	server := httptest.NewServer(src)
+	defer server.Close()
+
+	registry := strings.TrimPrefix(server.URL, "http://")
+
+	seedFakeRegistry(t, registry)
+
+	dst := newFakeRegistry()
+
+	dstServer := httptest.NewServer(dst)
+	defer dstServer.Close()
+
+	push := PushConfig{
+		Registry:      strings.TrimPrefix(dstServer.URL, "http://"),
+		Prefix:        "/mirror",
+		PathSeparator: "/",
+		PathTemplate:  "{{ .Prefix }}{{ .Path }}",
+		TagTemplate:   "{{ .Tag }}",
+	}
+
+	dir, err := ioutil.TempDir("", "lstags-plan-")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	api, err := New(Config{OCILayoutDir: filepath.Join(dir, "oci")})
+	assert.Nil(err)
+
+	ref := registry + "/lstags/flannel"
+
+	cn, err := api.CollectTags(ref)
+	assert.Nil(err)
+
+	p, err := api.MakePlan(cn, []PushConfig{push})
+	assert.Nil(err)
+
+	unavailableServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unavailableServer.Close()
+
+	unavailableRef := strings.TrimPrefix(unavailableServer.URL, "http://") + "/lstags/flannel:v0.10.0"
+
+	// the source below becomes unavailable after planning, so it could not be collected while applying the plan
+	p.Entries = append(p.Entries, plan.Entry{
+		SourceRef:      unavailableRef,
+		SourceDigest:   p.Entries[0].SourceDigest,
+		DestinationRef: push.Registry + "/mirror/unavailable/lstags/flannel:v0.10.0",
+		Action:         plan.Push,
+	})
+
+	events := &eventRecorder{}
+	unsubscribe := api.Subscribe(events.handle)
+	defer unsubscribe()
+
+	err = api.ApplyPlan(p, []PushConfig{push})
+
+	assert.NotNil(err)
+	assert.False(errors.Is(err, ErrDigestMoved), "should not refuse entries not collected: %v", err)
+	assert.True(errors.Is(err, ErrServer), "should return collect error: %v", err)
+
+	var collectErr *CollectError
+	assert.True(errors.As(err, &collectErr), "%v", err)
+
+	if failed := events.ofType(EventError); assert.Len(failed, 1) {
+		assert.True(errors.Is(failed[0].Err, ErrServer), "%v", failed[0].Err)
+	}
+
+	dst.mux.Lock()
+	defer dst.mux.Unlock()
+
+	for _, tagName := range []string{"v0.10.0", "v0.11.0"} {
+		_, isPushed := dst.manifests["mirror/lstags/flannel@"+tagName]
+		assert.True(isPushed, "should apply entries collected: %s", tagName)
+	}
+}
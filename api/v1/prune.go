@@ -0,0 +1,154 @@
+package v1
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ivanilves/lstags/api/v1/collection"
+	"github.com/ivanilves/lstags/oci/layout"
+	"github.com/ivanilves/lstags/repository"
+	"github.com/ivanilves/lstags/tag"
+	"github.com/ivanilves/lstags/tag/local"
+)
+
+// PruneConfig holds rules to prune (remove) local images by
+// NB! Images are never removed, unless some rule explicitly makes them removable
+type PruneConfig struct {
+	// KeepNewest is a number of the newest local images (per repository) we always keep (0 means "no such rule")
+	KeepNewest int
+	// KeepUpstream tells us to always keep images present in registry with the same digest (PRESENT),
+	// stale images having different digest in registry (CHANGED) are not kept by this rule
+	KeepUpstream bool
+	// RemoveLocalOnly tells us to remove images absent in registry (LOCAL_ONLY)
+	RemoveLocalOnly bool
+	// RemoveChanged tells us to remove stale images, having different digest in registry (CHANGED)
+	RemoveChanged bool
+}
+
+// isRemovable tells us if tag is removable by its state (regardless of "keep newest" rule)
+func (prune PruneConfig) isRemovable(tg *tag.Tag) bool {
+	switch tg.GetState() {
+	case "PRESENT":
+		return prune.KeepNewest > 0 && !prune.KeepUpstream
+	case "CHANGED":
+		return prune.RemoveChanged || prune.KeepNewest > 0
+	case "LOCAL_ONLY":
+		return prune.RemoveLocalOnly || prune.KeepNewest > 0
+	}
+
+	return false
+}
+
+// selectPruneTags selects tags to be pruned from the ones passed, according to the prune rules
+func selectPruneTags(tags []*tag.Tag, prune PruneConfig) []*tag.Tag {
+	localTags := make([]*tag.Tag, 0)
+	for _, tg := range tags {
+		switch tg.GetState() {
+		case "PRESENT", "CHANGED", "LOCAL_ONLY":
+			localTags = append(localTags, tg)
+		}
+	}
+
+	// newest first
+	sort.SliceStable(localTags, func(i, j int) bool {
+		return localTags[i].SortKey() > localTags[j].SortKey()
+	})
+
+	pruneTags := make([]*tag.Tag, 0)
+	for i, tg := range localTags {
+		if i < prune.KeepNewest {
+			continue
+		}
+
+		if prune.isRemovable(tg) {
+			pruneTags = append(pruneTags, tg)
+		}
+	}
+
+	return pruneTags
+}
+
+// PruneTags removes local images (from Docker daemon or OCI layout) of the collection passed,
+// according to the prune rules passed
+func (api *API) PruneTags(cn *collection.Collection, prune PruneConfig) error {
+	log.Debugf(
+		"%s collection: %+v (%d repos / %d tags)",
+		fn(), cn, cn.RepoCount(), cn.TagCount(),
+	)
+	log.Debugf("%s prune config: %+v", fn(), prune)
+
+	errMessages := make([]string, 0)
+
+	for _, ref := range cn.Refs() {
+		repo := cn.Repo(ref)
+
+		for _, tg := range selectPruneTags(cn.Tags(ref), prune) {
+			refName := layout.RefName(repo.Name(), tg.Name())
+
+			log.Infof("PRUNING %s [%s]", refName, tg.GetState())
+			if api.config.DryRun {
+				log.Infof("[DRY-RUN] PRUNED %s", refName)
+				continue
+			}
+
+			if err := api.removeLocal(refName); err != nil {
+				errMessages = append(errMessages, err.Error())
+			}
+		}
+	}
+
+	if len(errMessages) != 0 {
+		return errors.New(strings.Join(errMessages, "\n"))
+	}
+
+	return nil
+}
+
+// removeLocal removes local image (from Docker daemon or OCI layout) having the reference name passed
+func (api *API) removeLocal(refName string) error {
+	if api.layout != nil {
+		return api.layout.Untag(refName)
+	}
+
+	return api.dockerClient.RemoveImage(refName)
+}
+
+// fetchLocalTags fetches tags present locally (in Docker daemon or OCI layout) for the repository passed
+func (api *API) fetchLocalTags(repo *repository.Repository) map[string]*tag.Tag {
+	var localTags map[string]*tag.Tag
+	var err error
+
+	if api.layout != nil {
+		localTags, err = local.FetchLayoutTags(repo, api.layout)
+	} else {
+		localTags, err = local.FetchTags(repo, api.dockerClient)
+	}
+	if err != nil {
+		log.Warnf("%s unable to fetch local tags for '%s': %s", fn(), repo.Ref(), err.Error())
+
+		return make(map[string]*tag.Tag)
+	}
+
+	return localTags
+}
+
+// cleanupPushed removes local tags created to push image (if any) and the source image itself,
+// if it was pulled solely to be pushed (cleanup failures are not critical, so we only log them)
+func (api *API) cleanupPushed(srcRef string, dstRefs []string, wasPulled bool) {
+	refNames := make([]string, 0, len(dstRefs)+1)
+	refNames = append(refNames, dstRefs...)
+	if wasPulled {
+		refNames = append(refNames, srcRef)
+	}
+
+	for _, refName := range refNames {
+		log.Infof("[PULL/PUSH] CLEANUP %s", refName)
+
+		if err := api.removeLocal(refName); err != nil {
+			log.Warnf("%s unable to remove '%s': %s", fn(), refName, err.Error())
+		}
+	}
+}
@@ -1,9 +1,12 @@
 package basic
 
 import (
-	"errors"
+	"fmt"
 	"net/http"
 	"strings"
+
+	"github.com/ivanilves/lstags/api/v1/registry/client/request"
+	"github.com/ivanilves/lstags/util/redact"
 )
 
 // Token implementation for Basic authentication
@@ -32,9 +35,8 @@ func getTokenFromHeader(header string) string {
 	return fields[1]
 }
 
-// RequestToken performs Basic authentication and extracts token from response header
-func RequestToken(url, username, password string) (*Token, error) {
-	hc := &http.Client{}
+// RequestToken performs Basic authentication (using HTTP client passed) and extracts token from response header
+func RequestToken(hc *http.Client, url, username, password string) (*Token, error) {
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
@@ -44,10 +46,12 @@ func RequestToken(url, username, password string) (*Token, error) {
 
 	resp, err := hc.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, &request.TransportError{Method: req.Method, URL: redact.URL(url), Err: err}
 	}
 	if resp.StatusCode != 200 && resp.StatusCode != 403 {
-		return nil, errors.New("[AUTH::BASIC] Bad response status: " + resp.Status + " >> " + url)
+		defer resp.Body.Close()
+
+		return nil, fmt.Errorf("[AUTH::BASIC] %w", request.NewError(req.Method, url, resp))
 	}
 
 	return &Token{T: getTokenFromHeader(req.Header["Authorization"][0])}, nil
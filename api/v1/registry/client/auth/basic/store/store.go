@@ -37,7 +37,12 @@ func (st *Store) LoadAll(aa []string) error {
 }
 
 // GetByHostname gets a BASIC auth login for a registry hostname passed
+// NB! nil *Store is valid and has no logins at all
 func (st *Store) GetByHostname(registryHostname string) *Login {
+	if st == nil {
+		return nil
+	}
+
 	login, defined := st.logins[registryHostname]
 	if !defined {
 		return nil
@@ -48,11 +53,25 @@ func (st *Store) GetByHostname(registryHostname string) *Login {
 
 // GetByURL gets a BASIC auth login for a registry URL passed
 func (st *Store) GetByURL(registryURL string) *Login {
-	u, _ := url.Parse(registryURL)
+	u, err := url.Parse(registryURL)
+	if err != nil {
+		return nil
+	}
 
 	return st.GetByHostname(u.Host)
 }
 
+// GetCredentials gets BASIC auth username and password for a registry hostname passed
+// (makes store usable as a credentials provider, see "docker/config" package)
+func (st *Store) GetCredentials(registryHostname string) (string, string, bool) {
+	login := st.GetByHostname(registryHostname)
+	if login == nil {
+		return "", "", false
+	}
+
+	return login.Username, login.Password, true
+}
+
 func loadOne(a string) (string, *Login, error) {
 	const format = "REGISTRY[:PORT] username:password"
 
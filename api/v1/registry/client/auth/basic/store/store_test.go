@@ -53,3 +53,27 @@ func TestGetValues(t *testing.T) {
 	assert.Equal(t, login2.Username, "quser")
 	assert.Equal(t, login2.Password, "qpass")
 }
+
+func TestGetCredentials(t *testing.T) {
+	var store Store
+
+	store.LoadAll(examples)
+
+	username, password, defined := store.GetCredentials("quay.io")
+	assert.True(t, defined)
+	assert.Equal(t, "quser", username)
+	assert.Equal(t, "qpass", password)
+
+	_, _, defined = store.GetCredentials("eu.gcr.io")
+	assert.False(t, defined)
+}
+
+func TestNilStore(t *testing.T) {
+	var store *Store
+
+	assert.Nil(t, store.GetByHostname("quay.io"))
+	assert.Nil(t, store.GetByURL("https://quay.io"))
+
+	_, _, defined := store.GetCredentials("quay.io")
+	assert.False(t, defined)
+}
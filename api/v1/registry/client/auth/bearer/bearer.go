@@ -2,9 +2,12 @@ package bearer
 
 import (
 	"encoding/json"
-	"errors"
+	"fmt"
 	"io"
 	"net/http"
+
+	"github.com/ivanilves/lstags/api/v1/registry/client/request"
+	"github.com/ivanilves/lstags/util/redact"
 )
 
 // Token implementation for Bearer authentication
@@ -39,11 +42,10 @@ func decodeTokenResponse(data io.ReadCloser) (*Token, error) {
 	return &tk, nil
 }
 
-// RequestToken requests Bearer token from authentication service
-func RequestToken(username, password string, params map[string]string) (*Token, error) {
+// RequestToken requests Bearer token from authentication service (using HTTP client passed)
+func RequestToken(hc *http.Client, username, password string, params map[string]string) (*Token, error) {
 	url := params["realm"] + "?service=" + params["service"] + "&scope=" + params["scope"]
 
-	hc := &http.Client{}
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
@@ -55,10 +57,12 @@ func RequestToken(username, password string, params map[string]string) (*Token,
 
 	resp, err := hc.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, &request.TransportError{Method: req.Method, URL: redact.URL(url), Err: err}
 	}
 	if resp.StatusCode != 200 {
-		return nil, errors.New("[AUTH::BEARER] Bad response status: " + resp.Status + " >> " + url)
+		defer resp.Body.Close()
+
+		return nil, fmt.Errorf("[AUTH::BEARER] %w", request.NewError(req.Method, url, resp))
 	}
 
 	return decodeTokenResponse(resp.Body)
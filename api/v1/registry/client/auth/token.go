@@ -11,11 +11,10 @@ import (
 	basicstore "github.com/ivanilves/lstags/api/v1/registry/client/auth/basic/store"
 	"github.com/ivanilves/lstags/api/v1/registry/client/auth/bearer"
 	"github.com/ivanilves/lstags/api/v1/registry/client/auth/none"
+	"github.com/ivanilves/lstags/api/v1/registry/client/request"
+	"github.com/ivanilves/lstags/util/redact"
 )
 
-// BasicStore stores explicitly set BASIC authorization headers
-var BasicStore basicstore.Store
-
 // Token is an abstraction for aggregated token-related information we get from authentication services
 type Token interface {
 	Method() string
@@ -61,17 +60,19 @@ func getAuthParams(h authHeader) map[string]string {
 // NewToken creates a new instance of Token in two steps:
 // * detects authentication type ("Bearer", "Basic" or "None")
 // * delegates actual authentication to the type-specific implementation
-func NewToken(url, username, password, scope string) (Token, error) {
+// NB! If basic store passed (could be nil) has BASIC auth login for the registry, we use it explicitly
+func NewToken(hc *http.Client, url, username, password, scope string, basicStore *basicstore.Store) (Token, error) {
 	var method = ""
 	var params = make(map[string]string)
 
-	storedBasicAuth := BasicStore.GetByURL(url)
+	storedBasicAuth := basicStore.GetByURL(url)
 
 	if storedBasicAuth == nil {
-		resp, err := http.Get(url)
+		resp, err := hc.Get(url)
 		if err != nil {
-			return nil, err
+			return nil, &request.TransportError{Method: "GET", URL: redact.URL(url), Err: err}
 		}
+		resp.Body.Close()
 
 		authHeader, err := extractAuthHeader(resp.Header["Www-Authenticate"])
 		if err != nil {
@@ -91,7 +92,7 @@ func NewToken(url, username, password, scope string) (Token, error) {
 	case "none":
 		return none.RequestToken()
 	case "basic":
-		t, err := basic.RequestToken(url, username, password)
+		t, err := basic.RequestToken(hc, url, username, password)
 		if err != nil {
 			log.Debug(err.Error())
 
@@ -101,7 +102,7 @@ func NewToken(url, username, password, scope string) (Token, error) {
 		return t, nil
 	case "bearer":
 		params["scope"] = scope
-		return bearer.RequestToken(username, password, params)
+		return bearer.RequestToken(hc, username, password, params)
 	default:
 		return nil, errors.New("Unknown authentication method: " + method)
 	}
@@ -0,0 +1,28 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ivanilves/lstags/api/v1/registry/client/request"
+)
+
+func TestNewToken_TransportError(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL + "/v2/"
+	server.Close()
+
+	_, err := NewToken(http.DefaultClient, url, "", "", "", nil)
+
+	var transportErr *request.TransportError
+	if assert.True(errors.As(err, &transportErr), "%v", err) {
+		assert.Equal("GET", transportErr.Method)
+		assert.Equal(url, transportErr.URL)
+	}
+}
@@ -0,0 +1,200 @@
+package cache
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ivanilves/lstags/util/fix"
+)
+
+// MetadataFile is a name of the file (inside cache directory) we store tag metadata in
+const MetadataFile = "metadata.json"
+
+// DefaultMetadataTTL is a default time we keep tag metadata in cache for since the tag was seen (got or set) last time,
+// so metadata of tags deleted from registries (or repositories we do not sync anymore) is evicted eventually
+const DefaultMetadataTTL = 7 * 24 * time.Hour
+
+// metadataSeenResolution is a precision we track tags seen with (not to rewrite cache every time we see a tag)
+const metadataSeenResolution = time.Hour
+
+// MetadataItem is a tag metadata we store to revalidate tags instead of fetching them from scratch
+type MetadataItem struct {
+	Digest  string `json:"digest"`
+	Created int64  `json:"created,omitempty"`
+	ImageID string `json:"image_id,omitempty"`
+	ETag    string `json:"etag,omitempty"`
+}
+
+// metadataEntry is a tag metadata stored along with the time (Unix timestamp) we have seen the tag last time
+type metadataEntry struct {
+	MetadataItem
+	Seen int64 `json:"seen,omitempty"`
+}
+
+// Metadata is a persistent (on-disk) cache of tag metadata keyed by REGISTRY/REPOSITORY:TAG,
+// metadata of tags we have not seen for longer than its TTL is evicted on save
+// NB! nil *Metadata is valid: it is always empty and does not store anything
+type Metadata struct {
+	fileName string
+	items    map[string]metadataEntry
+	isDirty  bool
+	ttl      time.Duration
+	now      func() time.Time
+	mux      sync.Mutex
+}
+
+// MetadataKey gets cache key for the registry, repository path and tag passed
+func MetadataKey(registry, repoPath, tagName string) string {
+	return registry + "/" + repoPath + ":" + tagName
+}
+
+// LoadMetadata loads tag metadata cache from the directory passed (directory is created, if not exists),
+// metadata of tags not seen for longer than TTL passed is evicted (DefaultMetadataTTL is used, if TTL is zero)
+func LoadMetadata(dir string, ttl time.Duration) (*Metadata, error) {
+	dir = fix.Path(dir)
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	if ttl == 0 {
+		ttl = DefaultMetadataTTL
+	}
+
+	m := &Metadata{fileName: filepath.Join(dir, MetadataFile), items: make(map[string]metadataEntry), ttl: ttl, now: time.Now}
+
+	b, err := ioutil.ReadFile(m.fileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &m.items); err != nil {
+		return nil, err
+	}
+
+	// items cached before we started to track them have never been seen, so we assume they are seen right now
+	for key, e := range m.items {
+		if e.Seen == 0 {
+			e.Seen = m.now().Unix()
+			m.items[key] = e
+		}
+	}
+
+	return m, nil
+}
+
+// touch marks item with a passed key as seen right now (lock must be held)
+func (m *Metadata) touch(key string) {
+	e, defined := m.items[key]
+	if !defined {
+		return
+	}
+
+	now := m.now().Unix()
+	if now-e.Seen < int64(metadataSeenResolution/time.Second) {
+		return
+	}
+
+	e.Seen = now
+	m.items[key] = e
+	m.isDirty = true
+}
+
+// evict removes items we have not seen for longer than TTL (lock must be held)
+func (m *Metadata) evict() {
+	expired := m.now().Add(-m.ttl).Unix()
+
+	for key, e := range m.items {
+		if e.Seen < expired {
+			delete(m.items, key)
+			m.isDirty = true
+		}
+	}
+}
+
+// Get gets metadata for a passed key
+func (m *Metadata) Get(key string) (MetadataItem, bool) {
+	if m == nil {
+		return MetadataItem{}, false
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	e, defined := m.items[key]
+	if defined {
+		m.touch(key)
+	}
+
+	return e.MetadataItem, defined
+}
+
+// Set sets metadata for a passed key
+func (m *Metadata) Set(key string, item MetadataItem) {
+	if m == nil {
+		return
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if e, defined := m.items[key]; defined && e.MetadataItem == item {
+		m.touch(key)
+		return
+	}
+
+	m.items[key] = metadataEntry{MetadataItem: item, Seen: m.now().Unix()}
+	m.isDirty = true
+}
+
+// Save evicts metadata of tags not seen for longer than TTL and saves metadata to disk
+// (only if it was changed since load or last save)
+func (m *Metadata) Save() error {
+	if m == nil {
+		return nil
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	m.evict()
+
+	if !m.isDirty {
+		return nil
+	}
+
+	b, err := json.Marshal(m.items)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(m.fileName), MetadataFile+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(f.Name(), m.fileName); err != nil {
+		return err
+	}
+
+	m.isDirty = false
+
+	return nil
+}
@@ -0,0 +1,171 @@
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetadata(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "lstags-cache-")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	dir = filepath.Join(dir, "nested")
+
+	m, err := LoadMetadata(dir, 0)
+	assert.Nil(err)
+
+	key := MetadataKey("registry.company.io", "lstags/alpine", "latest")
+	assert.Equal("registry.company.io/lstags/alpine:latest", key)
+
+	_, defined := m.Get(key)
+	assert.False(defined)
+
+	item := MetadataItem{Digest: "sha256:abc", Created: 1500000000, ETag: `"sha256:abc"`}
+	m.Set(key, item)
+
+	assert.Nil(m.Save())
+
+	reloaded, err := LoadMetadata(dir, 0)
+	assert.Nil(err)
+
+	cached, defined := reloaded.Get(key)
+	assert.True(defined)
+	assert.Equal(item, cached)
+}
+
+func TestMetadataSaveOnlyIfChanged(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "lstags-cache-")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	m, err := LoadMetadata(dir, 0)
+	assert.Nil(err)
+
+	assert.Nil(m.Save())
+
+	_, err = os.Stat(filepath.Join(dir, MetadataFile))
+	assert.True(os.IsNotExist(err), "should not write unchanged cache")
+}
+
+func TestMetadataEviction(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "lstags-cache-")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	m, err := LoadMetadata(dir, 0)
+	assert.Nil(err)
+
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	seen := MetadataKey("registry.company.io", "lstags/alpine", "latest")
+	unseen := MetadataKey("registry.company.io", "lstags/alpine", "deleted")
+
+	m.Set(seen, MetadataItem{Digest: "sha256:abc"})
+	m.Set(unseen, MetadataItem{Digest: "sha256:def"})
+	assert.Nil(m.Save())
+
+	now = now.Add(DefaultMetadataTTL / 2)
+
+	_, defined := m.Get(seen)
+	assert.True(defined)
+
+	now = now.Add(DefaultMetadataTTL/2 + time.Minute)
+
+	assert.Nil(m.Save())
+
+	_, defined = m.Get(unseen)
+	assert.False(defined, "should evict item not seen for longer than TTL")
+	_, defined = m.Get(seen)
+	assert.True(defined, "should keep item seen recently")
+
+	reloaded, err := LoadMetadata(dir, 0)
+	assert.Nil(err)
+
+	_, defined = reloaded.Get(unseen)
+	assert.False(defined, "should evict item from disk too")
+	_, defined = reloaded.Get(seen)
+	assert.True(defined)
+}
+
+func TestMetadataEviction_TTL(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "lstags-cache-")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	m, err := LoadMetadata(dir, 24*time.Hour)
+	assert.Nil(err)
+
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	key := MetadataKey("registry.company.io", "lstags/alpine", "deleted")
+
+	m.Set(key, MetadataItem{Digest: "sha256:def"})
+
+	now = now.Add(24*time.Hour + time.Minute)
+
+	assert.Nil(m.Save())
+
+	_, defined := m.Get(key)
+	assert.False(defined, "should evict item not seen for longer than TTL passed")
+}
+
+func TestMetadataLoadUntracked(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "lstags-cache-")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	// cache written before we started to track items seen
+	assert.Nil(ioutil.WriteFile(filepath.Join(dir, MetadataFile), []byte(`{"alpine:latest":{"digest":"sha256:abc"}}`), 0600))
+
+	m, err := LoadMetadata(dir, 0)
+	assert.Nil(err)
+	assert.Nil(m.Save())
+
+	item, defined := m.Get("alpine:latest")
+	assert.True(defined, "should not evict item we have not tracked yet")
+	assert.Equal(MetadataItem{Digest: "sha256:abc"}, item)
+}
+
+func TestMetadataInvalidFile(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "lstags-cache-")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	assert.Nil(ioutil.WriteFile(filepath.Join(dir, MetadataFile), []byte("not a JSON"), 0600))
+
+	_, err = LoadMetadata(dir, 0)
+	assert.NotNil(err)
+}
+
+func TestNilMetadata(t *testing.T) {
+	assert := assert.New(t)
+
+	var m *Metadata
+
+	m.Set("key", MetadataItem{Digest: "sha256:abc"})
+
+	_, defined := m.Get("key")
+	assert.False(defined)
+
+	assert.Nil(m.Save())
+}
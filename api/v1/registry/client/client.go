@@ -2,9 +2,13 @@
 package client
 
 import (
+	"crypto/sha256"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"reflect"
 	"time"
@@ -12,10 +16,12 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/ivanilves/lstags/api/v1/registry/client/auth"
+	basicstore "github.com/ivanilves/lstags/api/v1/registry/client/auth/basic/store"
 	"github.com/ivanilves/lstags/api/v1/registry/client/cache"
 	"github.com/ivanilves/lstags/api/v1/registry/client/request"
 	"github.com/ivanilves/lstags/tag"
 	"github.com/ivanilves/lstags/tag/manifest"
+	"github.com/ivanilves/lstags/util/ratelimit"
 )
 
 // DefaultConcurrentRequests will be used if no explicit ConcurrentRequests configured
@@ -30,9 +36,10 @@ const MaxConcurrentRequests = 256
 // RegistryClient is an abstraction to wrap logic of working with Docker registry
 // incl. connection, authentification, authorization, obtaining information etc...
 type RegistryClient struct {
-	registry string
-	username string
-	password string
+	registry   string
+	username   string
+	password   string
+	httpClient *http.Client
 
 	// Config has general configuration of the registry client instance
 	Config Config
@@ -56,6 +63,31 @@ type Config struct {
 	TraceRequests bool
 	// IsInsecure sets if we want to communicate registry over plain HTTP instead of HTTPS
 	IsInsecure bool
+	// TLSConfig is a registry-specific TLS configuration (default HTTP transport is used, if nil)
+	TLSConfig *tls.Config
+	// Limiter limits rate and concurrency of requests to the registry (should be shared by all clients of it)
+	Limiter *ratelimit.Limiter
+	// Mirrors are mirror endpoints (ADDR[:PORT]) we try to read from before falling back to the registry itself
+	Mirrors []string
+	// Tokens is a cache of tokens obtained (should be shared by all clients, a private one is created, if nil)
+	Tokens *cache.Token
+	// BasicStore holds explicitly set BASIC auth logins (could be nil)
+	BasicStore *basicstore.Store
+	// OnRetry is called before every retry of the failed registry request (could be nil)
+	OnRetry request.RetryFunc
+	// Metadata is a persistent cache of tag metadata (could be nil)
+	Metadata *cache.Metadata
+}
+
+func newHTTPClient(tlsConfig *tls.Config) *http.Client {
+	if tlsConfig == nil {
+		return &http.Client{}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{Transport: transport}
 }
 
 // New creates and validates new RegistryClient instance
@@ -68,6 +100,10 @@ func New(registry string, config Config) (*RegistryClient, error) {
 		config.RetryDelay = DefaultRetryDelay
 	}
 
+	if config.Tokens == nil {
+		config.Tokens = cache.NewToken(config.WaitBetween)
+	}
+
 	if config.ConcurrentRequests > MaxConcurrentRequests {
 		err := fmt.Errorf(
 			"Could not run more than %d concurrent requests (%d configured)",
@@ -80,6 +116,7 @@ func New(registry string, config Config) (*RegistryClient, error) {
 
 	return &RegistryClient{
 		registry:   registry,
+		httpClient: newHTTPClient(config.TLSConfig),
 		Config:     config,
 		RepoTokens: make(map[string]auth.Token),
 	}, nil
@@ -100,20 +137,46 @@ func (cli *RegistryClient) URL() string {
 
 // Ping checks basic connectivity to the registry
 func (cli *RegistryClient) Ping() error {
-	resp, err := http.Get(cli.URL())
+	resp, err := cli.httpClient.Get(cli.URL())
 	if err != nil {
-		return err
+		return &request.TransportError{Method: "GET", URL: cli.URL(), Err: err}
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 && resp.StatusCode != 401 {
-		return fmt.Errorf("Unexpected status: %s", resp.Status)
+		return request.NewError("GET", cli.URL(), resp)
 	}
 
 	return nil
 }
 
+func (cli *RegistryClient) newToken(username, password, scope string) (auth.Token, error) {
+	cli.Config.Limiter.Acquire()
+	defer cli.Config.Limiter.Release()
+
+	return auth.NewToken(cli.httpClient, cli.URL(), username, password, scope, cli.Config.BasicStore)
+}
+
+func (cli *RegistryClient) perform(method, url, auth, mode string, header http.Header) (*http.Response, string, error) {
+	cli.Config.Limiter.Acquire()
+	defer cli.Config.Limiter.Release()
+
+	return request.Perform(
+		cli.httpClient,
+		method,
+		url,
+		auth,
+		mode,
+		header,
+		cli.Config.TraceRequests,
+		cli.Config.RetryRequests,
+		cli.Config.RetryDelay,
+		cli.Config.OnRetry,
+	)
+}
+
 func (cli *RegistryClient) registryToken(username, password string) (auth.Token, error) {
-	tk, err := auth.NewToken(cli.URL(), username, password, "registry:catalog:*")
+	tk, err := cli.newToken(username, password, "registry:catalog:*")
 	if err != nil {
 		if cli.Config.WaitBetween == 0 {
 			log.Debugf("Try to login with less permissions (repository:catalog:*)")
@@ -121,7 +184,7 @@ func (cli *RegistryClient) registryToken(username, password string) (auth.Token,
 			log.Debugf("Try to login with less permissions (repository:catalog:*) [after waiting %v]", cli.Config.WaitBetween)
 			time.Sleep(cli.Config.WaitBetween)
 		}
-		tk, err = auth.NewToken(cli.URL(), username, password, "repository:catalog:*")
+		tk, err = cli.newToken(username, password, "repository:catalog:*")
 		if err != nil {
 			if username == "" && password == "" {
 				return tk, nil
@@ -136,16 +199,16 @@ func (cli *RegistryClient) registryToken(username, password string) (auth.Token,
 
 // Login logs in to the registry (returns error, if failed)
 func (cli *RegistryClient) Login(username, password string) error {
-	if !cache.Token.Exists(cli.registry) {
+	if !cli.Config.Tokens.Exists(cli.registry) {
 		tk, err := cli.registryToken(username, password)
 		if err != nil {
 			return err
 		}
 
-		cache.Token.Set(cli.registry, tk)
+		cli.Config.Tokens.Set(cli.registry, tk)
 	}
 
-	cli.Token = cache.Token.Get(cli.registry)
+	cli.Token = cli.Config.Tokens.Get(cli.registry)
 
 	cli.username = username
 	cli.password = password
@@ -177,32 +240,41 @@ func decodeAllTagData(body io.ReadCloser) ([]string, map[string]manifest.Manifes
 }
 
 func (cli *RegistryClient) repoToken(repoPath string) (auth.Token, error) {
+	return cli.scopedRepoToken(repoPath, "pull")
+}
+
+// scopedRepoToken gets repo token for the actions passed, e.g. "pull" or "pull,push"
+func (cli *RegistryClient) scopedRepoToken(repoPath, actions string) (auth.Token, error) {
 	if cli.Token != nil && !reflect.ValueOf(cli.Token).IsNil() && cli.Token.Method() != "Bearer" {
 		return cli.Token, nil
 	}
 
-	_, tokenDefined := cli.RepoTokens[repoPath]
+	key := cli.registry + "/" + repoPath
+	if actions != "pull" {
+		key = key + ":" + actions
+	}
+
+	_, tokenDefined := cli.RepoTokens[key]
 	if tokenDefined {
-		return cli.RepoTokens[repoPath], nil
+		return cli.RepoTokens[key], nil
 	}
 
-	if !cache.Token.Exists(repoPath) {
-		repoToken, err := auth.NewToken(
-			cli.URL(),
+	if !cli.Config.Tokens.Exists(key) {
+		repoToken, err := cli.newToken(
 			cli.username,
 			cli.password,
-			"repository:"+repoPath+":pull",
+			"repository:"+repoPath+":"+actions,
 		)
 		if err != nil {
 			return nil, err
 		}
 
-		cache.Token.Set(repoPath, repoToken)
+		cli.Config.Tokens.Set(key, repoToken)
 	}
 
-	cli.RepoTokens[repoPath] = cache.Token.Get(repoPath)
+	cli.RepoTokens[key] = cli.Config.Tokens.Get(key)
 
-	return cli.RepoTokens[repoPath], nil
+	return cli.RepoTokens[key], nil
 }
 
 // TagData gets data of either all tags (list+get) or a set of single tags only (blind "get")
@@ -230,13 +302,12 @@ func (cli *RegistryClient) AllTagData(repoPath string) ([]string, map[string]man
 
 	link := "/tags/list"
 	for {
-		resp, nextlink, err := request.Perform(
+		resp, nextlink, err := cli.perform(
+			"GET",
 			cli.URL()+repoPath+link,
 			repoToken.Method()+" "+repoToken.String(),
 			"v2",
-			cli.Config.TraceRequests,
-			cli.Config.RetryRequests,
-			cli.Config.RetryDelay,
+			nil,
 		)
 		if err != nil {
 			return nil, nil, err
@@ -271,44 +342,87 @@ func (cli *RegistryClient) SingleTagData(repoTags []string) ([]string, map[strin
 	return repoTags, tagManifests, nil
 }
 
-func (cli *RegistryClient) tagDigest(repoPath, tagName string) (string, error) {
+// tagDigest gets tag digest (and ETag), revalidating metadata we already know, if it has ETag
+// NB! Digest is resolved with HEAD request first (it is "free" on Docker Hub, while GETs are counted against pull
+// rate limits), we only GET manifest (and calculate its digest locally), if registry did not give us digest on HEAD
+func (cli *RegistryClient) tagDigest(repoPath, tagName string, known cache.MetadataItem) (string, string, error) {
 	repoToken, err := cli.repoToken(repoPath)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
-	resp, _, err := request.Perform(
-		cli.URL()+repoPath+"/manifests/"+tagName,
-		repoToken.Method()+" "+repoToken.String(),
-		"v2",
-		cli.Config.TraceRequests,
-		cli.Config.RetryRequests,
-		cli.Config.RetryDelay,
-	)
+	var header http.Header
+	if known.ETag != "" {
+		header = http.Header{"If-None-Match": []string{known.ETag}}
+	}
+
+	url := cli.URL() + repoPath + "/manifests/" + tagName
+	auth := repoToken.Method() + " " + repoToken.String()
+
+	resp, _, err := cli.perform("HEAD", url, auth, "v2", header)
 	if err != nil {
-		return "", err
+		if !isHeadUnsupported(err) {
+			return "", "", err
+		}
+
+		log.Debugf("HEAD is not supported for '%s', will GET manifest: %s", url, err.Error())
+	} else {
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusNotModified {
+			return known.Digest, known.ETag, nil
+		}
+
+		if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+			return digest, resp.Header.Get("ETag"), nil
+		}
 	}
 
-	digests, defined := resp.Header["Docker-Content-Digest"]
-	if defined {
-		return digests[0], nil
+	resp, _, err = cli.perform("GET", url, auth, "v2", header)
+	if err != nil {
+		return "", "", err
 	}
+	defer resp.Body.Close()
 
-	type configField struct {
-		Digest string `json:"digest"`
+	if resp.StatusCode == http.StatusNotModified {
+		return known.Digest, known.ETag, nil
 	}
-	var values struct {
-		Config configField `json:"config"`
+
+	etag := resp.Header.Get("ETag")
+
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, etag, nil
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&values); err != nil {
-		return "", err
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", err
+	}
+
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data)), etag, nil
+}
+
+// Digest resolves current digest of the tag (or other manifest reference) passed, bypassing metadata cache
+func (cli *RegistryClient) Digest(repoPath, reference string) (string, error) {
+	digest, _, err := cli.tagDigest(repoPath, reference, cache.MetadataItem{})
+
+	return digest, err
+}
+
+// isHeadUnsupported tells us if error returned on HEAD request means registry does not handle HEAD requests
+// (on any other error GET would most probably fail too, so we do not even try it)
+func isHeadUnsupported(err error) bool {
+	var e *request.Error
+	if !errors.As(err, &e) {
+		return false
 	}
 
-	if values.Config.Digest == "" {
-		values.Config.Digest = "this.image.is.bad.it.has.no.digest.fuuu!"
+	switch e.StatusCode {
+	case http.StatusBadRequest, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return true
 	}
 
-	return values.Config.Digest, nil
+	return false
 }
 
 func (cli *RegistryClient) v1TagHistory(s string) (*tag.Options, error) {
@@ -336,13 +450,12 @@ func (cli *RegistryClient) v1TagOptions(repoPath, tagName string) (*tag.Options,
 		return nil, err
 	}
 
-	resp, _, err := request.Perform(
+	resp, _, err := cli.perform(
+		"GET",
 		cli.URL()+repoPath+"/manifests/"+tagName,
 		repoToken.Method()+" "+repoToken.String(),
 		"v1",
-		cli.Config.TraceRequests,
-		cli.Config.RetryRequests,
-		cli.Config.RetryDelay,
+		nil,
 	)
 	if err != nil {
 		return nil, err
@@ -364,37 +477,75 @@ func (cli *RegistryClient) v1TagOptions(repoPath, tagName string) (*tag.Options,
 }
 
 // Tag gets information about specified repository tag
+// (if tag metadata is cached and tag digest is not changed, cached metadata is used instead of fetching it again)
 func (cli *RegistryClient) Tag(repoPath, tagName string, tagManifest manifest.Manifest) (*tag.Tag, error) {
-	dc := make(chan string, 0)
-	ec := make(chan error, 0)
+	key := cache.MetadataKey(cli.registry, repoPath, tagName)
+
+	var digest, etag string
+	var options *tag.Options
 
-	go func(dc chan string, ec chan error) {
-		digest, err := cli.tagDigest(repoPath, tagName)
+	if known, isKnown := cli.Config.Metadata.Get(key); isKnown {
+		var err error
+
+		digest, etag, err = cli.tagDigest(repoPath, tagName, known)
 		if err != nil {
-			ec <- err
-			return
+			return nil, err
 		}
 
-		dc <- digest
-	}(dc, ec)
+		if digest == known.Digest {
+			options = &tag.Options{Created: known.Created, ImageID: known.ImageID}
+		} else {
+			options = cli.tagOptions(repoPath, tagName)
+		}
+	} else {
+		type result struct {
+			digest string
+			etag   string
+			err    error
+		}
 
-	options, err := cli.v1TagOptions(repoPath, tagName)
-	if err != nil {
-		log.Debugf("%s\n", err.Error())
+		rc := make(chan result, 1)
 
-		options = &tag.Options{}
-	}
+		go func() {
+			digest, etag, err := cli.tagDigest(repoPath, tagName, cache.MetadataItem{})
 
-	select {
-	case digest := <-dc:
-		options.Digest = digest
-	case err := <-ec:
-		return nil, err
+			rc <- result{digest: digest, etag: etag, err: err}
+		}()
+
+		options = cli.tagOptions(repoPath, tagName)
+
+		r := <-rc
+		if r.err != nil {
+			return nil, r.err
+		}
+
+		digest, etag = r.digest, r.etag
 	}
 
+	options.Digest = digest
+
 	if options.Created == 0 {
 		options.Created = tagManifest.Created()
 	}
 
+	cli.Config.Metadata.Set(key, cache.MetadataItem{
+		Digest:  options.Digest,
+		Created: options.Created,
+		ImageID: options.ImageID,
+		ETag:    etag,
+	})
+
 	return tag.New(tagName, *options)
 }
+
+// tagOptions gets tag options from v1 manifest history (empty options, if not possible)
+func (cli *RegistryClient) tagOptions(repoPath, tagName string) *tag.Options {
+	options, err := cli.v1TagOptions(repoPath, tagName)
+	if err != nil {
+		log.Debugf("%s\n", err.Error())
+
+		return &tag.Options{}
+	}
+
+	return options
+}
@@ -0,0 +1,128 @@
+package request
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/ivanilves/lstags/util/redact"
+)
+
+// Sentinel errors to check registry request errors against with errors.Is()
+var (
+	// ErrNotFound means registry has no such repository, tag or blob (HTTP 404)
+	ErrNotFound = errors.New("not found")
+	// ErrUnauthorized means we are not (or not properly) authenticated against the registry (HTTP 401)
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden means we are authenticated, but have no permission to do what we want (HTTP 403)
+	ErrForbidden = errors.New("forbidden")
+	// ErrRateLimited means we sent too much requests to the registry (HTTP 429)
+	ErrRateLimited = errors.New("rate limited")
+	// ErrServer means registry failed to process our request (HTTP 5xx)
+	ErrServer = errors.New("server error")
+	// ErrTransport means we were unable to communicate the registry at all (network, TLS etc)
+	ErrTransport = errors.New("transport error")
+)
+
+// RegistryError is an item of the "errors" array registry returns along with the failed response
+type RegistryError struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Detail  interface{} `json:"detail,omitempty"`
+}
+
+// Error is returned when registry responds with unexpected HTTP status
+type Error struct {
+	// StatusCode is an HTTP status code, e.g. 404
+	StatusCode int
+	// Status is an HTTP status, e.g. "404 Not Found"
+	Status string
+	// Method is an HTTP request method
+	Method string
+	// URL is a request URL (with all secrets redacted)
+	URL string
+	// Errors are errors reported by registry in the response body (if any)
+	Errors []RegistryError
+}
+
+// NewError creates an error from the HTTP response passed (response body is read, but not closed)
+func NewError(method, url string, resp *http.Response) *Error {
+	e := &Error{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Method:     method,
+		URL:        redact.URL(url),
+	}
+
+	if resp.Body != nil {
+		b, _ := ioutil.ReadAll(resp.Body)
+
+		var body struct {
+			Errors []RegistryError `json:"errors"`
+		}
+		if err := json.Unmarshal(b, &body); err == nil {
+			e.Errors = body.Errors
+		}
+	}
+
+	return e
+}
+
+func (e *Error) Error() string {
+	s := "Bad response status: " + e.Status + " >> " + e.Method + " " + e.URL
+
+	if len(e.Errors) != 0 {
+		messages := make([]string, len(e.Errors))
+		for i, re := range e.Errors {
+			messages[i] = re.Code + ": " + re.Message
+		}
+
+		s += " >> " + strings.Join(messages, "; ")
+	}
+
+	return s
+}
+
+// Is makes error match sentinel error for its status code
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrServer:
+		return e.StatusCode >= 500
+	}
+
+	return false
+}
+
+// TransportError is returned when request could not be sent or response could not be received
+type TransportError struct {
+	// Method is an HTTP request method
+	Method string
+	// URL is a request URL (with all secrets redacted)
+	URL string
+	// Err is an underlying error
+	Err error
+}
+
+func (e *TransportError) Error() string {
+	return e.Method + " " + e.URL + ": " + e.Err.Error()
+}
+
+// Unwrap gets underlying error
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes error match ErrTransport
+func (e *TransportError) Is(target error) bool {
+	return target == ErrTransport
+}
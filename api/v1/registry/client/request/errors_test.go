@@ -0,0 +1,132 @@
+package request
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPerformErrors(t *testing.T) {
+	var testCases = []struct {
+		status   int
+		sentinel error
+	}{
+		{http.StatusNotFound, ErrNotFound},
+		{http.StatusUnauthorized, ErrUnauthorized},
+		{http.StatusForbidden, ErrForbidden},
+		{http.StatusTooManyRequests, ErrRateLimited},
+		{http.StatusInternalServerError, ErrServer},
+		{http.StatusBadGateway, ErrServer},
+	}
+
+	assert := assert.New(t)
+
+	for _, testCase := range testCases {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(testCase.status)
+			w.Write([]byte(`{"errors":[{"code":"SOME_CODE","message":"some message"}]}`))
+		}))
+
+		_, _, err := Perform(http.DefaultClient, "GET", server.URL+"/v2/repo/tags/list?token=secret", "Bearer xyz", "v2", nil, false, 0, 0, nil)
+
+		server.Close()
+
+		assert.True(errors.Is(err, testCase.sentinel), "%d: %v", testCase.status, err)
+		assert.False(errors.Is(err, ErrTransport), "%d: %v", testCase.status, err)
+
+		var e *Error
+		if assert.True(errors.As(err, &e), "%d: %v", testCase.status, err) {
+			assert.Equal(testCase.status, e.StatusCode)
+			assert.Equal("GET", e.Method)
+			assert.Equal(server.URL+"/v2/repo/tags/list?token=REDACTED", e.URL)
+			assert.Equal([]RegistryError{{Code: "SOME_CODE", Message: "some message"}}, e.Errors)
+			assert.True(strings.Contains(e.Error(), "SOME_CODE: some message"), e.Error())
+		}
+	}
+}
+
+func TestPerformDoesNotRetryNotFound(t *testing.T) {
+	assert := assert.New(t)
+
+	count := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	_, _, err := Perform(http.DefaultClient, "GET", server.URL, "", "v2", nil, false, 3, 0, nil)
+
+	assert.True(errors.Is(err, ErrNotFound))
+	assert.Equal(1, count)
+}
+
+func TestPerformRetriesServerErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	count := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	attempts := make([]int, 0)
+	onRetry := func(url string, attempt int, delay time.Duration, err error) {
+		assert.Equal(server.URL, url)
+		assert.True(errors.Is(err, ErrServer))
+
+		attempts = append(attempts, attempt)
+	}
+
+	_, _, err := Perform(http.DefaultClient, "GET", server.URL, "", "v2", nil, false, 2, 0, onRetry)
+
+	assert.True(errors.Is(err, ErrServer))
+	assert.Equal(3, count)
+	assert.Equal([]int{1, 2}, attempts)
+}
+
+func TestTransportError(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := server.URL
+	server.Close()
+
+	_, err := Send(http.DefaultClient, "HEAD", url+"/v2/", "", nil, nil, -1, false)
+
+	assert.True(errors.Is(err, ErrTransport), "%v", err)
+	assert.False(errors.Is(err, ErrNotFound), "%v", err)
+
+	var e *TransportError
+	if assert.True(errors.As(err, &e)) {
+		assert.Equal("HEAD", e.Method)
+		assert.NotNil(e.Unwrap())
+	}
+}
+
+func TestPerformHead(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("HEAD", r.Method)
+		assert.Equal(`"sha256:abc"`, r.Header.Get("If-None-Match"))
+
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	defer server.Close()
+
+	header := http.Header{"If-None-Match": []string{`"sha256:abc"`}}
+
+	resp, _, err := Perform(http.DefaultClient, "HEAD", server.URL, "", "v2", header, false, 0, 0, nil)
+	assert.Nil(err, "should not treat '304 Not Modified' as error")
+	assert.Equal(http.StatusNotModified, resp.StatusCode)
+
+	_, _, err = Perform(http.DefaultClient, "POST", server.URL, "", "v2", nil, false, 0, 0, nil)
+	assert.NotNil(err)
+}
@@ -5,11 +5,14 @@ import (
 	"crypto/sha256"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
 	"strings"
 	"time"
+
+	"github.com/ivanilves/lstags/util/redact"
 )
 
 func getRequestID() string {
@@ -29,11 +32,10 @@ func getResponseBody(resp *http.Response) string {
 	return string(b)
 }
 
-func perform(url, auth, mode string, trace bool) (resp *http.Response, err error) {
-	hc := &http.Client{}
+func perform(hc *http.Client, method, url, auth, mode string, header http.Header, trace bool) (resp *http.Response, err error) {
 	rid := getRequestID()
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequest(method, url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -46,41 +48,59 @@ func perform(url, auth, mode string, trace bool) (resp *http.Response, err error
 		req.Header.Add("Accept", "application/vnd.docker.distribution.manifest.v1+json")
 	case "v2":
 		req.Header.Add("Accept", "application/vnd.docker.distribution.manifest.v2+json")
+		req.Header.Add("Accept", "application/vnd.docker.distribution.manifest.list.v2+json")
 		req.Header.Add("Accept", "application/vnd.oci.image.index.v1+json")
 		req.Header.Add("Accept", "application/vnd.oci.image.manifest.v1+json")
 	default:
 		return nil, errors.New("Unknown request mode: " + mode)
 	}
 
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
 	resp, err = hc.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, &TransportError{Method: req.Method, URL: redact.URL(url), Err: err}
 	}
 
 	if trace {
-		fmt.Printf("%s|@URL: %s\n", rid, url)
-		for k, v := range req.Header {
+		fmt.Printf("%s|@%s: %s\n", rid, method, redact.URL(url))
+		for k, v := range redact.Header(req.Header) {
 			fmt.Printf("%s|@REQ-HEADER: %-40s = %s\n", rid, k, v)
 		}
-		for k, v := range resp.Header {
+		for k, v := range redact.Header(resp.Header) {
 			fmt.Printf("%s|@RESP-HEADER: %-40s = %s\n", rid, k, v)
 		}
 		fmt.Printf("%s|--- BODY BEGIN ---\n", rid)
-		for _, line := range strings.Split(getResponseBody(resp), "\n") {
+		for _, line := range strings.Split(redact.JSON(getResponseBody(resp)), "\n") {
 			fmt.Printf("%s|%s\n", rid, line)
 		}
 		fmt.Printf("%s|--- BODY END ---\n", rid)
 	}
 
-	if resp.StatusCode != 200 && resp.StatusCode != 404 {
-		return resp, errors.New("Bad response status: " + resp.Status + " >> " + url)
+	// "304 Not Modified" is only returned on conditional requests, i.e. when we explicitly asked for it
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotModified {
+		defer resp.Body.Close()
+
+		return resp, NewError(req.Method, url, resp)
 	}
 
 	return resp, nil
 }
 
-// Perform performs the required HTTP(S) request, retrying if applicable
-func Perform(url, auth, mode string, trace bool, retries int, delay time.Duration) (resp *http.Response, nextlink string, err error) {
+// RetryFunc is called before every retry of the failed request with request URL (secrets redacted),
+// number of the failed attempt, delay before the next attempt and error happened
+type RetryFunc func(url string, attempt int, delay time.Duration, err error)
+
+// Perform performs the required HTTP(S) request (GET or HEAD) with HTTP client passed, retrying if applicable
+// (header passed, if not nil, is added to the request, onRetry function, if not nil, is called before every retry)
+// NB! If header passed makes request conditional (e.g. "If-None-Match"), "304 Not Modified" response is not an error
+func Perform(hc *http.Client, method, url, auth, mode string, header http.Header, trace bool, retries int, delay time.Duration, onRetry RetryFunc) (resp *http.Response, nextlink string, err error) {
+	if method != http.MethodGet && method != http.MethodHead {
+		return nil, "", errors.New("Unsupported request method: " + method)
+	}
+
 	tries := 1
 
 	if retries > 0 {
@@ -88,7 +108,7 @@ func Perform(url, auth, mode string, trace bool, retries int, delay time.Duratio
 	}
 
 	for try := 1; try <= tries; try++ {
-		resp, err := perform(url, auth, mode, trace)
+		resp, err = perform(hc, method, url, auth, mode, header, trace)
 
 		if err == nil {
 			return resp, getNextLink(resp.Header["Link"]), nil
@@ -103,19 +123,23 @@ func Perform(url, auth, mode string, trace bool, retries int, delay time.Duratio
 		if try < tries {
 			fmt.Printf(
 				"Will retry '%s' [%s] in a %v\n=> Error: %s\n",
-				url,
+				redact.URL(url),
 				mode,
 				delay,
 				err.Error(),
 			)
 
+			if onRetry != nil {
+				onRetry(redact.URL(url), try, delay, err)
+			}
+
 			time.Sleep(delay)
 
 			delay += delay
 		}
 	}
 
-	return resp, getNextLink(resp.Header["Link"]), err
+	return nil, "", err
 }
 
 func getNextLink(headers []string) string {
@@ -130,3 +154,48 @@ func getNextLink(headers []string) string {
 
 	return nextlink
 }
+
+// Send sends a single (non-retried) HTTP(S) request with arbitrary method, headers and body
+// NB! It is used for blob transfers and registry writes, where body could not be "rewinded" to retry
+func Send(hc *http.Client, method, url, auth string, header http.Header, body io.Reader, size int64, trace bool) (*http.Response, error) {
+	rid := getRequestID()
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	if body != nil && size >= 0 {
+		req.ContentLength = size
+	}
+
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, &TransportError{Method: method, URL: redact.URL(url), Err: err}
+	}
+
+	if trace {
+		fmt.Printf("%s|@%s: %s\n", rid, method, redact.URL(url))
+		for k, v := range redact.Header(req.Header) {
+			fmt.Printf("%s|@REQ-HEADER: %-40s = %s\n", rid, k, v)
+		}
+		for k, v := range redact.Header(resp.Header) {
+			fmt.Printf("%s|@RESP-HEADER: %-40s = %s\n", rid, k, v)
+		}
+		fmt.Printf("%s|@STATUS: %s\n", rid, resp.Status)
+	}
+
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+
+		return resp, NewError(method, url, resp)
+	}
+
+	return resp, nil
+}
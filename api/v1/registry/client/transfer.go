@@ -0,0 +1,172 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ivanilves/lstags/api/v1/registry/client/request"
+)
+
+func (cli *RegistryClient) send(method, url, auth string, header http.Header, body io.Reader, size int64) (*http.Response, error) {
+	cli.Config.Limiter.Acquire()
+	defer cli.Config.Limiter.Release()
+
+	return request.Send(cli.httpClient, method, url, auth, header, body, size, cli.Config.TraceRequests)
+}
+
+func (cli *RegistryClient) scopedAuth(repoPath, actions string) (string, error) {
+	repoToken, err := cli.scopedRepoToken(repoPath, actions)
+	if err != nil {
+		return "", err
+	}
+
+	return repoToken.Method() + " " + repoToken.String(), nil
+}
+
+// Manifest gets manifest (or index) for the repository path and reference (tag or digest) passed
+// NB! It returns media type, digest and raw body of the manifest (in this order)
+func (cli *RegistryClient) Manifest(repoPath, reference string) (string, string, []byte, error) {
+	auth, err := cli.scopedAuth(repoPath, "pull")
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	resp, _, err := cli.perform("GET", cli.URL()+repoPath+"/manifests/"+reference, auth, "v2", nil)
+	if err != nil {
+		return "", "", nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		digest = fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	}
+
+	mediaType := strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0])
+
+	return mediaType, digest, data, nil
+}
+
+// PutManifest uploads manifest (or index) of the media type passed and tags it with the reference passed
+func (cli *RegistryClient) PutManifest(repoPath, reference, mediaType string, data []byte) error {
+	auth, err := cli.scopedAuth(repoPath, "pull,push")
+	if err != nil {
+		return err
+	}
+
+	resp, err := cli.send(
+		"PUT",
+		cli.URL()+repoPath+"/manifests/"+reference,
+		auth,
+		http.Header{"Content-Type": []string{mediaType}},
+		bytes.NewReader(data),
+		int64(len(data)),
+	)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+// HasBlob tells us if blob with digest passed exists in the repository
+func (cli *RegistryClient) HasBlob(repoPath, digest string) (bool, error) {
+	auth, err := cli.scopedAuth(repoPath, "pull")
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := cli.send("HEAD", cli.URL()+repoPath+"/blobs/"+digest, auth, nil, nil, -1)
+	if err != nil {
+		if errors.Is(err, request.ErrNotFound) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, resp.Body.Close()
+}
+
+// Blob opens blob with digest passed for reading (caller is responsible for closing it)
+func (cli *RegistryClient) Blob(repoPath, digest string) (io.ReadCloser, error) {
+	auth, err := cli.scopedAuth(repoPath, "pull")
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := cli.send("GET", cli.URL()+repoPath+"/blobs/"+digest, auth, nil, nil, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// PutBlob uploads blob of the size passed in a single ("monolithic") upload
+func (cli *RegistryClient) PutBlob(repoPath, digest string, size int64, r io.Reader) error {
+	auth, err := cli.scopedAuth(repoPath, "pull,push")
+	if err != nil {
+		return err
+	}
+
+	resp, err := cli.send("POST", cli.URL()+repoPath+"/blobs/uploads/", auth, nil, nil, -1)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	location, err := cli.uploadLocation(resp.Header.Get("Location"))
+	if err != nil {
+		return err
+	}
+
+	q := location.Query()
+	q.Set("digest", digest)
+	location.RawQuery = q.Encode()
+
+	resp, err = cli.send(
+		"PUT",
+		location.String(),
+		auth,
+		http.Header{"Content-Type": []string{"application/octet-stream"}},
+		r,
+		size,
+	)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+// uploadLocation resolves upload location (could be relative) returned by registry
+func (cli *RegistryClient) uploadLocation(location string) (*url.URL, error) {
+	if location == "" {
+		return nil, fmt.Errorf("no upload location returned by registry: %s", cli.registry)
+	}
+
+	base, err := url.Parse(cli.URL())
+	if err != nil {
+		return nil, err
+	}
+
+	ref, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+
+	return base.ResolveReference(ref), nil
+}
@@ -1,18 +1,15 @@
 package container
 
 import (
-	"bufio"
 	crand "crypto/rand"
 	"fmt"
-	"io"
 	"math/rand"
 	"net/http"
 	"time"
 
-	log "github.com/sirupsen/logrus"
-
 	dockerclient "github.com/ivanilves/lstags/docker/client"
 	dockerconfig "github.com/ivanilves/lstags/docker/config"
+	"github.com/ivanilves/lstags/docker/stream"
 	"github.com/ivanilves/lstags/repository"
 	"github.com/ivanilves/lstags/util/getenv"
 	"github.com/ivanilves/lstags/util/wait"
@@ -148,7 +145,10 @@ func (c *Container) SeedWithImages(refs ...string) ([]string, error) {
 				done <- err
 				return
 			}
-			logDebugData(pullResp)
+			if _, err := stream.Log(pullResp, src); err != nil {
+				done <- fmt.Errorf("PULL %s failed: '%s'", src, err.Error())
+				return
+			}
 
 			c.dockerClient.Tag(src, dst)
 
@@ -157,18 +157,14 @@ func (c *Container) SeedWithImages(refs ...string) ([]string, error) {
 				done <- err
 				return
 			}
-			logDebugData(pushResp)
+			if _, err := stream.Log(pushResp, dst); err != nil {
+				done <- fmt.Errorf("PUSH %s failed: '%s'", dst, err.Error())
+				return
+			}
 
-			done <- err
+			done <- nil
 		}(i, ref)
 	}
 
 	return pushRefs, wait.Until(done)
 }
-
-func logDebugData(data io.Reader) {
-	scanner := bufio.NewScanner(data)
-	for scanner.Scan() {
-		log.Debug(scanner.Text())
-	}
-}
@@ -0,0 +1,359 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ivanilves/lstags/oci/layout"
+	"github.com/ivanilves/lstags/repository"
+	"github.com/ivanilves/lstags/tag"
+)
+
+// TemplateContext is a data push path and tag templates are executed with, e.g.
+//
+//	{{ .Prefix }}{{ index .Labels "team" }}/{{ .Path | base }}
+//	{{ .Semver.Major }}.{{ .Semver.Minor }}-{{ .ShortDigest }}
+type TemplateContext struct {
+	// Prefix is a push prefix, e.g. "/quay/io/"
+	Prefix string
+	// Path is a source repository path (with push path separator), e.g. "coreos/flannel"
+	Path string
+	// Name is a source repository name, e.g. "quay.io/coreos/flannel"
+	Name string
+	// Tag is a source tag name, e.g. "v0.10.0"
+	Tag string
+	// Registry is a source registry ADDR[:PORT], e.g. "quay.io"
+	Registry string
+	// Digest is a source image digest, e.g. "sha256:db9c658d6d02..."
+	Digest string
+	// ShortDigest is a short (12 characters) hex form of the digest, usable in tags, e.g. "db9c658d6d02"
+	ShortDigest string
+	// Created is a source image creation time (zero, if unknown)
+	Created time.Time
+	// Semver holds parts of the tag parsed as a semantic version
+	Semver Semver
+	// Captures are capture groups of the repository /FILTER/ matched against the tag (whole match goes first)
+	Captures []string
+	// NamedCaptures are named capture groups of the repository /FILTER/, e.g. (?P<major>[0-9]+)
+	NamedCaptures map[string]string
+
+	repo  *repository.Repository
+	image *imageLoader
+}
+
+// Semver holds parts of the tag parsed as a semantic version ("v" prefix allowed), e.g. "v1.2.3-rc.1+build.5"
+type Semver struct {
+	// Valid tells us if tag is a semantic version at all (all parts are empty otherwise)
+	Valid      bool
+	Major      int
+	Minor      int
+	Patch      int
+	Prerelease string
+	Metadata   string
+}
+
+var semverRE = regexp.MustCompile(`^v?(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)(?:-([0-9A-Za-z.-]+))?(?:\+([0-9A-Za-z.-]+))?$`)
+
+func parseSemver(s string) Semver {
+	parts := semverRE.FindStringSubmatch(s)
+	if parts == nil {
+		return Semver{}
+	}
+
+	major, _ := strconv.Atoi(parts[1])
+	minor, _ := strconv.Atoi(parts[2])
+	patch, _ := strconv.Atoi(parts[3])
+
+	return Semver{Valid: true, Major: major, Minor: minor, Patch: patch, Prerelease: parts[4], Metadata: parts[5]}
+}
+
+// imageLoader loads image configuration on demand (only once),
+// so we do not fetch anything, unless templates use labels or platform
+type imageLoader struct {
+	load   func() (*layout.ImageConfig, error)
+	once   sync.Once
+	config *layout.ImageConfig
+	err    error
+}
+
+func (l *imageLoader) get() (*layout.ImageConfig, error) {
+	if l == nil || l.load == nil {
+		return &layout.ImageConfig{}, nil
+	}
+
+	l.once.Do(func() {
+		l.config, l.err = l.load()
+	})
+
+	return l.config, l.err
+}
+
+// Labels gets source image labels (of the template platform, if source image is an index, see templatePlatform)
+func (c *TemplateContext) Labels() (map[string]string, error) {
+	config, err := c.image.get()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get labels of %s:%s: %s", c.Name, c.Tag, err.Error())
+	}
+
+	if config.Config.Labels == nil {
+		return map[string]string{}, nil
+	}
+
+	return config.Config.Labels, nil
+}
+
+// Platform gets source image platform, e.g. "linux/amd64" (the template platform, if source image is an index, see templatePlatform)
+func (c *TemplateContext) Platform() (string, error) {
+	config, err := c.image.get()
+	if err != nil {
+		return "", fmt.Errorf("unable to get platform of %s:%s: %s", c.Name, c.Tag, err.Error())
+	}
+
+	if config.OS == "" {
+		return "", nil
+	}
+
+	return config.Platform().String(), nil
+}
+
+// forPush gets a copy of the context with push prefix and path set for the push configuration passed
+func (c *TemplateContext) forPush(push PushConfig) (*TemplateContext, error) {
+	pushPrefix := getPushPrefix(push.Prefix, c.repo.PushPrefix())
+	if err := validatePushPrefix(pushPrefix); err != nil {
+		return nil, err
+	}
+
+	ctx := *c
+	ctx.Prefix = pushPrefix
+	ctx.Path = c.repo.PushPath(push.PathSeparator)
+
+	return &ctx, nil
+}
+
+// newTemplateContext makes template context for the repository tag passed,
+// image configuration (labels and platform) is loaded with the function passed (if any)
+func newTemplateContext(repo *repository.Repository, tg *tag.Tag, load func() (*layout.ImageConfig, error)) *TemplateContext {
+	ctx := &TemplateContext{
+		Name:     repo.Name(),
+		Tag:      tg.Name(),
+		Registry: repo.Registry(),
+		Semver:   parseSemver(tg.Name()),
+		repo:     repo,
+		image:    &imageLoader{load: load},
+	}
+
+	if digest := tg.GetDigest(); strings.Contains(digest, ":") {
+		ctx.Digest = digest
+		ctx.ShortDigest = shortHexDigest(digest)
+	} else {
+		ctx.image.load = nil // nothing to load for the image we have not found
+	}
+
+	if tg.GetCreated() > 0 {
+		ctx.Created = time.Unix(tg.GetCreated(), 0).UTC()
+	}
+
+	ctx.Captures, ctx.NamedCaptures = repo.FilterCaptures(tg.Name())
+
+	return ctx
+}
+
+// sampleTemplateContext makes template context with sample data for the repository passed (to validate templates against),
+// there are exactly as many sample captures as repository /FILTER/ has (none, if repository is nil or has no filter)
+func sampleTemplateContext(repo *repository.Repository) *TemplateContext {
+	if repo == nil {
+		repo, _ = repository.ParseRef("registry.company.io/sample/repo:v1.0.0")
+	}
+
+	tg, _ := tag.New("v1.0.0", tag.Options{Digest: "sha256:" + strings.Repeat("0", 64), Created: time.Now().Unix()})
+
+	ctx := newTemplateContext(repo, tg, func() (*layout.ImageConfig, error) {
+		return &layout.ImageConfig{OS: "linux", Architecture: "amd64"}, nil
+	})
+
+	// sample tag does not necessarily match the filter, so we make captures ourselves
+	ctx.Captures, ctx.NamedCaptures = nil, nil
+
+	if names := repo.FilterSubexpNames(); names != nil {
+		ctx.Captures = make([]string, len(names))
+		ctx.NamedCaptures = make(map[string]string)
+
+		for i, name := range names {
+			ctx.Captures[i] = "sample"
+
+			if name != "" {
+				ctx.NamedCaptures[name] = "sample"
+			}
+		}
+	}
+
+	return ctx
+}
+
+// shortHexDigest gets first 12 hex characters of the digest passed (with no algorithm), e.g. "db9c658d6d02"
+func shortHexDigest(digest string) string {
+	const limit = 12
+
+	hex := digest[strings.Index(digest, ":")+1:]
+	if len(hex) < limit {
+		return hex
+	}
+
+	return hex[0:limit]
+}
+
+// templateContext makes template context for the repository tag passed (image configuration is loaded from registry)
+func (api *API) templateContext(repo *repository.Repository, tg *tag.Tag) *TemplateContext {
+	return newTemplateContext(repo, tg, func() (*layout.ImageConfig, error) {
+		return api.imageConfig(repo, tg.GetDigest())
+	})
+}
+
+// imageConfig gets configuration of the image from registry (configurations are cached by image digest)
+func (api *API) imageConfig(repo *repository.Repository, digest string) (*layout.ImageConfig, error) {
+	key := repo.Full() + "@" + digest
+
+	if config, defined := api.imageConfigs.Load(key); defined {
+		return config.(*layout.ImageConfig), nil
+	}
+
+	username, password := api.credentials(repo.Registry(), api.dockerClient.Config())
+
+	cli, err := api.remote.NewClient(repo, username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	mediaType, _, data, err := cli.Manifest(repo.Path(), digest)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := loadImageConfig(
+		mediaType,
+		data,
+		func(d layout.Descriptor) ([]byte, error) {
+			_, _, data, err := cli.Manifest(repo.Path(), d.Digest)
+
+			return data, err
+		},
+		func(d layout.Descriptor) ([]byte, error) {
+			blob, err := cli.Blob(repo.Path(), d.Digest)
+			if err != nil {
+				return nil, err
+			}
+			defer blob.Close()
+
+			return ioutil.ReadAll(blob)
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	api.imageConfigs.Store(key, config)
+
+	return config, nil
+}
+
+// layoutTemplateContext makes template context for the image from OCI layout passed
+func layoutTemplateContext(l *layout.Layout, repo *repository.Repository, tagName string, d layout.Descriptor) (*TemplateContext, error) {
+	var created int64
+	if t := layout.Created(d); !t.IsZero() {
+		created = t.Unix()
+	}
+
+	tg, err := tag.New(tagName, tag.Options{Digest: d.Digest, Created: created})
+	if err != nil {
+		return nil, err
+	}
+
+	readBlob := func(d layout.Descriptor) ([]byte, error) {
+		return l.ReadBlobBytes(d.Digest)
+	}
+
+	return newTemplateContext(repo, tg, func() (*layout.ImageConfig, error) {
+		data, err := readBlob(d)
+		if err != nil {
+			return nil, err
+		}
+
+		return loadImageConfig(d.MediaType, data, readBlob, readBlob)
+	}), nil
+}
+
+// templatePlatform is a platform we take labels and platform of the multi-platform image (index) from for templates:
+// we have to pick a single one and images built for many platforms are built for this one in the first place
+const templatePlatform = "linux/amd64"
+
+// pickManifest picks manifest of the index to take image configuration from: the one for template platform,
+// the first one with a known platform, if there is no such (e.g. attestations are "unknown/unknown"), or just the first one
+func pickManifest(children []layout.Descriptor) layout.Descriptor {
+	for _, child := range children {
+		if child.Platform != nil && child.Platform.OS+"/"+child.Platform.Architecture == templatePlatform {
+			return child
+		}
+	}
+
+	for _, child := range children {
+		if child.Platform != nil && child.Platform.OS != "unknown" && child.Platform.Architecture != "unknown" {
+			return child
+		}
+	}
+
+	return children[0]
+}
+
+// loadImageConfig loads configuration of the image by its manifest (or by the manifest index picks, see pickManifest) passed,
+// manifests and blobs referenced are fetched with getters passed
+func loadImageConfig(
+	mediaType string,
+	data []byte,
+	getManifest, getBlob func(d layout.Descriptor) ([]byte, error),
+) (*layout.ImageConfig, error) {
+	if layout.IsIndex(mediaType) {
+		children, err := layout.Children(mediaType, data)
+		if err != nil {
+			return nil, err
+		}
+		if len(children) == 0 {
+			return nil, fmt.Errorf("image index has no manifests")
+		}
+
+		child := pickManifest(children)
+
+		mediaType = child.MediaType
+
+		data, err = getManifest(child)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !layout.IsManifest(mediaType) {
+		return nil, fmt.Errorf("unsupported manifest media type: %s", mediaType)
+	}
+
+	var manifest layout.Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+
+	data, err := getBlob(manifest.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	var config layout.ImageConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
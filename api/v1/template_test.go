@@ -0,0 +1,264 @@
+package v1
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ivanilves/lstags/api/v1/plan"
+	"github.com/ivanilves/lstags/api/v1/registry/client"
+	"github.com/ivanilves/lstags/oci/layout"
+	"github.com/ivanilves/lstags/repository"
+	"github.com/ivanilves/lstags/tag"
+)
+
+// seedLabeledImage pushes a minimal (config only) image with labels passed into the registry
+func seedLabeledImage(t *testing.T, registry, repoPath, tagName string, labels map[string]string) {
+	assert := assert.New(t)
+
+	cli, err := client.New(registry, client.Config{IsInsecure: true})
+	assert.Nil(err)
+	assert.Nil(cli.Login("", ""))
+
+	config := layout.ImageConfig{OS: "linux", Architecture: "amd64"}
+	config.Config.Labels = labels
+
+	data, err := json.Marshal(config)
+	assert.Nil(err)
+	assert.Nil(cli.PutBlob(repoPath, layout.Digest(data), int64(len(data)), bytes.NewReader(data)))
+
+	manifest := layout.Manifest{
+		SchemaVersion: 2,
+		MediaType:     layout.MediaTypeOCIManifest,
+		Config: layout.Descriptor{
+			MediaType: "application/vnd.oci.image.config.v1+json",
+			Digest:    layout.Digest(data),
+			Size:      int64(len(data)),
+		},
+		Layers: []layout.Descriptor{},
+	}
+
+	data, err = json.Marshal(manifest)
+	assert.Nil(err)
+	assert.Nil(cli.PutManifest(repoPath, tagName, layout.MediaTypeOCIManifest, data))
+}
+
+func TestParseSemver(t *testing.T) {
+	var testCases = map[string]Semver{
+		"1.2.3":               {Valid: true, Major: 1, Minor: 2, Patch: 3},
+		"v0.10.0":             {Valid: true, Major: 0, Minor: 10, Patch: 0},
+		"v1.2.3-rc.1+build.5": {Valid: true, Major: 1, Minor: 2, Patch: 3, Prerelease: "rc.1", Metadata: "build.5"},
+		"1.2":                 {},
+		"01.2.3":              {},
+		"latest":              {},
+	}
+
+	assert := assert.New(t)
+
+	for s, expected := range testCases {
+		assert.Equal(expected, parseSemver(s), s)
+	}
+}
+
+func TestNewTemplateContext(t *testing.T) {
+	assert := assert.New(t)
+
+	repo, _ := repository.ParseRef(`quay.io/coreos/flannel~/^v(?P<major>[0-9]+)\.([0-9]+)\./`)
+	tg, _ := tag.New("v0.10.0", tag.Options{Digest: "sha256:db9c658d6d02b24f5fbbf3d7802e34453fc7dac25cc8a18da2b3c0341fd8c896", Created: 1516708800})
+
+	ctx := newTemplateContext(repo, tg, nil)
+
+	assert.Equal("quay.io", ctx.Registry)
+	assert.Equal("quay.io/coreos/flannel", ctx.Name)
+	assert.Equal("v0.10.0", ctx.Tag)
+	assert.Equal("db9c658d6d02", ctx.ShortDigest)
+	assert.Equal(time.Date(2018, 1, 23, 12, 0, 0, 0, time.UTC), ctx.Created)
+	assert.Equal(Semver{Valid: true, Major: 0, Minor: 10, Patch: 0}, ctx.Semver)
+	assert.Equal([]string{"v0.10.", "0", "10"}, ctx.Captures)
+	assert.Equal(map[string]string{"major": "0"}, ctx.NamedCaptures)
+
+	labels, err := ctx.Labels()
+	assert.Nil(err)
+	assert.Empty(labels, "should give no labels, if there is nothing to load them from")
+
+	ctx, err = ctx.forPush(PushConfig{Prefix: "/mirror", PathSeparator: "-"})
+	assert.Nil(err)
+	assert.Equal("/mirror/", ctx.Prefix)
+	assert.Equal("coreos-flannel", ctx.Path)
+
+	pushTag, err := makePushTagTemplate(PushConfig{TagTemplate: `{{ .Semver.Major }}.{{ .Semver.Minor }}-{{ index .Captures 2 }}-{{ .ShortDigest }}`})
+	assert.Nil(err)
+
+	actual, err := pushTag(ctx)
+	assert.Nil(err)
+	assert.Equal("0.10-10-db9c658d6d02", actual)
+}
+
+func TestPickManifest(t *testing.T) {
+	assert := assert.New(t)
+
+	descriptor := func(digest string, platform *layout.Platform) layout.Descriptor {
+		return layout.Descriptor{Digest: digest, Platform: platform}
+	}
+
+	attestation := descriptor("sha256:attestation", &layout.Platform{OS: "unknown", Architecture: "unknown"})
+	arm64 := descriptor("sha256:arm64", &layout.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"})
+	amd64 := descriptor("sha256:amd64", &layout.Platform{OS: "linux", Architecture: "amd64"})
+	noPlatform := descriptor("sha256:noplatform", nil)
+
+	assert.Equal(amd64, pickManifest([]layout.Descriptor{attestation, arm64, amd64}), "should pick template platform")
+	assert.Equal(arm64, pickManifest([]layout.Descriptor{attestation, arm64}), "should pick the first known platform")
+	assert.Equal(noPlatform, pickManifest([]layout.Descriptor{noPlatform, attestation}), "should pick the first manifest")
+}
+
+func TestTemplateContext_ImageConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(newFakeRegistry())
+	defer server.Close()
+
+	registry := strings.TrimPrefix(server.URL, "http://")
+
+	seedFakeRegistry(t, registry)
+	seedLabeledImage(t, registry, "lstags/labeled", "1.2.3", map[string]string{"team": "infra"})
+
+	api, err := New(Config{})
+	assert.Nil(err)
+
+	for ref, expected := range map[string]struct {
+		platform string
+		labels   map[string]string
+	}{
+		registry + "/lstags/labeled:1.2.3":   {"linux/amd64", map[string]string{"team": "infra"}},
+		registry + "/lstags/flannel:v0.11.0": {"linux/amd64", map[string]string{}}, // template platform of the index
+	} {
+		cn, err := api.CollectTags(ref)
+		assert.Nil(err, ref)
+
+		repo := cn.Repo(cn.Refs()[0])
+		ctx := api.templateContext(repo, cn.Tags(repo.Ref())[0])
+
+		platform, err := ctx.Platform()
+		assert.Nil(err, ref)
+		assert.Equal(expected.platform, platform, ref)
+
+		labels, err := ctx.Labels()
+		assert.Nil(err, ref)
+		assert.Equal(expected.labels, labels, ref)
+	}
+}
+
+func TestPushTags_RouteByLabel(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(newFakeRegistry())
+	defer server.Close()
+
+	registry := strings.TrimPrefix(server.URL, "http://")
+
+	seedLabeledImage(t, registry, "lstags/labeled", "1.2.3", map[string]string{"team": "infra"})
+	seedLabeledImage(t, registry, "lstags/labeled", "1.2.4", map[string]string{"team": "web"})
+
+	target := newFakeRegistry()
+
+	targetServer := httptest.NewServer(target)
+	defer targetServer.Close()
+
+	push := PushConfig{
+		Registry:      strings.TrimPrefix(targetServer.URL, "http://"),
+		Prefix:        "/mirror",
+		PathSeparator: "/",
+		PathTemplate:  `{{ .Prefix }}{{ index .Labels "team" }}/{{ .Path | base }}`,
+		TagTemplate:   "{{ .Tag }}",
+	}
+
+	dir, err := ioutil.TempDir("", "lstags-oci-layout-")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	api, err := New(Config{OCILayoutDir: dir})
+	assert.Nil(err)
+
+	cn, err := api.CollectTags(registry + "/lstags/labeled")
+	assert.Nil(err)
+
+	assert.Nil(api.PushTags(cn, push))
+
+	for _, key := range []string{"mirror/infra/labeled@1.2.3", "mirror/web/labeled@1.2.4"} {
+		_, defined := target.manifests[key]
+		assert.True(defined, key)
+	}
+
+	pushCn, err := api.CollectPushTags(cn, push)
+	assert.Nil(err)
+	assert.Equal(0, pushCn.TagCount(), "should find tags already pushed to paths they are routed to")
+}
+
+func TestPushTags_TagTemplate(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(newFakeRegistry())
+	defer server.Close()
+
+	registry := strings.TrimPrefix(server.URL, "http://")
+
+	seedFakeRegistry(t, registry)
+
+	target := newFakeRegistry()
+
+	targetServer := httptest.NewServer(target)
+	defer targetServer.Close()
+
+	push := PushConfig{
+		Registry:      strings.TrimPrefix(targetServer.URL, "http://"),
+		Prefix:        "/mirror",
+		PathSeparator: "/",
+		PathTemplate:  "{{ .Prefix }}{{ .Path }}",
+		TagTemplate:   "{{ .Tag }}-{{ .ShortDigest }}",
+	}
+
+	dir, err := ioutil.TempDir("", "lstags-oci-layout-")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	api, err := New(Config{OCILayoutDir: dir})
+	assert.Nil(err)
+
+	ref := registry + "/lstags/flannel"
+
+	cn, err := api.CollectTags(ref)
+	assert.Nil(err)
+
+	pushCn, err := api.CollectPushTags(cn, push)
+	assert.Nil(err)
+	assert.Len(pushCn.Tags(ref), 2)
+
+	assert.Nil(api.PushTags(pushCn, push))
+
+	for _, tg := range cn.Tags(ref) {
+		key := "mirror/lstags/flannel@" + tg.Name() + "-" + shortHexDigest(tg.GetDigest())
+
+		_, defined := target.manifests[key]
+		assert.True(defined, key)
+	}
+
+	pushCn, err = api.CollectPushTags(cn, push)
+	assert.Nil(err)
+	assert.Empty(pushCn.Tags(ref), "should find tags already pushed under their templated names")
+
+	p, err := api.MakePlan(cn, []PushConfig{push})
+	assert.Nil(err)
+	if assert.Len(p.Entries, 2) {
+		for _, e := range p.Entries {
+			assert.Equal(plan.Skip, e.Action, e.SourceRef)
+			assert.Equal("present in destination", e.Reason, e.SourceRef)
+		}
+	}
+}
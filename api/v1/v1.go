@@ -3,39 +3,50 @@
 package v1
 
 import (
-	"bufio"
 	"bytes"
-	"encoding/json"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"html/template"
-	"io"
+	"io/ioutil"
 	"regexp"
 	"runtime"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Masterminds/sprig/v3"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/ivanilves/lstags/api/v1/collection"
+	"github.com/ivanilves/lstags/api/v1/registry/client"
+	basicstore "github.com/ivanilves/lstags/api/v1/registry/client/auth/basic/store"
 	"github.com/ivanilves/lstags/api/v1/registry/client/cache"
 	dockerclient "github.com/ivanilves/lstags/docker/client"
 	dockerconfig "github.com/ivanilves/lstags/docker/config"
+	"github.com/ivanilves/lstags/docker/stream"
+	"github.com/ivanilves/lstags/oci/layout"
 	"github.com/ivanilves/lstags/repository"
 	"github.com/ivanilves/lstags/tag"
 	"github.com/ivanilves/lstags/tag/local"
 	"github.com/ivanilves/lstags/tag/remote"
-	"github.com/ivanilves/lstags/util/wait"
+	"github.com/ivanilves/lstags/util/fix"
+	"github.com/ivanilves/lstags/util/pool"
+	"github.com/ivanilves/lstags/util/ratelimit"
+	"github.com/ivanilves/lstags/util/redact"
 )
 
 // Config holds API instance configuration
 type Config struct {
 	// DockerJSONConfigFile is a path to Docker JSON config file
 	DockerJSONConfigFile string
-	// ConcurrentRequests defines how much requests to registry we could run in parallel
+	// ConcurrentRequests defines how much requests to every registry (and how much pulls and pushes) we could run in parallel
 	ConcurrentRequests int
-	// WaitBetween defines how much we will wait between batches of requests (incl. pull and push)
+	// RequestRate defines how much requests per second we could send to every registry (0 means "no limit")
+	RequestRate float64
+	// WaitBetween defines how much we will wait between requests to the same registry (used if no RequestRate set)
 	WaitBetween time.Duration
 	// TraceRequests sets if we will print out registry HTTP request traces
 	TraceRequests bool
@@ -49,6 +60,193 @@ type Config struct {
 	VerboseLogging bool
 	// DryRun sets if we will dry run pull or push
 	DryRun bool
+	// Registries hold registry-specific configurations (keyed by registry ADDR[:PORT])
+	Registries map[string]RegistryConfig
+	// OCILayoutDir is a path to OCI image layout directory to be used as a local image store instead of Docker daemon
+	OCILayoutDir string
+	// KubernetesSecretFile is a path to mounted "kubernetes.io/dockerconfigjson" secret file to take credentials from
+	KubernetesSecretFile string
+	// NetrcFile is a path to netrc-style file to take credentials from
+	NetrcFile string
+	// BasicAuth is a list of explicitly set BASIC auth logins ("REGISTRY[:PORT] username:password" strings)
+	BasicAuth []string
+	// CacheDir is a path to directory to keep persistent tag metadata cache in (no cache is used, if empty)
+	CacheDir string
+	// CacheTTL is a time we keep metadata of tags not seen in cache for (7 days, if not set)
+	CacheTTL time.Duration
+}
+
+// requestRate gets per-registry request rate, either configured explicitly or derived from WaitBetween
+func (config Config) requestRate() float64 {
+	if config.RequestRate == 0 && config.WaitBetween > 0 {
+		return 1 / config.WaitBetween.Seconds()
+	}
+
+	return config.RequestRate
+}
+
+// redacted makes a copy of the config with registry passwords redacted (unless secrets are revealed), to log it safely
+func (config Config) redacted() Config {
+	if redact.IsRevealed() {
+		return config
+	}
+
+	if len(config.BasicAuth) != 0 {
+		basicAuth := make([]string, len(config.BasicAuth))
+		for i, a := range config.BasicAuth {
+			basicAuth[i] = a
+
+			ss := strings.SplitN(a, " ", 2)
+			if len(ss) != 2 {
+				continue
+			}
+			if up := strings.SplitN(ss[1], ":", 2); len(up) == 2 {
+				basicAuth[i] = ss[0] + " " + up[0] + ":" + redact.Mask
+			}
+		}
+		config.BasicAuth = basicAuth
+	}
+
+	if len(config.Registries) == 0 {
+		return config
+	}
+
+	registries := make(map[string]RegistryConfig, len(config.Registries))
+	for registry, rc := range config.Registries {
+		if rc.Password != "" {
+			rc.Password = redact.Mask
+		}
+
+		registries[registry] = rc
+	}
+	config.Registries = registries
+
+	return config
+}
+
+// RegistryConfig holds registry-specific configuration (zero values mean "use general configuration")
+type RegistryConfig struct {
+	// Scheme is either "http" or "https" (by default it is detected by InsecureRegistryEx)
+	Scheme string
+	// InsecureSkipVerify sets if we skip verification of registry TLS certificate
+	InsecureSkipVerify bool
+	// CAFile is a path to PEM file with additional CA certificate(s) to verify registry with
+	CAFile string
+	// CertFile is a path to PEM file with TLS client certificate
+	CertFile string
+	// KeyFile is a path to PEM file with TLS client key
+	KeyFile string
+	// Username is a username to authenticate against the registry with
+	Username string
+	// Password is a password to authenticate against the registry with
+	Password string
+	// DockerJSONConfigFile is a path to Docker JSON config file to take registry credentials from
+	DockerJSONConfigFile string
+	// ConcurrentRequests defines how much requests to the registry we could run in parallel
+	ConcurrentRequests int
+	// RequestRate defines how much requests per second we could send to the registry
+	RequestRate float64
+	// RetryRequests defines how much retries we will do to the failed HTTP request
+	RetryRequests int
+	// RetryDelay defines how much we will wait between failed HTTP request and retry
+	RetryDelay time.Duration
+	// Mirrors are mirror endpoints (ADDR[:PORT]) we try to read from before falling back to the registry
+	Mirrors []string
+}
+
+func (rc RegistryConfig) tlsConfig() (*tls.Config, error) {
+	if !rc.InsecureSkipVerify && rc.CAFile == "" && rc.CertFile == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: rc.InsecureSkipVerify}
+
+	if rc.CAFile != "" {
+		pem, err := ioutil.ReadFile(fix.Path(rc.CAFile))
+		if err != nil {
+			return nil, err
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no valid CA certificates found in: %s", rc.CAFile)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	if rc.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(fix.Path(rc.CertFile), fix.Path(rc.KeyFile))
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// insecureRegistryRE gets compiled regex to match insecure (non-HTTPS) registries
+func (config Config) insecureRegistryRE() (*regexp.Regexp, error) {
+	if config.InsecureRegistryEx == "" {
+		return regexp.Compile(repository.DefaultInsecureRegistryEx)
+	}
+
+	return regexp.Compile(config.InsecureRegistryEx)
+}
+
+func (rc RegistryConfig) clientConfig(registry string, config Config) (client.Config, error) {
+	var isInsecure bool
+
+	switch rc.Scheme {
+	case "":
+		insecureRegistryRE, err := config.insecureRegistryRE()
+		if err != nil {
+			return client.Config{}, err
+		}
+
+		isInsecure = insecureRegistryRE.MatchString(registry)
+	case "http":
+		isInsecure = true
+	case "https":
+		isInsecure = false
+	default:
+		return client.Config{}, fmt.Errorf("unsupported scheme for registry '%s': %s", registry, rc.Scheme)
+	}
+
+	tlsConfig, err := rc.tlsConfig()
+	if err != nil {
+		return client.Config{}, err
+	}
+
+	return client.Config{
+		ConcurrentRequests: rc.ConcurrentRequests,
+		RetryRequests:      rc.RetryRequests,
+		RetryDelay:         rc.RetryDelay,
+		IsInsecure:         isInsecure,
+		TLSConfig:          tlsConfig,
+		Limiter:            rc.limiter(config),
+		Mirrors:            rc.Mirrors,
+	}, nil
+}
+
+// limiter makes registry-specific limiter, general configuration limits are used, if registry ones are not set
+func (rc RegistryConfig) limiter(config Config) *ratelimit.Limiter {
+	rate := rc.RequestRate
+	if rate == 0 {
+		rate = config.requestRate()
+	}
+
+	concurrency := rc.ConcurrentRequests
+	if concurrency == 0 {
+		concurrency = config.ConcurrentRequests
+	}
+
+	return ratelimit.New(rate, concurrency)
 }
 
 // PushConfig holds push-specific configuration (where to push and with which prefix)
@@ -61,10 +259,97 @@ type PushConfig struct {
 	UpdateChanged bool
 	// PathSeparator defines which path separator to use (default: "/")
 	PathSeparator string
-	// PathTemplate is a template to change push path, sprig functions are supprted
+	// PathTemplate is a template to change push path, sprig functions are supprted (see TemplateContext for data passed)
 	PathTemplate string
-	// TagTemplate is a template to change push tag, sprig functions are supprted
+	// TagTemplate is a template to change push tag, sprig functions are supprted (see TemplateContext for data passed),
+	// empty template means the tag is pushed as is (the same as "{{ .Tag }}")
 	TagTemplate string
+	// DockerJSONConfigFile is a path to Docker JSON config file with push registry credentials (optional)
+	DockerJSONConfigFile string
+	// Cleanup tells us to remove local images pulled solely to be pushed (and local tags created to push them)
+	Cleanup bool
+	// ProtectedTags is a regular expression matching tags never to be overwritten in the registry (e.g. release tags),
+	// protected tags having different digest there are reported as conflicts, even if we update changed tags
+	// NB! Expression is matched against tags as they are pushed (i.e. rendered with TagTemplate), not source ones.
+	ProtectedTags string
+	// RepoOverrides are complete per-repository push configurations (keyed by repository reference),
+	// used instead of this one for the repositories they are defined for
+	RepoOverrides map[string]PushConfig
+}
+
+// Validate checks push configuration for correctness (compiles templates and executes them against sample data)
+func (push PushConfig) Validate() error {
+	return push.ValidateFor(nil)
+}
+
+// ValidateFor does the same as Validate, but executes templates against sample data of the repository passed
+// (e.g. with exactly as many filter captures as repository /FILTER/ has)
+func (push PushConfig) ValidateFor(repo *repository.Repository) error {
+	ctx, err := sampleTemplateContext(repo).forPush(push)
+	if err != nil {
+		return err
+	}
+
+	pushPathTemplate, err := makePushPathTemplate(push)
+	if err != nil {
+		return fmt.Errorf("invalid push path template: %s", err.Error())
+	}
+	if _, err := pushPathTemplate(ctx); err != nil {
+		return fmt.Errorf("invalid push path template: %s", err.Error())
+	}
+
+	pushTagTemplate, err := makePushTagTemplate(push)
+	if err != nil {
+		return fmt.Errorf("invalid push tag template: %s", err.Error())
+	}
+	if _, err := pushTagTemplate(ctx); err != nil {
+		return fmt.Errorf("invalid push tag template: %s", err.Error())
+	}
+
+	if _, err := regexp.Compile(push.ProtectedTags); err != nil {
+		return fmt.Errorf("invalid protected tags expression: %s", err.Error())
+	}
+
+	return nil
+}
+
+// ForRef gets push configuration for the repository reference passed
+func (push PushConfig) ForRef(ref string) PushConfig {
+	override, defined := push.RepoOverrides[ref]
+	if !defined {
+		return push
+	}
+
+	return override
+}
+
+// forImage gets push configuration and repository for the image (repository name and tag) we have no configured
+// reference for (e.g. one imported from bundle): if some repository override reference matches the image,
+// both its push configuration and its repository (so its filter captures are available in templates) are used
+func (push PushConfig) forImage(name, tagName string) (PushConfig, *repository.Repository, error) {
+	refs := make([]string, 0, len(push.RepoOverrides))
+	for ref := range push.RepoOverrides {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+
+	for _, ref := range refs {
+		repo, err := repository.ParseRef(ref)
+		if err != nil {
+			return PushConfig{}, nil, err
+		}
+
+		if repo.Name() == name && repo.MatchTag(tagName) {
+			return push.RepoOverrides[ref], repo, nil
+		}
+	}
+
+	repo, err := repository.ParseRef(name)
+	if err != nil {
+		return PushConfig{}, nil, err
+	}
+
+	return push, repo, nil
 }
 
 // API represents configured application API instance,
@@ -72,74 +357,163 @@ type PushConfig struct {
 type API struct {
 	config       Config
 	dockerClient *dockerclient.DockerClient
+	layout       *layout.Layout
+	pool         *pool.Pool
+	remote       *remote.Remote
+	subscribers  *subscribers
+	metadata     *cache.Metadata
+	imageConfigs sync.Map
+
+	dockerConfigs map[string]*dockerconfig.Config
+	before        []dockerconfig.Provider
+	after         []dockerconfig.Provider
+	mux           sync.Mutex
 }
 
-// rtags is a structure to send collection of referenced tags using chan
-type rtags struct {
-	ref  string
-	tags []*tag.Tag
+// dockerConfig gets Docker JSON config loaded from file passed (configs are loaded only once)
+func (api *API) dockerConfig(fileName string) (*dockerconfig.Config, error) {
+	api.mux.Lock()
+	defer api.mux.Unlock()
+
+	dockerConfig, defined := api.dockerConfigs[fileName]
+	if !defined {
+		var err error
+
+		dockerConfig, err = dockerconfig.Load(fileName)
+		if err != nil {
+			return nil, err
+		}
+		dockerConfig.WithProviders(api.before, api.after)
+
+		api.dockerConfigs[fileName] = dockerConfig
+	}
+
+	return dockerConfig, nil
 }
 
-// fn gives the name of the calling function (e.g. enriches log.Debugf() output)
-// + optionally attaches free form string labels (mainly to identify goroutines)
-func fn(labels ...string) string {
-	function, _, _, _ := runtime.Caller(1)
+// makeCredentialProviders makes additional credential providers to be consulted before and after Docker config
+// Resulting precedence is:
+// * BASIC auth store (-B)
+// * per-registry environment variables (LSTAGS_REGISTRY_<HOST>_USERNAME / LSTAGS_REGISTRY_<HOST>_PASSWORD)
+// * Docker JSON config (incl. credential helpers)
+// * mounted Kubernetes "kubernetes.io/dockerconfigjson" secret file
+// * netrc-style file
+func makeCredentialProviders(config Config, basicStore *basicstore.Store) ([]dockerconfig.Provider, []dockerconfig.Provider, error) {
+	before := []dockerconfig.Provider{basicStore, dockerconfig.EnvProvider{}}
+	after := make([]dockerconfig.Provider, 0)
+
+	if config.KubernetesSecretFile != "" {
+		c, err := dockerconfig.Load(config.KubernetesSecretFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to load Kubernetes secret file '%s': %s", config.KubernetesSecretFile, err.Error())
+		}
 
-	longname := runtime.FuncForPC(function).Name()
+		after = append(after, c)
+	}
 
-	nameparts := strings.Split(longname, ".")
-	shortname := nameparts[len(nameparts)-1]
+	if config.NetrcFile != "" {
+		n, err := dockerconfig.LoadNetrc(config.NetrcFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to load netrc file '%s': %s", config.NetrcFile, err.Error())
+		}
 
-	if labels == nil {
-		return fmt.Sprintf("[%s()]", shortname)
+		after = append(after, n)
 	}
 
-	return fmt.Sprintf("[%s():%s]", shortname, strings.Join(labels, ":"))
+	return before, after, nil
+}
+
+// saveMetadata saves persistent tag metadata cache (if any), failure to save it is not critical for us
+func (api *API) saveMetadata() {
+	if err := api.metadata.Save(); err != nil {
+		log.Warnf("%s unable to save metadata cache: %s", fn(), err.Error())
+	}
 }
 
-func getBatchedSlices(batchSize int, unbatched ...string) [][]string {
-	batchedSlices := make([][]string, 0)
+// resetCredentialsCache makes all Docker configs loaded forget credential helper results, so every run gets fresh ones
+func (api *API) resetCredentialsCache() {
+	api.dockerClient.Config().ResetCredentialsCache()
 
-	index := 0
+	api.mux.Lock()
+	defer api.mux.Unlock()
 
-	for range unbatched {
-		batchedSlice := make([]string, 0)
+	for _, dockerConfig := range api.dockerConfigs {
+		dockerConfig.ResetCredentialsCache()
+	}
+}
 
-		for c := 0; c < batchSize; c++ {
-			batchedSlice = append(batchedSlice, unbatched[index])
+// credentials gets credentials for the registry passed, registry-specific configuration goes first
+func (api *API) credentials(registry string, dockerConfig *dockerconfig.Config) (string, string) {
+	rc, defined := api.config.Registries[registry]
+	if defined {
+		if rc.Username != "" {
+			return rc.Username, rc.Password
+		}
 
-			index++
+		if rc.DockerJSONConfigFile != "" {
+			c, err := api.dockerConfig(rc.DockerJSONConfigFile)
+			if err != nil {
+				log.Warnf("%s unable to load registry '%s' credentials: %s", fn(), registry, err.Error())
 
-			if index == len(unbatched) {
-				break
+				return "", ""
 			}
-		}
 
-		batchedSlices = append(batchedSlices, batchedSlice)
+			username, password, _ := c.GetCredentials(registry)
 
-		if index == len(unbatched) {
-			break
+			return username, password
 		}
 	}
 
-	return batchedSlices
+	username, password, _ := dockerConfig.GetCredentials(registry)
+
+	return username, password
+}
+
+// pushDockerClient gets Docker client using credentials specific for the push configuration passed
+func (api *API) pushDockerClient(push PushConfig) (*dockerclient.DockerClient, error) {
+	if push.DockerJSONConfigFile == "" {
+		return api.dockerClient, nil
+	}
+
+	dockerConfig, err := api.dockerConfig(push.DockerJSONConfigFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return api.dockerClient.WithConfig(dockerConfig), nil
+}
+
+// rtags is a structure to collect referenced tags from concurrent jobs
+type rtags struct {
+	ref  string
+	tags []*tag.Tag
+}
+
+// fn gives the name of the calling function (e.g. enriches log.Debugf() output)
+// + optionally attaches free form string labels (mainly to identify goroutines)
+func fn(labels ...string) string {
+	function, _, _, _ := runtime.Caller(1)
+
+	longname := runtime.FuncForPC(function).Name()
+
+	nameparts := strings.Split(longname, ".")
+	shortname := nameparts[len(nameparts)-1]
+
+	if labels == nil {
+		return fmt.Sprintf("[%s()]", shortname)
+	}
+
+	return fmt.Sprintf("[%s():%s]", shortname, strings.Join(labels, ":"))
 }
 
-func receiveTags(tagc chan rtags) map[string][]*tag.Tag {
+// collectedTags makes map of tags (keyed by reference) from the tag sets collected
+func collectedTags(collected []rtags) map[string][]*tag.Tag {
 	tags := make(map[string][]*tag.Tag)
 
-	step := 1
-	size := cap(tagc)
-	for t := range tagc {
+	for _, t := range collected {
 		log.Debugf("[%s] receiving tags: %+v", t.ref, t.tags)
 
 		tags[t.ref] = t.tags
-
-		if step >= size {
-			close(tagc)
-		}
-
-		step++
 	}
 
 	return tags
@@ -147,6 +521,8 @@ func receiveTags(tagc chan rtags) map[string][]*tag.Tag {
 
 // CollectTags collects information on tags present in remote registry and [local] Docker daemon,
 // makes required comparisons between them and spits organized info back as collection.Collection
+// NB! If we fail to collect tags for some repositories, we return partial collection (with failed
+// references having no tags and their errors accessible via collection Err() / Errors()) AND *CollectError
 func (api *API) CollectTags(refs ...string) (*collection.Collection, error) {
 	if len(refs) == 0 {
 		return nil, fmt.Errorf("no image references passed")
@@ -157,67 +533,101 @@ func (api *API) CollectTags(refs ...string) (*collection.Collection, error) {
 		return nil, err
 	}
 
-	tagc := make(chan rtags, len(refs))
+	api.resetCredentialsCache()
 
-	batchedSlicesOfRefs := getBatchedSlices(api.config.ConcurrentRequests, refs...)
+	log.Debugf("%s references: %+v", fn(), refs)
 
-	for bindex, brefs := range batchedSlicesOfRefs {
-		log.Infof("BATCH %d of %d", bindex+1, len(batchedSlicesOfRefs))
-
-		log.Debugf("%s references: %+v", fn(), brefs)
-
-		repos, _ := repository.ParseRefs(brefs)
-		for _, repo := range repos {
-			log.Debugf("%s repository: %+v", fn(), repo)
-		}
+	repos, _ := repository.ParseRefs(refs)
+	for _, repo := range repos {
+		log.Debugf("%s repository: %+v", fn(), repo)
+	}
 
-		done := make(chan error, len(repos))
+	collected := make([]rtags, len(repos))
 
-		for _, repo := range repos {
-			go func(repo *repository.Repository, done chan error) {
-				log.Infof("ANALYZE %s", repo.Ref())
+	jobs := make([]pool.Job, len(repos))
+	for i, repo := range repos {
+		i, repo := i, repo
 
-				username, password, _ := api.dockerClient.Config().GetCredentials(repo.Registry())
+		jobs[i] = func() error {
+			log.Infof("ANALYZE %s", repo.Ref())
+			api.emit(Event{Type: EventAnalyzeStart, Ref: repo.Ref()})
 
-				remoteTags, err := remote.FetchTags(repo, username, password)
-				if err != nil {
-					done <- err
-					return
-				}
-				log.Debugf("%s remote tags: %+v", fn(repo.Ref()), remoteTags)
+			username, password := api.credentials(repo.Registry(), api.dockerClient.Config())
 
-				localTags, _ := local.FetchTags(repo, api.dockerClient)
+			remoteTags, err := api.remote.FetchTags(repo, username, password)
+			if err != nil {
+				return err
+			}
+			log.Debugf("%s remote tags: %+v", fn(repo.Ref()), remoteTags)
 
-				log.Debugf("%s local tags: %+v", fn(repo.Ref()), localTags)
+			var localTags map[string]*tag.Tag
+			if api.layout != nil {
+				localTags, _ = local.FetchLayoutTags(repo, api.layout)
+			} else {
+				localTags, _ = local.FetchTags(repo, api.dockerClient)
+			}
 
-				sortedKeys, tagNames, joinedTags := tag.Join(
-					remoteTags,
-					localTags,
-					repo.Tags(),
-				)
-				log.Debugf("%s sending joined tags: %+v", fn(repo.Ref()), joinedTags)
+			log.Debugf("%s local tags: %+v", fn(repo.Ref()), localTags)
 
-				tagc <- rtags{ref: repo.Ref(), tags: tag.Collect(sortedKeys, tagNames, joinedTags)}
-				done <- nil
+			sortedKeys, tagNames, joinedTags := tag.Join(
+				remoteTags,
+				localTags,
+				repo.Tags(),
+			)
+			log.Debugf("%s sending joined tags: %+v", fn(repo.Ref()), joinedTags)
+
+			collected[i] = rtags{ref: repo.Ref(), tags: tag.Collect(sortedKeys, tagNames, joinedTags)}
+
+			for _, tg := range collected[i].tags {
+				api.emit(Event{
+					Type:   EventTagDiscovered,
+					Ref:    repo.Ref(),
+					Tag:    tg.Name(),
+					Digest: tg.GetDigest(),
+					State:  tg.GetState(),
+				})
+			}
 
-				log.Infof("FETCHED %s", repo.Ref())
+			log.Infof("FETCHED %s", repo.Ref())
+			api.emit(Event{Type: EventAnalyzeFinish, Ref: repo.Ref()})
 
-				return
-			}(repo, done)
+			return nil
 		}
+	}
 
-		if err := wait.Until(done); err != nil {
-			return nil, err
+	errs := make(map[string]error)
+	failedRefs := make([]string, 0)
+	for i, err := range api.pool.RunAll(jobs) {
+		if err == nil {
+			continue
 		}
 
-		time.Sleep(api.config.WaitBetween)
+		ref := repos[i].Ref()
+
+		log.Warnf("FAILED %s: %s", ref, err.Error())
+		api.emit(Event{Type: EventError, Ref: ref, Err: err})
+
+		collected[i] = rtags{ref: ref, tags: []*tag.Tag{}}
+		errs[ref] = err
+		failedRefs = append(failedRefs, ref)
 	}
 
-	tags := receiveTags(tagc)
+	api.saveMetadata()
+
+	tags := collectedTags(collected)
 
 	log.Debugf("%s tags: %+v", fn(), tags)
 
-	return collection.New(refs, tags)
+	cn, err := collection.NewWithErrors(refs, tags, errs)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(failedRefs) != 0 {
+		return cn, &CollectError{Refs: failedRefs, Errors: errs}
+	}
+
+	return cn, nil
 }
 
 func getPushPrefix(prefix, defaultPrefix string) string {
@@ -258,201 +668,357 @@ func validatePushPrefix(prefix string) error {
 
 // CollectPushTags blends passed collection with information fetched from [local] "push" registry,
 // makes required comparisons between them and spits organized info back as collection.Collection
+// (references we failed to collect tags for are skipped, but their errors are kept in the resulting collection)
+// NB! If some protected tags have different digest in "push" registry, we return "push" collection
+// (with no conflicting tags in it) along with *ConflictError.
 func (api *API) CollectPushTags(cn *collection.Collection, push PushConfig) (*collection.Collection, error) {
-	log.Debugf(
-		"%s collection: %+v (%d repos / %d tags)",
-		fn(), cn, cn.RepoCount(), cn.TagCount(),
-	)
-	log.Debugf("%s push config: %+v", fn(), push)
-
-	pushPathTemplate, terr := makePushPathTemplate(push)
-	if terr != nil {
-		return nil, terr
+	refs, joined, err := api.joinPushTags(cn, push)
+	if err != nil {
+		return nil, err
 	}
 
-	refs := make([]string, len(cn.Refs()))
-	done := make(chan error, len(cn.Refs()))
-	tagc := make(chan rtags, len(refs))
-
-	for i, repo := range cn.Repos() {
-		go func(repo *repository.Repository, i int, done chan error) {
-			refs[i] = repo.Ref()
-
-			pushPrefix := getPushPrefix(push.Prefix, repo.PushPrefix())
-			if err := validatePushPrefix(pushPrefix); err != nil {
-				done <- err
-				return
-			}
-
-			pushPath, perr := pushPathTemplate(pushPrefix, repo.PushPath(push.PathSeparator), repo.Name())
-			if perr != nil {
-				done <- perr
-				return
-			}
-			pushRef := fmt.Sprintf(
-				"%s%s~/.*/",
-				push.Registry,
-				pushPath,
-			)
-
-			log.Debugf("%s 'push' reference: %+v", fn(repo.Ref()), pushRef)
-
-			pushRepo, _ := repository.ParseRef(pushRef)
-
-			log.Infof("[PULL/PUSH] ANALYZE %s => %s", repo.Ref(), pushRef)
+	conflicts := make([]Conflict, 0)
 
-			username, password, _ := api.dockerClient.Config().GetCredentials(push.Registry)
+	collected := make([]rtags, len(joined))
+	for i, j := range joined {
+		push := push.ForRef(j.ref)
 
-			pushedTags, err := remote.FetchTags(pushRepo, username, password)
-			if err != nil {
-				if !strings.Contains(err.Error(), "404 Not Found") {
-					done <- err
-					return
+		tagsToPush := make([]*tag.Tag, 0)
+		for _, tg := range j.tags {
+			if tg.IsConflict() {
+				conflict, err := api.reportConflict(cn.Repo(j.ref), tg, push)
+				if err != nil {
+					return nil, err
 				}
 
-				log.Warnf("%s repo not found: %+s", fn(repo.Ref()), pushRef)
+				conflicts = append(conflicts, conflict)
 
-				pushedTags = make(map[string]*tag.Tag)
+				continue
 			}
-			log.Debugf("%s pushed tags: %+v", fn(repo.Ref()), pushedTags)
-
-			remoteTags := cn.TagMap(repo.Ref())
-			log.Debugf("%s remote tags: %+v", fn(repo.Ref()), remoteTags)
-
-			sortedKeys, tagNames, joinedTags := tag.Join(
-				remoteTags,
-				pushedTags,
-				repo.Tags(),
-			)
-			log.Debugf("%s joined tags: %+v", fn(repo.Ref()), joinedTags)
-
-			tagsToPush := make([]*tag.Tag, 0)
-			for _, key := range sortedKeys {
-				name := tagNames[key]
-				tg := joinedTags[name]
 
-				if tg.NeedsPush(push.UpdateChanged) {
-					tagsToPush = append(tagsToPush, tg)
-				}
+			if tg.NeedsPush(push.UpdateChanged) {
+				tagsToPush = append(tagsToPush, tg)
 			}
-			log.Debugf("%s sending 'push' tags: %+v", fn(repo.Ref()), tagsToPush)
+		}
+		log.Debugf("%s sending 'push' tags: %+v", fn(j.ref), tagsToPush)
 
-			tagc <- rtags{ref: repo.Ref(), tags: tagsToPush}
-			done <- nil
+		collected[i] = rtags{ref: j.ref, tags: tagsToPush}
+	}
 
-			return
-		}(repo, i, done)
+	tags := collectedTags(collected)
 
-		time.Sleep(api.config.WaitBetween)
-	}
+	log.Debugf("%s 'push' tags: %+v", fn(), tags)
 
-	if err := wait.Until(done); err != nil {
+	pushCn, err := collection.NewWithErrors(refs, tags, cn.Errors())
+	if err != nil {
 		return nil, err
 	}
 
-	tags := receiveTags(tagc)
-
-	log.Debugf("%s 'push' tags: %+v", fn(), tags)
+	if len(conflicts) != 0 {
+		return pushCn, &ConflictError{Conflicts: conflicts}
+	}
 
-	return collection.New(refs, tags)
+	return pushCn, nil
 }
 
-func makePushPathTemplate(push PushConfig) (func(pushPrefix, pushPath, name string) (string, error), error) {
-	tpl, err := template.New("push-path-template").
-		Funcs(sprig.FuncMap()).Parse(push.PathTemplate)
+// reportConflict reports protected tag having different digest in "push" registry (logs it and emits error event)
+func (api *API) reportConflict(repo *repository.Repository, tg *tag.Tag, push PushConfig) (Conflict, error) {
+	makeDstRef, err := makePushRefMaker(push)
 	if err != nil {
-		return nil, err
+		return Conflict{}, err
 	}
 
-	return func(pushPrefix, pushPath, name string) (string, error) {
-		var tout bytes.Buffer
-		err = tpl.Execute(&tout, struct{ Prefix, Path, Name string }{pushPrefix, pushPath, name})
-		if err != nil {
-			return "", err
-		}
-		return tout.String(), nil
-	}, nil
+	dstRef, err := makeDstRef(api.templateContext(repo, tg))
+	if err != nil {
+		return Conflict{}, err
+	}
+
+	return api.reportConflictAt(repo, tg, dstRef), nil
 }
 
-// PullTags compares images from remote registry and Docker daemon and pulls
-// images that match tag spec passed and are not present in Docker daemon.
-func (api *API) PullTags(cn *collection.Collection) error {
+// reportConflictAt reports protected tag having different digest at the destination reference passed
+func (api *API) reportConflictAt(repo *repository.Repository, tg *tag.Tag, dstRef string) Conflict {
+	conflict := Conflict{SourceRef: repo.Name() + ":" + tg.Name(), SourceDigest: tg.GetDigest(), DestinationRef: dstRef}
+
+	log.Warnf("[PULL/PUSH] CONFLICT %s => %s: %s", conflict.SourceRef, conflict.DestinationRef, ErrTagConflict.Error())
+
+	api.emit(Event{
+		Type:        EventError,
+		Ref:         conflict.SourceRef,
+		Tag:         tg.Name(),
+		Digest:      tg.GetDigest(),
+		State:       tg.GetState(),
+		Destination: dstRef,
+		Err:         ErrTagConflict,
+	})
+
+	return conflict
+}
+
+// joinPushTags joins tags of passed collection with ones fetched from [local] "push" registry,
+// so every joined tag gets its state relative to the "push" registry (e.g. "ABSENT" if it is not pushed yet,
+// or "CONFLICT" if it is protected, but has different digest there)
+// NB! Tags are copied before join, so tags of the passed collection are left intact.
+func (api *API) joinPushTags(cn *collection.Collection, push PushConfig) ([]string, []rtags, error) {
 	log.Debugf(
 		"%s collection: %+v (%d repos / %d tags)",
 		fn(), cn, cn.RepoCount(), cn.TagCount(),
 	)
+	log.Debugf("%s push config: %+v", fn(), push)
 
-	done := make(chan error, cn.TagCount())
+	refs := make([]string, len(cn.Refs()))
+	joined := make([]rtags, len(cn.Refs()))
 
-	for _, ref := range cn.Refs() {
-		repo := cn.Repo(ref)
-		tags := cn.Tags(ref)
+	jobs := make([]pool.Job, len(cn.Refs()))
+	for i, repo := range cn.Repos() {
+		i, repo := i, repo
 
-		log.Debugf("%s repository: %+v", fn(), repo)
-		for _, tg := range tags {
-			log.Debugf("%s tag: %+v", fn(), tg)
+		refs[i] = repo.Ref()
+
+		if cn.Err(repo.Ref()) != nil {
+			joined[i] = rtags{ref: repo.Ref(), tags: []*tag.Tag{}}
+			jobs[i] = func() error { return nil }
+
+			continue
 		}
 
-		go func(repo *repository.Repository, tags []*tag.Tag, done chan error) {
-			for _, tg := range tags {
-				if !tg.NeedsPull() {
-					done <- nil
-					continue
+		// repositories with no push registry (neither global, nor their own one) are never pushed anywhere
+		if push.ForRef(repo.Ref()).Registry == "" {
+			log.Warnf("[PULL/PUSH] SKIPPED %s: no push registry configured", repo.Ref())
+
+			joined[i] = rtags{ref: repo.Ref(), tags: []*tag.Tag{}}
+			jobs[i] = func() error { return nil }
+
+			continue
+		}
+
+		jobs[i] = api.emitOnError(Event{Ref: repo.Ref()}, func() error {
+			push := push.ForRef(repo.Ref())
+
+			pushPathTemplate, err := makePushPathTemplate(push)
+			if err != nil {
+				return err
+			}
+
+			pushTagTemplate, err := makePushTagTemplate(push)
+			if err != nil {
+				return err
+			}
+
+			pushDockerClient, err := api.pushDockerClient(push)
+			if err != nil {
+				return err
+			}
+
+			remoteTags := make(map[string]*tag.Tag)
+			for name, tg := range cn.TagMap(repo.Ref()) {
+				tgCopy := *tg
+				remoteTags[name] = &tgCopy
+			}
+			log.Debugf("%s remote tags: %+v", fn(repo.Ref()), remoteTags)
+
+			// push path could differ from tag to tag (e.g. if path template uses image labels),
+			// so we group tags by their push paths and join every group with tags pushed there
+			// (under tag names they are pushed with, as tag template could rename tags)
+			pushPaths := make([]string, 0)
+			tagNames := make(map[string][]string)
+			pushTagNames := make(map[string]string)
+
+			addTag := func(tg *tag.Tag) error {
+				ctx, err := api.templateContext(repo, tg).forPush(push)
+				if err != nil {
+					return err
+				}
+
+				pushPath, err := pushPathTemplate(ctx)
+				if err != nil {
+					return err
 				}
 
-				ref := repo.Name() + ":" + tg.Name()
+				pushTagName, err := pushTagTemplate(ctx)
+				if err != nil {
+					return err
+				}
+				pushTagNames[tg.Name()] = pushTagName
+
+				if _, defined := tagNames[pushPath]; !defined {
+					pushPaths = append(pushPaths, pushPath)
+				}
+				tagNames[pushPath] = append(tagNames[pushPath], tg.Name())
+
+				return nil
+			}
 
-				log.Infof("PULLING %s", ref)
-				if api.config.DryRun {
-					log.Infof("[DRY-RUN] PULLED %s", ref)
-					done <- nil
+			for _, tg := range remoteTags {
+				if err := addTag(tg); err != nil {
+					return err
+				}
+			}
+			for _, name := range repo.Tags() {
+				if _, defined := remoteTags[name]; defined {
 					continue
 				}
 
-				resp, err := api.dockerClient.Pull(ref)
-				if err != nil {
-					done <- err
-					return
+				tg, _ := tag.New(name, tag.Options{Digest: "n/a"})
+				if err := addTag(tg); err != nil {
+					return err
 				}
+			}
 
-				logDebugData(resp)
+			sort.Strings(pushPaths)
 
-				done <- nil
+			tags := make([]*tag.Tag, 0)
+			for _, pushPath := range pushPaths {
+				joinedTags, err := api.joinPushPath(repo, push, pushDockerClient, pushPath, remoteTags, tagNames[pushPath], pushTagNames)
+				if err != nil {
+					return err
+				}
+
+				tags = append(tags, joinedTags...)
 			}
-		}(repo, tags, done)
 
-		time.Sleep(api.config.WaitBetween)
+			sort.SliceStable(tags, func(a, b int) bool { return tags[a].SortKey() < tags[b].SortKey() })
+
+			joined[i] = rtags{ref: repo.Ref(), tags: tags}
+
+			return nil
+		})
 	}
 
-	return wait.WithTolerance(done)
+	err := api.pool.Run(jobs)
+
+	api.saveMetadata()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return refs, joined, nil
 }
 
-// PushTags compares images from remote and "push" (usually local) registries,
-// pulls images that are present in remote registry, but are not in "push" one
-// and then [re-]pushes them to the "push" registry.
-func (api *API) PushTags(cn *collection.Collection, push PushConfig) error {
-	log.Debugf(
-		"%s 'push' collection: %+v (%d repos / %d tags)",
-		fn(), cn, cn.RepoCount(), cn.TagCount(),
+// joinPushPath joins repository tags (with names passed) with ones already pushed to the push path passed,
+// every repository tag is joined with the pushed one having the name it is pushed with (see pushTagNames)
+func (api *API) joinPushPath(
+	repo *repository.Repository,
+	push PushConfig,
+	pushDockerClient *dockerclient.DockerClient,
+	pushPath string,
+	remoteTags map[string]*tag.Tag,
+	tagNames []string,
+	pushTagNames map[string]string,
+) ([]*tag.Tag, error) {
+	pushRef := fmt.Sprintf(
+		"%s%s~/.*/",
+		push.Registry,
+		pushPath,
 	)
-	log.Debugf("%s push config: %+v", fn(), push)
 
-	pushPathTemplate, terr := makePushPathTemplate(push)
-	if terr != nil {
-		return terr
+	log.Debugf("%s 'push' reference: %+v", fn(repo.Ref()), pushRef)
+
+	pushRepo, _ := repository.ParseRef(pushRef)
+
+	log.Infof("[PULL/PUSH] ANALYZE %s => %s", repo.Ref(), pushRef)
+	api.emit(Event{Type: EventAnalyzeStart, Ref: repo.Ref(), Destination: pushRef})
+
+	username, password := api.credentials(push.Registry, pushDockerClient.Config())
+
+	pushedTags, err := api.remote.FetchTags(pushRepo, username, password)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+
+		log.Warnf("%s repo not found: %+s", fn(repo.Ref()), pushRef)
+
+		pushedTags = make(map[string]*tag.Tag)
 	}
-	pushTagTemplate, terr := makePushTagTemplate(push)
-	if terr != nil {
-		return terr
+	log.Debugf("%s pushed tags: %+v", fn(repo.Ref()), pushedTags)
+
+	sort.Strings(tagNames)
+
+	// tags are keyed by names they are pushed with, tags not found in source registry are kept as they are
+	pathTags := make(map[string]*tag.Tag)
+	assumedTagNames := make([]string, 0)
+	for _, name := range tagNames {
+		tg, defined := remoteTags[name]
+		if !defined {
+			assumedTagNames = append(assumedTagNames, name)
+			continue
+		}
+
+		pushTagName := pushTagNames[name]
+		if other, defined := pathTags[pushTagName]; defined {
+			log.Warnf("[PULL/PUSH] SKIPPED %s:%s: pushed as %s, the same as %s:%s", repo.Name(), name, pushTagName, repo.Name(), other.Name())
+			continue
+		}
+
+		pathTags[pushTagName] = tg
 	}
 
-	done := make(chan error, cn.TagCount())
+	sortedKeys, sortedNames, joinedTags := tag.Join(
+		pathTags,
+		pushedTags,
+		assumedTagNames,
+	)
+	log.Debugf("%s joined tags: %+v", fn(repo.Ref()), joinedTags)
 
-	if cn.TagCount() == 0 {
-		log.Infof("%s No tags to push", fn())
+	// protected tags expression is matched against names tags are pushed with
+	if err := protectTags(pathTags, push); err != nil {
+		return nil, err
+	}
+
+	api.emit(Event{Type: EventAnalyzeFinish, Ref: repo.Ref(), Destination: pushRef})
+
+	return tag.Collect(sortedKeys, sortedNames, joinedTags), nil
+}
+
+// protectTags protects joined tags (keyed by names they are pushed with) matching protected tags expression of the push configuration
+func protectTags(joinedTags map[string]*tag.Tag, push PushConfig) error {
+	if push.ProtectedTags == "" {
 		return nil
 	}
 
+	protectedTagsRE, err := regexp.Compile(push.ProtectedTags)
+	if err != nil {
+		return fmt.Errorf("invalid protected tags expression: %s", err.Error())
+	}
+
+	for name, tg := range joinedTags {
+		if protectedTagsRE.MatchString(name) {
+			tg.Protect()
+		}
+	}
+
+	return nil
+}
+
+func makePushPathTemplate(push PushConfig) (func(ctx *TemplateContext) (string, error), error) {
+	tpl, err := template.New("push-path-template").
+		Funcs(sprig.FuncMap()).Parse(push.PathTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ctx *TemplateContext) (string, error) {
+		var tout bytes.Buffer
+		err := tpl.Execute(&tout, ctx)
+		if err != nil {
+			return "", err
+		}
+		return tout.String(), nil
+	}, nil
+}
+
+// PullTags compares images from remote registry and Docker daemon and pulls
+// images that match tag spec passed and are not present in Docker daemon.
+func (api *API) PullTags(cn *collection.Collection) error {
+	log.Debugf(
+		"%s collection: %+v (%d repos / %d tags)",
+		fn(), cn, cn.RepoCount(), cn.TagCount(),
+	)
+
+	jobs := make([]pool.Job, 0, cn.TagCount())
+
 	for _, ref := range cn.Refs() {
 		repo := cn.Repo(ref)
 		tags := cn.Tags(ref)
@@ -460,134 +1026,518 @@ func (api *API) PushTags(cn *collection.Collection, push PushConfig) error {
 		log.Debugf("%s repository: %+v", fn(), repo)
 		for _, tg := range tags {
 			log.Debugf("%s tag: %+v", fn(), tg)
+
+			if !tg.NeedsPull() {
+				continue
+			}
+
+			jobs = append(jobs, api.pullJob(repo, tg))
 		}
+	}
 
-		go func(repo *repository.Repository, tags []*tag.Tag, done chan error) {
+	return api.pool.RunWithTolerance(jobs)
+}
+
+// emitOnError makes job emit error event (based on the event passed), if it fails
+func (api *API) emitOnError(e Event, job pool.Job) pool.Job {
+	return func() error {
+		err := job()
+		if err != nil {
+			e.Err = err
+
+			api.emit(e.withType(EventError))
+		}
+
+		return err
+	}
+}
+
+// emitProgress makes function to emit progress events of the type passed (based on the event passed)
+// for every progress event of Docker daemon stream
+func (api *API) emitProgress(t EventType, e Event) func(stream.Event) {
+	return func(se stream.Event) {
+		if se.Kind != stream.Progress {
+			return
+		}
+
+		e.Status = se.Status
+		e.Layer = se.ID
+		e.Current = se.Current
+		e.Total = se.Total
+
+		api.emit(e.withType(t))
+	}
+}
+
+// pullJob makes a job to pull a single image (repository tag) into Docker daemon or OCI layout
+func (api *API) pullJob(repo *repository.Repository, tg *tag.Tag) pool.Job {
+	ref := repo.Name() + ":" + tg.Name()
+
+	e := Event{Ref: ref, Tag: tg.Name(), Digest: tg.GetDigest(), State: tg.GetState()}
+
+	return api.emitOnError(e, func() error {
+		log.Infof("PULLING %s", ref)
+		api.emit(e.withType(EventPullStart))
+
+		if api.config.DryRun {
+			log.Infof("[DRY-RUN] PULLED %s", ref)
+			api.emit(e.withType(EventPullFinish))
+			return nil
+		}
+
+		if api.layout != nil {
+			if err := api.pullToLayout(api.layout, repo, tg); err != nil {
+				return err
+			}
+
+			api.emit(e.withType(EventPullFinish))
+
+			return nil
+		}
+
+		resp, err := api.dockerClient.Pull(ref)
+		if err != nil {
+			return err
+		}
+
+		summary, err := stream.Watch(resp, ref, api.emitProgress(EventPullProgress, e))
+		if err != nil {
+			return fmt.Errorf("PULL %s failed: '%w'", ref, err)
+		}
+
+		log.Infof("PULLED %s (%s)", ref, summary)
+		api.emit(e.withType(EventPullFinish))
+
+		return nil
+	})
+}
+
+// PushTags compares images from remote and "push" (usually local) registries,
+// pulls images that are present in remote registry, but are not in "push" one
+// and then [re-]pushes them to the "push" registry.
+func (api *API) PushTags(cn *collection.Collection, push PushConfig) error {
+	return api.PushTagsToTargets([]*collection.Collection{cn}, []PushConfig{push})
+}
+
+// CollectPushTagsForTargets does the same as CollectPushTags, but for multiple push targets at once,
+// giving us a separate "push" collection for every push configuration passed (in the same order)
+// NB! Conflicts of all push targets are returned as a single *ConflictError (along with all "push" collections).
+func (api *API) CollectPushTagsForTargets(cn *collection.Collection, pushes []PushConfig) ([]*collection.Collection, error) {
+	cns := make([]*collection.Collection, len(pushes))
+	conflicts := make([]Conflict, 0)
+
+	for i, push := range pushes {
+		pushCollection, err := api.CollectPushTags(cn, push)
+		if err != nil {
+			var conflictErr *ConflictError
+			if !errors.As(err, &conflictErr) {
+				return nil, fmt.Errorf("push target '%s': %w", push.Registry, err)
+			}
+
+			conflicts = append(conflicts, conflictErr.Conflicts...)
+		}
+
+		cns[i] = pushCollection
+	}
+
+	if len(conflicts) != 0 {
+		return cns, &ConflictError{Conflicts: conflicts}
+	}
+
+	return cns, nil
+}
+
+// pushDestination is a single destination (push target) we push source image to
+type pushDestination struct {
+	ref          string
+	tag          *tag.Tag
+	push         PushConfig
+	dockerClient *dockerclient.DockerClient
+}
+
+// pushSource is a source image (repository tag) with all its push destinations
+type pushSource struct {
+	repo      *repository.Repository
+	tag       *tag.Tag
+	dsts      []pushDestination
+	wasPulled bool
+}
+
+// PushTagsToTargets does the same as PushTags, but for multiple push targets at once
+// ("push" collections are expected to be collected by CollectPushTagsForTargets for the same push configurations),
+// every source image is pulled only once and then pushed to all the targets it is missing in.
+func (api *API) PushTagsToTargets(cns []*collection.Collection, pushes []PushConfig) error {
+	if len(cns) != len(pushes) {
+		return fmt.Errorf("got %d 'push' collections for %d push configurations", len(cns), len(pushes))
+	}
+
+	sources := make(map[string]*pushSource)
+	srcRefs := make([]string, 0)
+
+	for i, cn := range cns {
+		log.Debugf(
+			"%s 'push' collection: %+v (%d repos / %d tags)",
+			fn(), cn, cn.RepoCount(), cn.TagCount(),
+		)
+		log.Debugf("%s push config: %+v", fn(), pushes[i])
+
+		for _, ref := range cn.Refs() {
+			repo := cn.Repo(ref)
+			tags := cn.Tags(ref)
+
+			log.Debugf("%s repository: %+v", fn(), repo)
 			for _, tg := range tags {
-				srcRef := repo.Name() + ":" + tg.Name()
-				pushPrefix := getPushPrefix(push.Prefix, repo.PushPrefix())
-				if err := validatePushPrefix(pushPrefix); err != nil {
-					done <- err
-					return
-				}
-				pushPath := repo.PushPath(push.PathSeparator)
-				fullPath, perr := pushPathTemplate(pushPrefix, pushPath, repo.Name())
-				if perr != nil {
-					done <- perr
-					return
-				}
-				tagName, err := pushTagTemplate(pushPrefix, pushPath, repo.Name(), tg.Name())
-				if err != nil {
-					done <- err
-					return
-				}
-				dstRef := push.Registry + fullPath + ":" + tagName
+				log.Debugf("%s tag: %+v", fn(), tg)
+			}
 
-				log.Infof("[PULL/PUSH] PUSHING %s => %s", srcRef, dstRef)
-				if api.config.DryRun {
-					log.Infof("[DRY-RUN] PUSHED %s => %s", srcRef, dstRef)
-					done <- nil
-					continue
-				}
+			if len(tags) == 0 {
+				continue
+			}
 
-				pullResp, err := api.dockerClient.Pull(srcRef)
-				if err != nil {
-					done <- err
-					return
-				}
-				logDebugData(pullResp)
+			push := pushes[i].ForRef(ref)
+			if push.Registry == "" {
+				log.Warnf("[PULL/PUSH] SKIPPED %s: no push registry configured", ref)
+
+				continue
+			}
 
-				api.dockerClient.Tag(srcRef, dstRef)
+			makeDstRef, err := makePushRefMaker(push)
+			if err != nil {
+				return err
+			}
+			pushDockerClient, err := api.pushDockerClient(push)
+			if err != nil {
+				return err
+			}
 
-				pushResp, err := api.dockerClient.Push(dstRef)
+			for _, tg := range tags {
+				dstRef, err := makeDstRef(api.templateContext(repo, tg))
 				if err != nil {
-					done <- err
-					return
+					return err
 				}
-				err = logDebugDataMaybeError(pushResp)
-				if err != nil {
-					errMsg := fmt.Sprintf("PUSH %s => %s failed: '%s'", srcRef, dstRef, err.Error())
-					done <- errors.New(errMsg)
-					return
+
+				srcRef := repo.Name() + ":" + tg.Name()
+
+				src, defined := sources[srcRef]
+				if !defined {
+					src = &pushSource{repo: repo, tag: tg}
+
+					sources[srcRef] = src
+					srcRefs = append(srcRefs, srcRef)
 				}
 
-				done <- err
+				src.dsts = append(src.dsts, pushDestination{ref: dstRef, tag: tg, push: push, dockerClient: pushDockerClient})
 			}
-		}(repo, tags, done)
+		}
+	}
 
-		time.Sleep(api.config.WaitBetween)
+	srcs := make([]*pushSource, len(srcRefs))
+	for i, srcRef := range srcRefs {
+		srcs[i] = sources[srcRef]
 	}
 
-	return wait.WithTolerance(done)
+	return api.pushSources(srcs)
 }
 
-func makePushTagTemplate(push PushConfig) (func(pushPrefix, pushPath, name, tag string) (string, error), error) {
-	tpl, err := template.New("push-tag-template").
-		Funcs(sprig.FuncMap()).Parse(push.TagTemplate)
-	if err != nil {
-		return nil, err
+// pushSources [pulls and] pushes source images passed to all their destinations
+func (api *API) pushSources(srcs []*pushSource) error {
+	if len(srcs) == 0 {
+		log.Infof("%s No tags to push", fn())
+		return nil
 	}
 
-	return func(pushPrefix, pushPath, name, tag string) (string, error) {
-		var tout bytes.Buffer
-		err = tpl.Execute(&tout, struct{ Prefix, Path, Name, Tag string }{pushPrefix, pushPath, name, tag})
-		if err != nil {
-			return "", err
+	localTags := make(map[string]map[string]*tag.Tag)
+
+	jobs := make([]pool.Job, len(srcs))
+	for i, src := range srcs {
+		for _, dst := range src.dsts {
+			if _, defined := localTags[src.repo.Name()]; dst.push.Cleanup && !defined {
+				localTags[src.repo.Name()] = api.fetchLocalTags(src.repo)
+			}
 		}
-		return tout.String(), nil
-	}, nil
+
+		if tags, defined := localTags[src.repo.Name()]; defined {
+			src.wasPulled = tags[src.tag.Name()] == nil
+		}
+
+		jobs[i] = api.pushJob(src)
+	}
+
+	return api.pool.RunWithTolerance(jobs)
 }
 
-func logDebugData(data io.Reader) {
-	scanner := bufio.NewScanner(data)
-	for scanner.Scan() {
-		log.Debug(scanner.Text())
+// pushEvent makes a base event for pushing source image to the destination passed
+func pushEvent(srcRef string, dst pushDestination) Event {
+	return Event{
+		Ref:         srcRef,
+		Tag:         dst.tag.Name(),
+		Digest:      dst.tag.GetDigest(),
+		State:       dst.tag.GetState(),
+		Destination: dst.ref,
 	}
 }
 
-func logDebugDataMaybeError(data io.Reader) error {
-	scanner := bufio.NewScanner(data)
-	for scanner.Scan() {
-		msg := scanner.Text()
-		// error message need return error
-		if strings.Index(msg, `"error":`) > 0 {
-			dataErr := struct {
-				Error string `name:"error"`
-			}{}
-			err := json.Unmarshal([]byte(msg), &dataErr)
+// pushJob makes a job to [pull and] push a single image (repository tag) to all its destinations,
+// image is pulled only once, failure to push to one destination does not prevent pushing to others.
+// Every push is verified: digest of the image pushed must match the source one (or one of its platforms).
+// NB! If "wasPulled" is set, source image is not present locally and will be removed after push (on cleanup)
+func (api *API) pushJob(src *pushSource) pool.Job {
+	srcRef := src.repo.Name() + ":" + src.tag.Name()
+
+	return func() error {
+		for _, dst := range src.dsts {
+			log.Infof("[PULL/PUSH] PUSHING %s => %s", srcRef, dst.ref)
+			api.emit(pushEvent(srcRef, dst).withType(EventPushStart))
+		}
+
+		if api.config.DryRun {
+			for _, dst := range src.dsts {
+				log.Infof("[DRY-RUN] PUSHED %s => %s", srcRef, dst.ref)
+				api.emit(pushEvent(srcRef, dst).withType(EventPushFinish))
+			}
+
+			return nil
+		}
+
+		if err := api.pullToPush(src, srcRef); err != nil {
+			for _, dst := range src.dsts {
+				e := pushEvent(srcRef, dst)
+				e.Err = err
+
+				api.emit(e.withType(EventError))
+			}
+
+			return err
+		}
+
+		errs := make(pool.Errors, 0)
+		dstRefs := make([]string, 0, len(src.dsts))
+		isCleanup := false
+
+		for _, dst := range src.dsts {
+			e := pushEvent(srcRef, dst)
+
+			if err := api.pushPulled(srcRef, dst, e); err != nil {
+				e.Err = err
+
+				api.emit(e.withType(EventError))
+
+				errs = append(errs, err)
+
+				continue
+			}
+
+			dstDigest, platform, err := api.verifyPushed(src, dst)
 			if err != nil {
-				return err
+				e.DestinationDigest = dstDigest
+				e.Err = fmt.Errorf("VERIFY %s => %s failed: %w", srcRef, dst.ref, err)
+
+				api.emit(e.withType(EventError))
+
+				errs = append(errs, e.Err)
+
+				continue
 			}
-			if len(dataErr.Error) > 0 {
-				return errors.New(dataErr.Error)
+
+			if platform != "" {
+				log.Infof("[PULL/PUSH] VERIFIED %s => %s (%s: %s)", srcRef, dst.ref, platform, dstDigest)
+			} else {
+				log.Infof("[PULL/PUSH] VERIFIED %s => %s (%s)", srcRef, dst.ref, dstDigest)
 			}
-			break
+
+			e.DestinationDigest = dstDigest
+			e.Platform = platform
+
+			api.emit(e.withType(EventPushVerified))
+
+			if dst.push.Cleanup {
+				isCleanup = true
+
+				if api.layout == nil {
+					dstRefs = append(dstRefs, dst.ref)
+				}
+			}
+
+			api.emit(e.withType(EventPushFinish))
+		}
+
+		if isCleanup {
+			api.cleanupPushed(srcRef, dstRefs, src.wasPulled && len(errs) == 0)
+		}
+
+		if len(errs) == 1 {
+			return errs[0]
+		}
+
+		if len(errs) != 0 {
+			return errs
 		}
-		log.Debug(msg)
+
+		return nil
+	}
+}
+
+// pullToPush pulls source image (into OCI layout or Docker daemon) to push it then
+// NB! Progress of the source image pull is reported by push progress events with no destination set
+func (api *API) pullToPush(src *pushSource, srcRef string) error {
+	if api.layout != nil {
+		return api.pullToLayout(api.layout, src.repo, src.tag)
+	}
+
+	e := Event{Ref: srcRef, Tag: src.tag.Name(), Digest: src.tag.GetDigest(), State: src.tag.GetState()}
+
+	pullResp, err := api.dockerClient.Pull(srcRef)
+	if err != nil {
+		return err
+	}
+	if _, err := stream.Watch(pullResp, srcRef, api.emitProgress(EventPushProgress, e)); err != nil {
+		return fmt.Errorf("PULL %s failed: '%w'", srcRef, err)
+	}
+
+	return nil
+}
+
+// pushPulled pushes already pulled source image to the destination passed
+func (api *API) pushPulled(srcRef string, dst pushDestination, e Event) error {
+	if api.layout != nil {
+		if err := api.pushFromLayout(api.layout, srcRef, dst.ref, dst.push); err != nil {
+			return fmt.Errorf("PUSH %s => %s failed: '%w'", srcRef, dst.ref, err)
+		}
+
+		log.Infof("[PULL/PUSH] PUSHED %s => %s", srcRef, dst.ref)
+
+		return nil
 	}
+
+	api.dockerClient.Tag(srcRef, dst.ref)
+
+	pushResp, err := dst.dockerClient.Push(dst.ref)
+	if err != nil {
+		return err
+	}
+	summary, err := stream.Watch(pushResp, dst.ref, api.emitProgress(EventPushProgress, e))
+	if err != nil {
+		return fmt.Errorf("PUSH %s => %s failed: '%w'", srcRef, dst.ref, err)
+	}
+
+	log.Infof("[PULL/PUSH] PUSHED %s => %s (%s)", srcRef, dst.ref, summary)
+
 	return nil
 }
 
+// makePushRefMaker makes a function to get destination reference (REGISTRY/PATH:TAG) to push repository tag to
+// (repository tag is passed as a template context, see TemplateContext)
+func makePushRefMaker(push PushConfig) (func(ctx *TemplateContext) (string, error), error) {
+	pushPathTemplate, err := makePushPathTemplate(push)
+	if err != nil {
+		return nil, err
+	}
+	pushTagTemplate, err := makePushTagTemplate(push)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ctx *TemplateContext) (string, error) {
+		ctx, err := ctx.forPush(push)
+		if err != nil {
+			return "", err
+		}
+		fullPath, err := pushPathTemplate(ctx)
+		if err != nil {
+			return "", err
+		}
+		pushTag, err := pushTagTemplate(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		return push.Registry + fullPath + ":" + pushTag, nil
+	}, nil
+}
+
+func makePushTagTemplate(push PushConfig) (func(ctx *TemplateContext) (string, error), error) {
+	tagTemplate := push.TagTemplate
+	if tagTemplate == "" {
+		tagTemplate = "{{ .Tag }}"
+	}
+
+	tpl, err := template.New("push-tag-template").
+		Funcs(sprig.FuncMap()).Parse(tagTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ctx *TemplateContext) (string, error) {
+		var tout bytes.Buffer
+		err := tpl.Execute(&tout, ctx)
+		if err != nil {
+			return "", err
+		}
+		return tout.String(), nil
+	}, nil
+}
+
 // New creates new instance of application API
 func New(config Config) (*API, error) {
 	if config.VerboseLogging {
 		log.SetLevel(log.DebugLevel)
 	}
-	log.Debugf("%s API config: %+v", fn(), config)
+	log.Debugf("%s API config: %+v", fn(), config.redacted())
 
 	if config.ConcurrentRequests == 0 {
 		config.ConcurrentRequests = 8
 	}
-	remote.ConcurrentRequests = config.ConcurrentRequests
-	remote.WaitBetween = config.WaitBetween
-	remote.TraceRequests = config.TraceRequests
-	remote.RetryRequests = config.RetryRequests
-	remote.RetryDelay = config.RetryDelay
 
-	cache.WaitBetween = config.WaitBetween
+	registryConfigs := make(map[string]client.Config)
+	for registry, rc := range config.Registries {
+		clientConfig, err := rc.clientConfig(registry, config)
+		if err != nil {
+			return nil, err
+		}
+
+		registryConfigs[registry] = clientConfig
+	}
+
+	basicStore := &basicstore.Store{}
+	if err := basicStore.LoadAll(config.BasicAuth); err != nil {
+		return nil, err
+	}
+
+	var metadata *cache.Metadata
+	if config.CacheDir != "" {
+		var err error
+
+		metadata, err = cache.LoadMetadata(config.CacheDir, config.CacheTTL)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load metadata cache from '%s': %s", config.CacheDir, err.Error())
+		}
+	}
 
-	if config.InsecureRegistryEx != "" {
-		repository.InsecureRegistryEx = config.InsecureRegistryEx
+	subscribers := newSubscribers()
+
+	r, err := remote.New(remote.Config{
+		ConcurrentRequests: config.ConcurrentRequests,
+		WaitBetween:        config.WaitBetween,
+		RetryRequests:      config.RetryRequests,
+		RetryDelay:         config.RetryDelay,
+		TraceRequests:      config.TraceRequests,
+		InsecureRegistryEx: config.InsecureRegistryEx,
+		RegistryConfigs:    registryConfigs,
+		Limiters:           ratelimit.NewSet(config.requestRate(), config.ConcurrentRequests),
+		Tokens:             cache.NewToken(config.WaitBetween),
+		BasicStore:         basicStore,
+		OnRetry: func(url string, attempt int, delay time.Duration, err error) {
+			subscribers.emit(Event{Type: EventRetry, URL: url, Attempt: attempt, Delay: delay, Err: err})
+		},
+		Metadata: metadata,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	before, after, err := makeCredentialProviders(config, basicStore)
+	if err != nil {
+		return nil, err
 	}
 
 	if config.DockerJSONConfigFile == "" {
@@ -597,13 +1547,30 @@ func New(config Config) (*API, error) {
 	if err != nil {
 		return nil, err
 	}
+	dockerConfig.WithProviders(before, after)
 	dockerClient, err := dockerclient.New(dockerConfig)
 	if err != nil {
 		return nil, err
 	}
 
+	var l *layout.Layout
+	if config.OCILayoutDir != "" {
+		l, err = layout.Open(config.OCILayoutDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &API{
-		config:       config,
-		dockerClient: dockerClient,
+		config:        config,
+		dockerClient:  dockerClient,
+		layout:        l,
+		pool:          pool.New(config.ConcurrentRequests),
+		remote:        r,
+		subscribers:   subscribers,
+		metadata:      metadata,
+		dockerConfigs: make(map[string]*dockerconfig.Config),
+		before:        before,
+		after:         after,
 	}, nil
 }
@@ -1,7 +1,13 @@
 package v1
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -9,8 +15,12 @@ import (
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/ivanilves/lstags/api/v1/plan"
+	"github.com/ivanilves/lstags/api/v1/registry/client"
 	registrycontainer "github.com/ivanilves/lstags/api/v1/registry/container"
+	"github.com/ivanilves/lstags/oci/layout"
 	"github.com/ivanilves/lstags/repository"
+	"github.com/ivanilves/lstags/tag"
 )
 
 func runEnd2EndJob(pullRefs, seedRefs []string) ([]string, error) {
@@ -157,9 +167,31 @@ func TestNew_InsecureRegistryEx(t *testing.T) {
 
 	assert := assert.New(t)
 
-	New(Config{InsecureRegistryEx: ex})
+	api, err := New(Config{InsecureRegistryEx: ex})
+	assert.Nil(err)
 
-	assert.Equal(ex, repository.InsecureRegistryEx)
+	defaultAPI, err := New(Config{})
+	assert.Nil(err)
+
+	re, _ := api.config.insecureRegistryRE()
+	assert.True(re.MatchString("registry.company.io"))
+
+	defaultRE, _ := defaultAPI.config.insecureRegistryRE()
+	assert.False(defaultRE.MatchString("registry.company.io"))
+	assert.True(defaultRE.MatchString("localhost:5000"))
+
+	repo, _ := repository.ParseRef("registry.company.io/sample/repo")
+	assert.True(repo.IsSecure(), "other API instances (and repository defaults) should not be affected")
+}
+
+func TestNew_InvalidInsecureRegistryEx(t *testing.T) {
+	assert := assert.New(t)
+
+	api, err := New(Config{InsecureRegistryEx: "(invalid"})
+
+	assert.Nil(api)
+
+	assert.NotNil(err)
 }
 
 func TestNew_InvalidDockerJSONConfigFile(t *testing.T) {
@@ -195,40 +227,21 @@ func TestGetPushPrefix(t *testing.T) {
 	}
 }
 
-func TestGetBatchedSlices(t *testing.T) {
-	var unbatched = []string{
-		"unbatched/repo01",
-		"unbatched/repo02",
-		"unbatched/repo03",
-		"unbatched/repo04",
-		"unbatched/repo05",
-		"unbatched/repo06",
-		"unbatched/repo07",
-		"unbatched/repo08",
-		"unbatched/repo09",
-		"unbatched/repo10",
-	}
-
-	var testCases = map[int][][]string{
-		1:   [][]string{{"unbatched/repo01"}, {"unbatched/repo02"}, {"unbatched/repo03"}, {"unbatched/repo04"}, {"unbatched/repo05"}, {"unbatched/repo06"}, {"unbatched/repo07"}, {"unbatched/repo08"}, {"unbatched/repo09"}, {"unbatched/repo10"}},
-		3:   [][]string{{"unbatched/repo01", "unbatched/repo02", "unbatched/repo03"}, {"unbatched/repo04", "unbatched/repo05", "unbatched/repo06"}, {"unbatched/repo07", "unbatched/repo08", "unbatched/repo09"}, {"unbatched/repo10"}},
-		7:   [][]string{{"unbatched/repo01", "unbatched/repo02", "unbatched/repo03", "unbatched/repo04", "unbatched/repo05", "unbatched/repo06", "unbatched/repo07"}, {"unbatched/repo08", "unbatched/repo09", "unbatched/repo10"}},
-		10:  [][]string{{"unbatched/repo01", "unbatched/repo02", "unbatched/repo03", "unbatched/repo04", "unbatched/repo05", "unbatched/repo06", "unbatched/repo07", "unbatched/repo08", "unbatched/repo09", "unbatched/repo10"}},
-		11:  [][]string{{"unbatched/repo01", "unbatched/repo02", "unbatched/repo03", "unbatched/repo04", "unbatched/repo05", "unbatched/repo06", "unbatched/repo07", "unbatched/repo08", "unbatched/repo09", "unbatched/repo10"}},
-		100: [][]string{{"unbatched/repo01", "unbatched/repo02", "unbatched/repo03", "unbatched/repo04", "unbatched/repo05", "unbatched/repo06", "unbatched/repo07", "unbatched/repo08", "unbatched/repo09", "unbatched/repo10"}},
+func TestConfigRequestRate(t *testing.T) {
+	var testCases = []struct {
+		config   Config
+		expected float64
+	}{
+		{Config{}, 0},
+		{Config{RequestRate: 5}, 5},
+		{Config{WaitBetween: 500 * time.Millisecond}, 2},
+		{Config{RequestRate: 5, WaitBetween: 500 * time.Millisecond}, 5},
 	}
 
 	var assert = assert.New(t)
 
-	for batchSize, expectedBatchedSlices := range testCases {
-		actualBatchedSlices := getBatchedSlices(batchSize, unbatched...)
-
-		assert.Equalf(
-			expectedBatchedSlices,
-			actualBatchedSlices,
-			"unexpected result for batch size: %d",
-			batchSize,
-		)
+	for _, testCase := range testCases {
+		assert.Equal(testCase.expected, testCase.config.requestRate(), fmt.Sprintf("%+v", testCase.config))
 	}
 }
 
@@ -237,7 +250,7 @@ func TestMakePushPathTemplate(t *testing.T) {
 		PathTemplate: "{{ .Prefix }}{{ .Path }}"})
 	assert.NoError(t, err)
 
-	actualDefault, err := defaultTemplate("starter/", "foo/bar/cool", "cool")
+	actualDefault, err := defaultTemplate(&TemplateContext{Prefix: "starter/", Path: "foo/bar/cool", Name: "cool"})
 	assert.NoError(t, err)
 	assert.Equal(t, "starter/foo/bar/cool", actualDefault)
 
@@ -246,7 +259,7 @@ func TestMakePushPathTemplate(t *testing.T) {
 		PathTemplate: "{{ .Prefix }}{{ .Name }}"})
 	assert.NoError(t, err)
 
-	actualName, err := nameTemplate("volavola/", "foo/bar/cool", "coolname")
+	actualName, err := nameTemplate(&TemplateContext{Prefix: "volavola/", Path: "foo/bar/cool", Name: "coolname"})
 	assert.NoError(t, err)
 	assert.Equal(t, "volavola/coolname", actualName)
 
@@ -255,7 +268,7 @@ func TestMakePushPathTemplate(t *testing.T) {
 		PathTemplate: "{{ .Prefix }}{{ .Path | base }}"})
 	assert.NoError(t, err)
 
-	actualBase, err := basenameTemplate("starter/", "foo/bar/cool", "cool")
+	actualBase, err := basenameTemplate(&TemplateContext{Prefix: "starter/", Path: "foo/bar/cool", Name: "cool"})
 	assert.NoError(t, err)
 	assert.Equal(t, "starter/cool", actualBase)
 }
@@ -265,7 +278,7 @@ func TestMakePushTagTemplate(t *testing.T) {
 		TagTemplate: "{{ .Tag }}"})
 	assert.NoError(t, err)
 
-	actualDefault, err := defaultTemplate("starter/", "kill/me", "bill", "1.0.0")
+	actualDefault, err := defaultTemplate(&TemplateContext{Prefix: "starter/", Path: "kill/me", Name: "bill", Tag: "1.0.0"})
 	assert.NoError(t, err)
 	assert.Equal(t, "1.0.0", actualDefault)
 
@@ -274,7 +287,7 @@ func TestMakePushTagTemplate(t *testing.T) {
 		TagTemplate: "{{ .Tag }}-prd"})
 	assert.NoError(t, err)
 
-	suffixTag, err := suffixTemplate("volavola/", "kill/me", "bill", "2.1.3")
+	suffixTag, err := suffixTemplate(&TemplateContext{Prefix: "volavola/", Path: "kill/me", Name: "bill", Tag: "2.1.3"})
 	assert.NoError(t, err)
 	assert.Equal(t, "2.1.3-prd", suffixTag)
 
@@ -284,7 +297,7 @@ func TestMakePushTagTemplate(t *testing.T) {
 	assert.NoError(t, err)
 
 	curDate := time.Now().Format("20060102")
-	actualDate, err := dateTemplate("starter/", "kill/me", "bill", "16.3.1")
+	actualDate, err := dateTemplate(&TemplateContext{Prefix: "starter/", Path: "kill/me", Name: "bill", Tag: "16.3.1"})
 	assert.NoError(t, err)
 	assert.Equal(t, "SNAPSHOT-16.3.1-"+curDate, actualDate)
 }
@@ -296,3 +309,682 @@ func TestValidatePushPrefix(t *testing.T) {
 	assert.Error(t, validatePushPrefix("/baz"))
 	assert.Error(t, validatePushPrefix("http://localhost:5000"))
 }
+
+func TestPushConfigForRef(t *testing.T) {
+	assert := assert.New(t)
+
+	override := PushConfig{Registry: "registry.hub.company.io", Prefix: "/hub"}
+
+	push := PushConfig{
+		Registry:      "registry.company.io",
+		Prefix:        "/quay",
+		RepoOverrides: map[string]PushConfig{"nginx:stable": override},
+	}
+
+	assert.Equal(override, push.ForRef("nginx:stable"))
+	assert.Equal(push, push.ForRef("quay.io/coreos/flannel"))
+
+	assert.Equal(PushConfig{}, PushConfig{}.ForRef("nginx:stable"), "should work with no overrides defined")
+}
+
+func TestPushConfigValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(PushConfig{
+		Prefix:       "/mirror",
+		PathTemplate: "{{ .Prefix }}{{ .Path }}",
+		TagTemplate:  "{{ .Tag }}",
+	}.Validate())
+
+	assert.NoError(PushConfig{
+		PathTemplate: "{{ .Prefix }}{{ .Path | base }}",
+		TagTemplate:  `{{ .Tag }}-{{ now | date "20060102" }}`,
+	}.Validate())
+
+	assert.Error(PushConfig{Prefix: "http://localhost"}.Validate(), "invalid prefix")
+	assert.Error(PushConfig{PathTemplate: "{{ .Prefix }"}.Validate(), "unparseable path template")
+	assert.Error(PushConfig{PathTemplate: "{{ .Prefixxx }}"}.Validate(), "unknown field in path template")
+	assert.Error(PushConfig{TagTemplate: "{{ .Tag | nosuchfunc }}"}.Validate(), "unknown function in tag template")
+	assert.Error(PushConfig{PathTemplate: "{{ .Prefix }}{{ .Path }}", TagTemplate: "{{ .Tag }}", ProtectedTags: "^v[0-9"}.Validate(), "invalid protected tags expression")
+}
+
+func TestPushConfigValidateFor(t *testing.T) {
+	assert := assert.New(t)
+
+	repo, _ := repository.ParseRef(`quay.io/coreos/flannel~/^v(?P<major>[0-9]+)\.([0-9]+)\./`)
+
+	push := func(tagTemplate string) PushConfig {
+		return PushConfig{PathTemplate: "{{ .Prefix }}{{ .Path }}", TagTemplate: tagTemplate}
+	}
+
+	assert.NoError(push(`{{ index .Captures 2 }}-{{ .NamedCaptures.major }}`).ValidateFor(repo))
+	assert.Error(push(`{{ index .Captures 3 }}`).ValidateFor(repo), "should fail on capture filter does not have")
+
+	assert.Error(push(`{{ index .Captures 1 }}`).Validate(), "should fail on capture with no filter")
+	assert.NoError(push(`{{ .Tag }}`).ValidateFor(repo))
+}
+
+func TestMakePushRefMaker(t *testing.T) {
+	assert := assert.New(t)
+
+	makePushRef, err := makePushRefMaker(PushConfig{
+		Registry:      "registry.company.io",
+		PathSeparator: "-",
+		PathTemplate:  "{{ .Prefix }}{{ .Path }}",
+		TagTemplate:   "{{ .Tag }}-mirror",
+	})
+	assert.Nil(err)
+
+	examples := map[string]string{
+		"alpine":                 "registry.company.io/registry/hub/docker/com/library-alpine:3.7-mirror",
+		"quay.io/coreos/flannel": "registry.company.io/quay/io/coreos-flannel:3.7-mirror",
+	}
+
+	for ref, expected := range examples {
+		repo, _ := repository.ParseRef(ref)
+		tg, _ := tag.New("3.7", tag.Options{Digest: "sha256:cafebabe"})
+
+		pushRef, err := makePushRef(newTemplateContext(repo, tg, nil))
+		assert.Nil(err, ref)
+		assert.Equal(expected, pushRef, ref)
+	}
+
+	_, err = makePushRefMaker(PushConfig{PathTemplate: "{{ .Prefix"})
+	assert.NotNil(err)
+}
+
+func TestSelectPruneTags(t *testing.T) {
+	assert := assert.New(t)
+
+	newTag := func(name, digest string, created int64) *tag.Tag {
+		tg, _ := tag.New(name, tag.Options{Digest: digest, ImageID: digest, Created: created})
+
+		return tg
+	}
+
+	remoteTags := map[string]*tag.Tag{
+		"present-old": newTag("present-old", "sha256:p1", 100),
+		"present-new": newTag("present-new", "sha256:p2", 500),
+		"changed":     newTag("changed", "sha256:c2", 300),
+		"absent":      newTag("absent", "sha256:a1", 600),
+	}
+	localTags := map[string]*tag.Tag{
+		"present-old": newTag("present-old", "sha256:p1", 100),
+		"present-new": newTag("present-new", "sha256:p2", 500),
+		"changed":     newTag("changed", "sha256:c1", 200),
+		"local-only":  newTag("local-only", "sha256:l1", 400),
+	}
+
+	keys, names, joined := tag.Join(remoteTags, localTags, nil)
+	tags := tag.Collect(keys, names, joined)
+
+	examples := []struct {
+		prune    PruneConfig
+		expected []string
+	}{
+		{PruneConfig{}, []string{}},
+		{PruneConfig{RemoveLocalOnly: true}, []string{"local-only"}},
+		{PruneConfig{RemoveChanged: true}, []string{"changed"}},
+		{PruneConfig{RemoveLocalOnly: true, RemoveChanged: true}, []string{"local-only", "changed"}},
+		{PruneConfig{KeepNewest: 2}, []string{"changed", "present-old"}},
+		{PruneConfig{KeepNewest: 1, KeepUpstream: true}, []string{"local-only", "changed"}},
+		{PruneConfig{KeepNewest: 2, KeepUpstream: true}, []string{"changed"}},
+		{PruneConfig{KeepNewest: 1, KeepUpstream: true, RemoveChanged: true}, []string{"local-only", "changed"}},
+		{PruneConfig{KeepNewest: 3, RemoveLocalOnly: true, RemoveChanged: true}, []string{"present-old"}},
+		{PruneConfig{KeepNewest: 10, RemoveLocalOnly: true, RemoveChanged: true}, []string{}},
+	}
+
+	for _, e := range examples {
+		pruned := make([]string, 0)
+		for _, tg := range selectPruneTags(tags, e.prune) {
+			pruned = append(pruned, tg.Name())
+		}
+
+		assert.Equal(e.expected, pruned, fmt.Sprintf("%+v", e.prune))
+	}
+}
+
+func TestConfigRedacted(t *testing.T) {
+	assert := assert.New(t)
+
+	config := Config{
+		Registries: map[string]RegistryConfig{
+			"registry.company.io": {Username: "user", Password: "pass"},
+			"quay.io":             {Scheme: "https"},
+		},
+		BasicAuth: []string{"registry.company.io:5000 user:pass"},
+	}
+
+	redacted := config.redacted()
+
+	assert.Equal("user", redacted.Registries["registry.company.io"].Username)
+	assert.Equal("REDACTED", redacted.Registries["registry.company.io"].Password)
+	assert.Equal("", redacted.Registries["quay.io"].Password)
+	assert.Equal("pass", config.Registries["registry.company.io"].Password, "should not modify original config")
+	assert.Equal([]string{"registry.company.io:5000 user:REDACTED"}, redacted.BasicAuth)
+	assert.Equal("registry.company.io:5000 user:pass", config.BasicAuth[0], "should not modify original config")
+}
+
+func TestCollectTags_Partial(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(newFakeRegistry())
+	defer server.Close()
+
+	registry := strings.TrimPrefix(server.URL, "http://")
+
+	seedFakeRegistry(t, registry)
+
+	api, err := New(Config{})
+	assert.Nil(err)
+
+	presentRef := registry + "/lstags/flannel"
+	missingRef := registry + "/lstags/missing"
+
+	cn, err := api.CollectTags(presentRef, missingRef)
+
+	if assert.NotNil(cn, "should return partial collection") {
+		assert.Equal([]string{presentRef, missingRef}, cn.Refs())
+		assert.Len(cn.Tags(presentRef), 2)
+		assert.Empty(cn.Tags(missingRef))
+		assert.Nil(cn.Err(presentRef))
+		assert.True(errors.Is(cn.Err(missingRef), ErrNotFound))
+		assert.Equal([]string{missingRef}, cn.FailedRefs())
+	}
+
+	var collectErr *CollectError
+	if assert.True(errors.As(err, &collectErr)) {
+		assert.Equal([]string{missingRef}, collectErr.Refs)
+	}
+	assert.True(errors.Is(err, ErrNotFound))
+	assert.False(errors.Is(err, ErrUnauthorized))
+
+	pushCn, err := api.CollectPushTags(cn, PushConfig{Registry: registry, Prefix: "/mirror", PathTemplate: "{{ .Prefix }}{{ .Path }}"})
+	assert.Nil(err)
+	if assert.NotNil(pushCn) {
+		assert.Len(pushCn.Tags(presentRef), 2)
+		assert.Empty(pushCn.Tags(missingRef))
+		assert.Equal([]string{missingRef}, pushCn.FailedRefs())
+	}
+}
+
+func TestCollectTags_MetadataCache(t *testing.T) {
+	assert := assert.New(t)
+
+	fr := newFakeRegistry()
+
+	server := httptest.NewServer(fr)
+	defer server.Close()
+
+	registry := strings.TrimPrefix(server.URL, "http://")
+
+	seedFakeRegistry(t, registry)
+
+	dir, err := ioutil.TempDir("", "lstags-cache-")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	ref := registry + "/lstags/flannel"
+
+	collect := func() (map[string]*tag.Tag, int, int, int) {
+		api, err := New(Config{CacheDir: dir})
+		assert.Nil(err)
+
+		fr.mux.Lock()
+		fr.manifestGets, fr.manifestHeads, fr.notModified = 0, 0, 0
+		fr.mux.Unlock()
+
+		cn, err := api.CollectTags(ref)
+		assert.Nil(err)
+
+		fr.mux.Lock()
+		defer fr.mux.Unlock()
+
+		return cn.TagMap(ref), fr.manifestGets, fr.manifestHeads, fr.notModified
+	}
+
+	tags, manifestGets, manifestHeads, notModified := collect()
+	assert.Len(tags, 2)
+	assert.Equal(2, manifestHeads, "should resolve digest of every tag with HEAD")
+	assert.Equal(2, manifestGets, "should only GET v1 history of every tag")
+	assert.Equal(0, notModified)
+
+	cachedTags, manifestGets, manifestHeads, notModified := collect()
+	assert.Len(cachedTags, 2)
+	assert.Equal(2, manifestHeads, "should only revalidate digest of every tag")
+	assert.Equal(0, manifestGets)
+	assert.Equal(2, notModified)
+
+	for name, tg := range tags {
+		assert.Equal(tg.GetDigest(), cachedTags[name].GetDigest(), name)
+		assert.Equal(tg.GetCreated(), cachedTags[name].GetCreated(), name)
+	}
+}
+
+func TestCollectTags_NoDigestHeader(t *testing.T) {
+	assert := assert.New(t)
+
+	fr := newFakeRegistry()
+
+	server := httptest.NewServer(fr)
+	defer server.Close()
+
+	registry := strings.TrimPrefix(server.URL, "http://")
+
+	seedFakeRegistry(t, registry)
+
+	src, err := layout.Open("../../fixtures/oci/layout")
+	assert.Nil(err)
+
+	fr.mux.Lock()
+	fr.noDigestHeader = true
+	fr.mux.Unlock()
+
+	api, err := New(Config{})
+	assert.Nil(err)
+
+	ref := registry + "/lstags/flannel"
+
+	cn, err := api.CollectTags(ref)
+	assert.Nil(err)
+
+	for _, tg := range cn.Tags(ref) {
+		d, err := src.Resolve("quay.io/coreos/flannel:" + tg.Name())
+		assert.Nil(err, tg.Name())
+
+		assert.Equal(d.Digest, tg.GetDigest(), "should calculate digest of manifest fetched")
+	}
+
+	fr.mux.Lock()
+	defer fr.mux.Unlock()
+
+	assert.Equal(2, fr.manifestHeads)
+	assert.Equal(4, fr.manifestGets, "should fall back to GET to get digest of every tag")
+}
+
+func TestPushTagsToTargets(t *testing.T) {
+	assert := assert.New(t)
+
+	src := newFakeRegistry()
+
+	server := httptest.NewServer(src)
+	defer server.Close()
+
+	registry := strings.TrimPrefix(server.URL, "http://")
+
+	seedFakeRegistry(t, registry)
+
+	targets := []*fakeRegistry{newFakeRegistry(), newFakeRegistry()}
+	pushes := make([]PushConfig, len(targets))
+	for i, target := range targets {
+		targetServer := httptest.NewServer(target)
+		defer targetServer.Close()
+
+		pushes[i] = PushConfig{
+			Registry:      strings.TrimPrefix(targetServer.URL, "http://"),
+			Prefix:        "/mirror",
+			PathSeparator: "/",
+			PathTemplate:  "{{ .Prefix }}{{ .Path }}",
+			TagTemplate:   "{{ .Tag }}",
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "lstags-oci-layout-")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	api, err := New(Config{OCILayoutDir: dir})
+	assert.Nil(err)
+
+	var recorder eventRecorder
+	api.Subscribe(recorder.handle)
+
+	ref := registry + "/lstags/flannel"
+
+	cn, err := api.CollectTags(ref)
+	assert.Nil(err)
+
+	pushCns, err := api.CollectPushTagsForTargets(cn, pushes)
+	assert.Nil(err)
+	if assert.Len(pushCns, len(pushes)) {
+		for _, pushCn := range pushCns {
+			assert.Len(pushCn.Tags(ref), 2)
+		}
+	}
+
+	src.mux.Lock()
+	src.manifestGets = 0
+	src.mux.Unlock()
+
+	assert.Nil(api.PushTagsToTargets(pushCns, pushes))
+
+	src.mux.Lock()
+	// image index and image manifest for each of 2 images
+	assert.Equal(4, src.manifestGets, "should pull every source image only once")
+	src.mux.Unlock()
+
+	assert.Len(recorder.ofType(EventPushStart), 4)
+	assert.Len(recorder.ofType(EventPushFinish), 4)
+	assert.Empty(recorder.ofType(EventError))
+
+	assert.Len(recorder.ofType(EventPushVerified), 4)
+	for _, e := range recorder.ofType(EventPushVerified) {
+		assert.Equal(e.Digest, e.DestinationDigest, "should verify digest of the image pushed")
+		assert.Empty(e.Platform)
+	}
+
+	for _, target := range targets {
+		target.mux.Lock()
+		for _, tagName := range []string{"v0.10.0", "v0.11.0"} {
+			_, defined := target.manifests["mirror/lstags/flannel@"+tagName]
+			assert.True(defined, "should push %s to every target", tagName)
+		}
+		target.mux.Unlock()
+	}
+
+	pushCns, err = api.CollectPushTagsForTargets(cn, pushes)
+	assert.Nil(err)
+	for _, pushCn := range pushCns {
+		assert.Empty(pushCn.Tags(ref), "should have nothing to push after push")
+	}
+
+	assert.NotNil(api.PushTagsToTargets(pushCns, pushes[:1]), "should fail on collections/configs mismatch")
+}
+
+func TestCollectPushTagsForTargets_TypedErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(newFakeRegistry())
+	defer server.Close()
+
+	registry := strings.TrimPrefix(server.URL, "http://")
+
+	seedFakeRegistry(t, registry)
+
+	forbiddenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer forbiddenServer.Close()
+
+	push := PushConfig{
+		Registry:      strings.TrimPrefix(forbiddenServer.URL, "http://"),
+		Prefix:        "/mirror",
+		PathSeparator: "/",
+		PathTemplate:  "{{ .Prefix }}{{ .Path }}",
+		TagTemplate:   "{{ .Tag }}",
+	}
+
+	api, err := New(Config{})
+	assert.Nil(err)
+
+	cn, err := api.CollectTags(registry + "/lstags/flannel")
+	assert.Nil(err)
+
+	_, err = api.CollectPushTagsForTargets(cn, []PushConfig{push})
+	if assert.NotNil(err) {
+		assert.Contains(err.Error(), push.Registry)
+		assert.True(errors.Is(err, ErrForbidden), "%v", err)
+	}
+}
+
+func TestCollectPushTags_ProtectedTags(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(newFakeRegistry())
+	defer server.Close()
+
+	registry := strings.TrimPrefix(server.URL, "http://")
+
+	seedFakeRegistry(t, registry)
+
+	dstServer := httptest.NewServer(newFakeRegistry())
+	defer dstServer.Close()
+
+	push := PushConfig{
+		Registry:      strings.TrimPrefix(dstServer.URL, "http://"),
+		Prefix:        "/mirror",
+		PathSeparator: "/",
+		PathTemplate:  "{{ .Prefix }}{{ .Path }}",
+		TagTemplate:   "{{ .Tag }}",
+		UpdateChanged: true,
+		ProtectedTags: `^v[0-9]+\.[0-9]+\.[0-9]+$`,
+	}
+
+	// make "v0.11.0" tag differ in the "push" registry
+	cli, err := client.New(push.Registry, client.Config{IsInsecure: true})
+	assert.Nil(err)
+	assert.Nil(cli.Login("", ""))
+
+	l, err := layout.Open("../../fixtures/oci/layout")
+	assert.Nil(err)
+
+	d, err := l.Resolve("quay.io/coreos/flannel:v0.10.0")
+	assert.Nil(err)
+	assert.Nil(copyFromLayout(l, *d, cli, "mirror/lstags/flannel", "v0.11.0"))
+
+	api, err := New(Config{})
+	assert.Nil(err)
+
+	var recorder eventRecorder
+	api.Subscribe(recorder.handle)
+
+	ref := registry + "/lstags/flannel"
+
+	cn, err := api.CollectTags(ref)
+	assert.Nil(err)
+
+	pushCn, err := api.CollectPushTags(cn, push)
+
+	assert.True(errors.Is(err, ErrTagConflict), "%v", err)
+
+	var conflictErr *ConflictError
+	if assert.True(errors.As(err, &conflictErr)) && assert.Len(conflictErr.Conflicts, 1) {
+		assert.Equal(ref+":v0.11.0", conflictErr.Conflicts[0].SourceRef)
+		assert.Equal(push.Registry+"/mirror/lstags/flannel:v0.11.0", conflictErr.Conflicts[0].DestinationRef)
+	}
+
+	if assert.NotNil(pushCn, "should return 'push' collection along with conflicts") && assert.Len(pushCn.Tags(ref), 1) {
+		assert.Equal("v0.10.0", pushCn.Tags(ref)[0].Name())
+	}
+
+	errorEvents := recorder.ofType(EventError)
+	if assert.Len(errorEvents, 1) {
+		assert.Equal(push.Registry+"/mirror/lstags/flannel:v0.11.0", errorEvents[0].Destination)
+		assert.True(errors.Is(errorEvents[0].Err, ErrTagConflict))
+	}
+
+	for _, tg := range cn.Tags(ref) {
+		assert.NotEqual("CONFLICT", tg.GetState(), "should not change states of the source collection")
+	}
+
+	p, err := api.MakePlan(cn, []PushConfig{push})
+	assert.Nil(err)
+	if assert.Len(p.Entries, 2) {
+		assert.Equal(plan.Push, p.Entries[0].Action)
+		assert.Equal(plan.Conflict, p.Entries[1].Action)
+	}
+
+	push.ProtectedTags = ""
+
+	pushCn, err = api.CollectPushTags(cn, push)
+	assert.Nil(err)
+	assert.Len(pushCn.Tags(ref), 2, "should update changed tags, if they are not protected")
+}
+
+func TestCollectPushTags_ProtectedTags_TagTemplate(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(newFakeRegistry())
+	defer server.Close()
+
+	registry := strings.TrimPrefix(server.URL, "http://")
+
+	seedFakeRegistry(t, registry)
+
+	dstServer := httptest.NewServer(newFakeRegistry())
+	defer dstServer.Close()
+
+	push := PushConfig{
+		Registry:      strings.TrimPrefix(dstServer.URL, "http://"),
+		Prefix:        "/mirror",
+		PathSeparator: "/",
+		PathTemplate:  "{{ .Prefix }}{{ .Path }}",
+		TagTemplate:   "release-{{ .Tag }}",
+		UpdateChanged: true,
+		ProtectedTags: `^release-`,
+	}
+
+	// make "v0.11.0" tag (pushed as "release-v0.11.0") differ in the "push" registry
+	cli, err := client.New(push.Registry, client.Config{IsInsecure: true})
+	assert.Nil(err)
+	assert.Nil(cli.Login("", ""))
+
+	l, err := layout.Open("../../fixtures/oci/layout")
+	assert.Nil(err)
+
+	d, err := l.Resolve("quay.io/coreos/flannel:v0.10.0")
+	assert.Nil(err)
+	assert.Nil(copyFromLayout(l, *d, cli, "mirror/lstags/flannel", "release-v0.11.0"))
+
+	api, err := New(Config{})
+	assert.Nil(err)
+
+	ref := registry + "/lstags/flannel"
+
+	cn, err := api.CollectTags(ref)
+	assert.Nil(err)
+
+	pushCn, err := api.CollectPushTags(cn, push)
+
+	var conflictErr *ConflictError
+	if assert.True(errors.As(err, &conflictErr), "%v", err) && assert.Len(conflictErr.Conflicts, 1) {
+		assert.Equal(ref+":v0.11.0", conflictErr.Conflicts[0].SourceRef)
+		assert.Equal(push.Registry+"/mirror/lstags/flannel:release-v0.11.0", conflictErr.Conflicts[0].DestinationRef)
+	}
+
+	if assert.NotNil(pushCn) && assert.Len(pushCn.Tags(ref), 1) {
+		assert.Equal("v0.10.0", pushCn.Tags(ref)[0].Name())
+	}
+
+	// expression matches source tag names, but not the names tags are pushed with
+	push.ProtectedTags = `^v[0-9]+\.[0-9]+\.[0-9]+$`
+
+	pushCn, err = api.CollectPushTags(cn, push)
+	assert.Nil(err)
+	assert.Len(pushCn.Tags(ref), 2, "should match protected tags expression against tags pushed, not source ones")
+}
+
+func TestPushTags_RepoWithNoPushRegistry(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(newFakeRegistry())
+	defer server.Close()
+
+	registry := strings.TrimPrefix(server.URL, "http://")
+
+	seedFakeRegistry(t, registry)
+
+	target := newFakeRegistry()
+
+	targetServer := httptest.NewServer(target)
+	defer targetServer.Close()
+
+	withRegistry := registry + "/lstags/flannel:v0.10.0"
+	withNoRegistry := registry + "/lstags/flannel:v0.11.0"
+
+	// global push registry is not set, only one of repositories has its own one
+	push := PushConfig{
+		PathSeparator: "/",
+		PathTemplate:  "{{ .Prefix }}{{ .Path }}",
+		TagTemplate:   "{{ .Tag }}",
+		RepoOverrides: map[string]PushConfig{
+			withRegistry: {
+				Registry:      strings.TrimPrefix(targetServer.URL, "http://"),
+				Prefix:        "/mirror",
+				PathSeparator: "/",
+				PathTemplate:  "{{ .Prefix }}{{ .Path }}",
+				TagTemplate:   "{{ .Tag }}",
+			},
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "lstags-oci-layout-")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	api, err := New(Config{OCILayoutDir: dir})
+	assert.Nil(err)
+
+	cn, err := api.CollectTags(withRegistry, withNoRegistry)
+	assert.Nil(err)
+
+	pushCn, err := api.CollectPushTags(cn, push)
+	assert.Nil(err)
+	assert.Len(pushCn.Tags(withRegistry), 1)
+	assert.Len(pushCn.Tags(withNoRegistry), 0, "should not push repository with no push registry")
+
+	assert.Nil(api.PushTags(pushCn, push))
+
+	_, defined := target.manifests["mirror/lstags/flannel@v0.10.0"]
+	assert.True(defined)
+	_, defined = target.manifests["mirror/lstags/flannel@v0.11.0"]
+	assert.False(defined)
+
+	// even if collection to push is made by someone else, repository with no push registry is skipped
+	assert.Nil(api.PushTags(cn, push))
+}
+
+func TestPushTags_TypedErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	src := newFakeRegistry()
+
+	server := httptest.NewServer(src)
+	defer server.Close()
+
+	registry := strings.TrimPrefix(server.URL, "http://")
+
+	seedFakeRegistry(t, registry)
+
+	dstServer := httptest.NewServer(newFakeRegistry())
+	defer dstServer.Close()
+
+	push := PushConfig{
+		Registry:      strings.TrimPrefix(dstServer.URL, "http://"),
+		Prefix:        "/mirror",
+		PathSeparator: "/",
+		PathTemplate:  "{{ .Prefix }}{{ .Path }}",
+		TagTemplate:   "{{ .Tag }}",
+	}
+
+	dir, err := ioutil.TempDir("", "lstags-oci-layout-")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	api, err := New(Config{OCILayoutDir: dir})
+	assert.Nil(err)
+
+	ref := registry + "/lstags/flannel"
+
+	cn, err := api.CollectTags(ref)
+	assert.Nil(err)
+
+	pushCn, err := api.CollectPushTags(cn, push)
+	assert.Nil(err)
+
+	// images are gone from the source registry after we collected them
+	src.mux.Lock()
+	for key := range src.manifests {
+		delete(src.manifests, key)
+	}
+	src.mux.Unlock()
+
+	err = api.PushTags(pushCn, push)
+
+	assert.True(errors.Is(err, ErrNotFound), "%v", err)
+
+	var registryErr *RegistryError
+	if assert.True(errors.As(err, &registryErr), "%v", err) {
+		assert.Equal(http.StatusNotFound, registryErr.StatusCode)
+	}
+}
@@ -0,0 +1,123 @@
+package v1
+
+import (
+	"fmt"
+
+	dockerclient "github.com/ivanilves/lstags/docker/client"
+	"github.com/ivanilves/lstags/oci/layout"
+	"github.com/ivanilves/lstags/repository"
+)
+
+// verifyPushed resolves digest of the image pushed in the "push" registry and compares it with the source image digest
+// (or with per-platform digests, if source image is an index, as Docker daemon pushes only the platform it has pulled)
+// NB! Returns digest of the image pushed and the platform it matches (if it matches the single platform of the source)
+func (api *API) verifyPushed(src *pushSource, dst pushDestination) (string, string, error) {
+	return api.verifyDigest(dst.ref, dst.dockerClient, src.tag.GetDigest(), func() (map[string]string, error) {
+		return api.sourcePlatforms(src.repo, src.tag.GetDigest())
+	})
+}
+
+// verifyDigest compares digest of the image pushed (REGISTRY/PATH:TAG) with the source image digest passed,
+// per-platform digests of the source image are only got (with the function passed), if digests differ
+func (api *API) verifyDigest(
+	dstRef string,
+	dockerClient *dockerclient.DockerClient,
+	srcDigest string,
+	getPlatforms func() (map[string]string, error),
+) (string, string, error) {
+	dstDigest, err := api.pushedDigest(dstRef, dockerClient)
+	if err != nil {
+		return "", "", err
+	}
+
+	if dstDigest == srcDigest {
+		return dstDigest, "", nil
+	}
+
+	platforms, err := getPlatforms()
+	if err != nil {
+		return "", "", err
+	}
+
+	if platform, defined := platforms[dstDigest]; defined {
+		return dstDigest, platform, nil
+	}
+
+	return dstDigest, "", fmt.Errorf("%w (source: %s, destination: %s)", ErrDigestMismatch, srcDigest, dstDigest)
+}
+
+// pushedDigest gets digest of the image in the "push" registry by its reference (REGISTRY/PATH:TAG)
+func (api *API) pushedDigest(dstRef string, dockerClient *dockerclient.DockerClient) (string, error) {
+	dstRepo, err := repository.ParseRef(dstRef)
+	if err != nil {
+		return "", err
+	}
+
+	username, password := api.credentials(dstRepo.Registry(), dockerClient.Config())
+
+	cli, err := api.remote.NewClient(dstRepo, username, password)
+	if err != nil {
+		return "", err
+	}
+
+	return cli.Digest(dstRepo.Path(), dstRepo.Tags()[0])
+}
+
+// sourcePlatforms gets platforms (keyed by their manifest digests) of the source image passed,
+// empty map is returned, if source image is not an index (i.e. is a single platform image)
+func (api *API) sourcePlatforms(repo *repository.Repository, digest string) (map[string]string, error) {
+	username, password := api.credentials(repo.Registry(), api.dockerClient.Config())
+
+	cli, err := api.remote.NewClient(repo, username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	mediaType, _, data, err := cli.Manifest(repo.Path(), digest)
+	if err != nil {
+		return nil, err
+	}
+
+	return indexPlatforms(mediaType, data)
+}
+
+// layoutPlatforms gets platforms (keyed by their manifest digests) of the OCI layout image passed,
+// empty map is returned, if image is not an index (i.e. is a single platform image)
+func layoutPlatforms(l *layout.Layout, d layout.Descriptor) (map[string]string, error) {
+	if !layout.IsIndex(d.MediaType) {
+		return make(map[string]string), nil
+	}
+
+	data, err := l.ReadBlobBytes(d.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	return indexPlatforms(d.MediaType, data)
+}
+
+// indexPlatforms gets platforms (keyed by their manifest digests) of the image manifest or index passed,
+// either OCI index or Docker manifest list, empty map is returned, if it is not an index (i.e. is a single platform image)
+func indexPlatforms(mediaType string, data []byte) (map[string]string, error) {
+	platforms := make(map[string]string)
+
+	if !layout.IsIndex(mediaType) {
+		return platforms, nil
+	}
+
+	children, err := layout.Children(mediaType, data)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, child := range children {
+		platform := "unknown"
+		if child.Platform != nil {
+			platform = child.Platform.String()
+		}
+
+		platforms[child.Digest] = platform
+	}
+
+	return platforms, nil
+}
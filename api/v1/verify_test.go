@@ -0,0 +1,196 @@
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ivanilves/lstags/api/v1/registry/client"
+	"github.com/ivanilves/lstags/oci/layout"
+)
+
+func TestVerifyPushed(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(newFakeRegistry())
+	defer server.Close()
+
+	registry := strings.TrimPrefix(server.URL, "http://")
+
+	seedFakeRegistry(t, registry)
+
+	api, err := New(Config{})
+	assert.Nil(err)
+
+	cn, err := api.CollectTags(registry + "/lstags/flannel=v0.10.0,v0.11.0")
+	assert.Nil(err)
+
+	ref := cn.Refs()[0]
+	srcTags := cn.TagMap(ref)
+
+	cli, err := client.New(registry, client.Config{IsInsecure: true})
+	assert.Nil(err)
+	assert.Nil(cli.Login("", ""))
+
+	l, err := layout.Open("../../fixtures/oci/layout")
+	assert.Nil(err)
+
+	// push a single platform of the source index, as Docker daemon does
+	d, err := l.Resolve("quay.io/coreos/flannel:v0.10.0")
+	assert.Nil(err)
+	assert.Nil(copyFromLayout(l, *d, cli, "mirror/flannel", "single"))
+
+	dockerClient, err := api.pushDockerClient(PushConfig{})
+	assert.Nil(err)
+
+	var testCases = []struct {
+		srcTagName string
+		dstRef     string
+		platform   string
+		err        error
+	}{
+		{"v0.11.0", registry + "/lstags/flannel:v0.11.0", "", nil},
+		{"v0.11.0", registry + "/mirror/flannel:single", "linux/amd64", nil},
+		{"v0.10.0", registry + "/lstags/flannel:v0.11.0", "", ErrDigestMismatch},
+		{"v0.11.0", registry + "/mirror/flannel:missing", "", ErrNotFound},
+	}
+
+	for _, tc := range testCases {
+		src := &pushSource{repo: cn.Repo(ref), tag: srcTags[tc.srcTagName]}
+		dst := pushDestination{ref: tc.dstRef, tag: src.tag, dockerClient: dockerClient}
+
+		digest, platform, err := api.verifyPushed(src, dst)
+
+		if tc.err == nil {
+			assert.Nil(err, tc.dstRef)
+			assert.NotEmpty(digest, tc.dstRef)
+		} else {
+			assert.True(errors.Is(err, tc.err), "%s: %v", tc.dstRef, err)
+		}
+
+		assert.Equal(tc.platform, platform, tc.dstRef)
+	}
+}
+
+func TestVerifyPushed_DockerManifestList(t *testing.T) {
+	assert := assert.New(t)
+
+	fr := newFakeRegistry()
+	fr.negotiate = true
+
+	server := httptest.NewServer(fr)
+	defer server.Close()
+
+	registry := strings.TrimPrefix(server.URL, "http://")
+
+	seedFakeRegistry(t, registry)
+
+	// make Docker manifest list of the source index (the same platform manifests, but another media type)
+	fr.mux.Lock()
+	var list map[string]interface{}
+	assert.Nil(json.Unmarshal(fr.manifests["lstags/flannel@v0.11.0"], &list))
+	list["mediaType"] = layout.MediaTypeDockerList
+	data, err := json.Marshal(list)
+	assert.Nil(err)
+	for _, key := range []string{"lstags/flannel@list", "lstags/flannel@" + layout.Digest(data)} {
+		fr.manifests[key] = data
+		fr.types[key] = layout.MediaTypeDockerList
+	}
+	fr.mux.Unlock()
+
+	api, err := New(Config{})
+	assert.Nil(err)
+
+	cn, err := api.CollectTags(registry + "/lstags/flannel=list")
+	assert.Nil(err)
+
+	ref := cn.Refs()[0]
+	srcTag := cn.TagMap(ref)["list"]
+	if srcTag == nil {
+		t.Fatalf("should collect tag of Docker manifest list")
+	}
+	assert.Equal(layout.Digest(data), srcTag.GetDigest())
+
+	cli, err := client.New(registry, client.Config{IsInsecure: true})
+	assert.Nil(err)
+	assert.Nil(cli.Login("", ""))
+
+	l, err := layout.Open("../../fixtures/oci/layout")
+	assert.Nil(err)
+
+	// push a single platform of the source manifest list, as Docker daemon does
+	d, err := l.Resolve("quay.io/coreos/flannel:v0.10.0")
+	assert.Nil(err)
+	assert.Nil(copyFromLayout(l, *d, cli, "mirror/flannel", "single"))
+
+	dockerClient, err := api.pushDockerClient(PushConfig{})
+	assert.Nil(err)
+
+	src := &pushSource{repo: cn.Repo(ref), tag: srcTag}
+	dst := pushDestination{ref: registry + "/mirror/flannel:single", tag: srcTag, dockerClient: dockerClient}
+
+	digest, platform, err := api.verifyPushed(src, dst)
+
+	assert.Nil(err)
+	assert.Equal(d.Digest, digest)
+	assert.Equal("linux/amd64", platform)
+}
+
+func TestPushTags_VerificationFailed(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(newFakeRegistry())
+	defer server.Close()
+
+	registry := strings.TrimPrefix(server.URL, "http://")
+
+	seedFakeRegistry(t, registry)
+
+	dst := newFakeRegistry()
+	dst.overwriteTags = true
+
+	dstServer := httptest.NewServer(dst)
+	defer dstServer.Close()
+
+	dir, err := ioutil.TempDir("", "lstags-oci-layout-")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	api, err := New(Config{OCILayoutDir: dir})
+	assert.Nil(err)
+
+	var recorder eventRecorder
+	api.Subscribe(recorder.handle)
+
+	push := PushConfig{
+		Registry:      strings.TrimPrefix(dstServer.URL, "http://"),
+		Prefix:        "/mirror",
+		PathSeparator: "/",
+		PathTemplate:  "{{ .Prefix }}{{ .Path }}",
+		TagTemplate:   "{{ .Tag }}",
+	}
+
+	cn, err := api.CollectTags(registry + "/lstags/flannel=v0.10.0")
+	assert.Nil(err)
+
+	pushCn, err := api.CollectPushTags(cn, push)
+	assert.Nil(err)
+
+	err = api.PushTags(pushCn, push)
+	assert.True(errors.Is(err, ErrDigestMismatch), "should fail, if digest of the image pushed does not match: %v", err)
+
+	assert.Empty(recorder.ofType(EventPushVerified))
+	assert.Empty(recorder.ofType(EventPushFinish))
+
+	errorEvents := recorder.ofType(EventError)
+	if assert.Len(errorEvents, 1) {
+		assert.True(errors.Is(errorEvents[0].Err, ErrDigestMismatch), "%v", errorEvents[0].Err)
+		assert.NotEqual(errorEvents[0].Digest, errorEvents[0].DestinationDigest)
+	}
+}
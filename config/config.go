@@ -2,20 +2,70 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"os"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v2"
 
+	v1 "github.com/ivanilves/lstags/api/v1"
 	"github.com/ivanilves/lstags/util/fix"
 )
 
-// Config holds repository list (e.g. loadable from YAML file)
+// Config holds repository list and application options (e.g. loadable from YAML file)
 // NB!
-// There are no mapping between main.Options and config.Config!
-// We could implement it, but we need to see an explicit demand
-// for this feature from our stakeholders.
+// Options are named exactly like long CLI options (e.g. "push-registry"),
+// so you could easily map config keys to main.Options and vice versa.
+// Pointers are used to distinguish options left unset from ones set to zero values.
 type Config struct {
-	Repositories []string `yaml:"repositories"`
+	// Repositories are references of all repositories we load (incl. the ones defined with settings)
+	Repositories []string `yaml:"-"`
+	// RepositoryEntries are repositories as they are defined in YAML (either plain references or objects)
+	RepositoryEntries []Repository `yaml:"repositories"`
+	// Registries hold registry-specific settings (keyed by registry ADDR[:PORT])
+	Registries map[string]Registry `yaml:"registries"`
+	// PushTargets are multiple registries we push to (used instead of a single "push-registry", if defined)
+	PushTargets []PushTarget `yaml:"push-targets"`
+
+	DockerJSON         *string        `yaml:"docker-json"`
+	Pull               *bool          `yaml:"pull"`
+	Push               *bool          `yaml:"push"`
+	DryRun             *bool          `yaml:"dry-run"`
+	PushRegistry       *string        `yaml:"push-registry"`
+	PushPrefix         *string        `yaml:"push-prefix"`
+	PushPathTemplate   *string        `yaml:"push-path-template"`
+	PushTagTemplate    *string        `yaml:"push-tag-template"`
+	NoSSLVerify        *bool          `yaml:"no-ssl-verify"`
+	PushCleanup        *bool          `yaml:"push-cleanup"`
+	PushUpdate         *bool          `yaml:"push-update"`
+	PushProtectedTags  *string        `yaml:"push-protected-tags"`
+	PathSeparator      *string        `yaml:"path-separator"`
+	ConcurrentRequests *int           `yaml:"concurrent-requests"`
+	RequestRate        *float64       `yaml:"request-rate"`
+	WaitBetween        *time.Duration `yaml:"wait-between"`
+	RetryRequests      *int           `yaml:"retry-requests"`
+	RetryDelay         *time.Duration `yaml:"retry-delay"`
+	InsecureRegistryEx *string        `yaml:"insecure-registry-ex"`
+	BasicAuth          *[]string      `yaml:"basic-auth"`
+	KubeSecret         *string        `yaml:"kube-secret"`
+	Netrc              *string        `yaml:"netrc"`
+	TraceRequests      *bool          `yaml:"trace-requests"`
+	DoNotFail          *bool          `yaml:"do-not-fail"`
+	DaemonMode         *bool          `yaml:"daemon-mode"`
+	PollingInterval    *time.Duration `yaml:"polling-interval"`
+	NotificationListen *string        `yaml:"notification-listen"`
+	OCILayout          *string        `yaml:"oci-layout"`
+	CacheDir           *string        `yaml:"cache-dir"`
+	CacheTTL           *time.Duration `yaml:"cache-ttl"`
+	Prune              *bool          `yaml:"prune"`
+	PruneKeepNewest    *int           `yaml:"prune-keep-newest"`
+	PruneKeepUpstream  *bool          `yaml:"prune-keep-upstream"`
+	PruneLocalOnly     *bool          `yaml:"prune-local-only"`
+	PruneChanged       *bool          `yaml:"prune-changed"`
+	OutputFormat       *string        `yaml:"output-format"`
+	Verbose            *bool          `yaml:"verbose"`
 }
 
 // LoadYAMLFile loads YAML file into Config structure
@@ -33,9 +83,303 @@ func LoadYAMLFile(path string) (*Config, error) {
 		return nil, err
 	}
 
-	if structure.ConfigRoot.Repositories == nil {
+	if structure.ConfigRoot.RepositoryEntries == nil {
 		return nil, errors.New("no repos could be loaded from: " + path)
 	}
 
-	return &structure.ConfigRoot, nil
+	c := &structure.ConfigRoot
+
+	for hostname, r := range c.Registries {
+		if err := r.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid settings for registry '%s': %s", hostname, err.Error())
+		}
+	}
+
+	for i, t := range c.PushTargets {
+		if err := t.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid push target #%d: %s", i+1, err.Error())
+		}
+	}
+
+	c.Repositories = make([]string, len(c.RepositoryEntries))
+	for i, r := range c.RepositoryEntries {
+		ref, err := r.FullRef()
+		if err != nil {
+			return nil, err
+		}
+
+		c.Repositories[i] = ref
+
+		if len(c.PushTargets) != 0 && r.PushRegistry != nil {
+			return nil, fmt.Errorf("repository could not have its own 'push-registry' with 'push-targets' defined: %s", r.Ref)
+		}
+	}
+
+	return c, nil
+}
+
+// Repository gets repository entry by its (full) reference, nil if not found
+func (c *Config) Repository(ref string) *Repository {
+	for i, r := range c.Repositories {
+		if r == ref {
+			return &c.RepositoryEntries[i]
+		}
+	}
+
+	return nil
+}
+
+// HasPushRegistry tells us if there are push targets defined or any of repositories has its own push registry set
+func (c *Config) HasPushRegistry() bool {
+	if len(c.PushTargets) != 0 {
+		return true
+	}
+
+	for _, r := range c.RepositoryEntries {
+		if r.PushRegistry != nil && *r.PushRegistry != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PushConfigs makes push configurations for all push targets defined (or the single push configuration passed,
+// if there are no push targets defined) with per-repository overrides (keyed by full repository references).
+// NB! Push configuration passed is made of global push options (CLI ones, with YAML ones applied over the unset ones),
+// push target settings override global options and repository settings override both of them.
+func (c *Config) PushConfigs(push v1.PushConfig) []v1.PushConfig {
+	if c == nil {
+		return []v1.PushConfig{push}
+	}
+
+	if len(c.PushTargets) == 0 {
+		return []v1.PushConfig{c.withRepoOverrides(push)}
+	}
+
+	pushConfigs := make([]v1.PushConfig, len(c.PushTargets))
+
+	for i, t := range c.PushTargets {
+		pushConfig := push
+		pushConfig.Registry = t.Registry
+
+		for _, s := range []struct {
+			value  *string
+			target *string
+		}{
+			{t.Prefix, &pushConfig.Prefix},
+			{t.PathTemplate, &pushConfig.PathTemplate},
+			{t.TagTemplate, &pushConfig.TagTemplate},
+			{t.DockerJSON, &pushConfig.DockerJSONConfigFile},
+			{t.ProtectedTags, &pushConfig.ProtectedTags},
+		} {
+			if s.value != nil {
+				*s.target = *s.value
+			}
+		}
+
+		if t.Update != nil {
+			pushConfig.UpdateChanged = *t.Update
+		}
+
+		pushConfigs[i] = c.withRepoOverrides(pushConfig)
+	}
+
+	return pushConfigs
+}
+
+// withRepoOverrides adds per-repository overrides to the push configuration passed
+// (repositories with invalid references are skipped, they could not be processed anyway)
+func (c *Config) withRepoOverrides(push v1.PushConfig) v1.PushConfig {
+	push.RepoOverrides = make(map[string]v1.PushConfig)
+
+	for _, r := range c.RepositoryEntries {
+		ref, err := r.FullRef()
+		if err != nil {
+			continue
+		}
+
+		override := push
+		override.RepoOverrides = nil
+
+		isOverridden := false
+		for _, s := range []struct {
+			value  *string
+			target *string
+		}{
+			{r.PushRegistry, &override.Registry},
+			{r.PushPrefix, &override.Prefix},
+			{r.PushPathTemplate, &override.PathTemplate},
+			{r.PushTagTemplate, &override.TagTemplate},
+			{r.PushDockerJSON, &override.DockerJSONConfigFile},
+			{r.PushProtectedTags, &override.ProtectedTags},
+		} {
+			if s.value != nil {
+				*s.target = *s.value
+				isOverridden = true
+			}
+		}
+
+		if r.PushUpdate != nil {
+			override.UpdateChanged = *r.PushUpdate
+			isOverridden = true
+		}
+
+		if isOverridden {
+			push.RepoOverrides[ref] = override
+		}
+	}
+
+	return push
+}
+
+// Repository holds repository reference with optional per-repository settings.
+// In YAML it could be either a plain reference string, or an object with "ref" and settings:
+//   - quay.io/coreos/flannel~/^v0\.10/
+//   - ref: quay.io/coreos/flannel
+//     filter: ^v0\.10
+//     push-prefix: /quay
+type Repository struct {
+	Ref    string   `yaml:"ref"`
+	Tags   []string `yaml:"tags"`
+	Filter string   `yaml:"filter"`
+
+	PushRegistry      *string `yaml:"push-registry"`
+	PushPrefix        *string `yaml:"push-prefix"`
+	PushPathTemplate  *string `yaml:"push-path-template"`
+	PushTagTemplate   *string `yaml:"push-tag-template"`
+	PushUpdate        *bool   `yaml:"push-update"`
+	PushDockerJSON    *string `yaml:"push-docker-json"`
+	PushProtectedTags *string `yaml:"push-protected-tags"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler interface to load repository either from string or from object
+func (r *Repository) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var ref string
+	if err := unmarshal(&ref); err == nil {
+		*r = Repository{Ref: ref}
+
+		return nil
+	}
+
+	type plain Repository
+
+	return unmarshal((*plain)(r))
+}
+
+// FullRef gives us repository reference with tags or filter (if set separately) appended
+func (r Repository) FullRef() (string, error) {
+	if r.Ref == "" {
+		return "", errors.New("repository has no reference defined")
+	}
+
+	if len(r.Tags) == 0 && r.Filter == "" {
+		return r.Ref, nil
+	}
+
+	if len(r.Tags) != 0 && r.Filter != "" {
+		return "", fmt.Errorf("repository could have either tags or filter, not both: %s", r.Ref)
+	}
+
+	if strings.ContainsAny(r.Ref, "~=") {
+		return "", fmt.Errorf("repository already has tags or filter in its reference: %s", r.Ref)
+	}
+
+	if r.Filter != "" {
+		return r.Ref + "~/" + r.Filter + "/", nil
+	}
+
+	return r.Ref + "=" + strings.Join(r.Tags, ","), nil
+}
+
+// PushTarget holds settings of one of multiple registries we push to,
+// settings left unset are taken from global push options (e.g. "push-prefix"):
+//   - registry: registry.eu.company.io
+//     prefix: /mirror
+//   - registry: registry.us.company.io
+//     docker-json: ~/.docker/us.json
+type PushTarget struct {
+	Registry      string  `yaml:"registry"`
+	Prefix        *string `yaml:"prefix"`
+	PathTemplate  *string `yaml:"path-template"`
+	TagTemplate   *string `yaml:"tag-template"`
+	Update        *bool   `yaml:"update"`
+	DockerJSON    *string `yaml:"docker-json"`
+	ProtectedTags *string `yaml:"protected-tags"`
+}
+
+// Validate checks push target settings for correctness
+func (t PushTarget) Validate() error {
+	if t.Registry == "" {
+		return errors.New("push target has no registry defined")
+	}
+
+	return nil
+}
+
+// Registry holds registry-specific settings
+type Registry struct {
+	Scheme         string        `yaml:"scheme"`
+	TLS            RegistryTLS   `yaml:"tls"`
+	Auth           RegistryAuth  `yaml:"auth"`
+	MaxConcurrency int           `yaml:"max-concurrency"`
+	RequestRate    float64       `yaml:"request-rate"`
+	Retry          RegistryRetry `yaml:"retry"`
+	Mirrors        []string      `yaml:"mirrors"`
+}
+
+// RegistryTLS holds registry TLS settings
+type RegistryTLS struct {
+	InsecureSkipVerify bool   `yaml:"insecure-skip-verify"`
+	CAFile             string `yaml:"ca-file"`
+	CertFile           string `yaml:"cert-file"`
+	KeyFile            string `yaml:"key-file"`
+}
+
+// RegistryAuth holds registry authentication settings (where to take registry credentials from)
+type RegistryAuth struct {
+	Username    string `yaml:"username"`
+	Password    string `yaml:"password"`
+	PasswordEnv string `yaml:"password-env"`
+	DockerJSON  string `yaml:"docker-json"`
+}
+
+// RegistryRetry holds registry request retry policy
+type RegistryRetry struct {
+	Requests int           `yaml:"requests"`
+	Delay    time.Duration `yaml:"delay"`
+}
+
+// GetPassword gets password either set explicitly or from environment variable
+func (a RegistryAuth) GetPassword() string {
+	if a.PasswordEnv != "" {
+		return os.Getenv(a.PasswordEnv)
+	}
+
+	return a.Password
+}
+
+// Validate checks registry settings for correctness
+func (r Registry) Validate() error {
+	if r.Scheme != "" && r.Scheme != "http" && r.Scheme != "https" {
+		return fmt.Errorf("scheme should be either 'http' or 'https', got: %s", r.Scheme)
+	}
+
+	if r.Auth.Password != "" && r.Auth.PasswordEnv != "" {
+		return errors.New("auth could have either 'password' or 'password-env', not both")
+	}
+
+	if r.Auth.Username != "" && r.Auth.DockerJSON != "" {
+		return errors.New("auth could have either 'username' or 'docker-json', not both")
+	}
+
+	if (r.TLS.CertFile == "") != (r.TLS.KeyFile == "") {
+		return errors.New("tls should have both 'cert-file' and 'key-file' or none of them")
+	}
+
+	if r.MaxConcurrency < 0 || r.RequestRate < 0 || r.Retry.Requests < 0 {
+		return errors.New("limits could not be negative")
+	}
+
+	return nil
 }
@@ -1,9 +1,13 @@
 package config
 
 import (
+	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	v1 "github.com/ivanilves/lstags/api/v1"
 )
 
 var expectedRepositories = []string{
@@ -71,3 +75,271 @@ func TestLoadYAMLFile_NonExisting(t *testing.T) {
 
 	assert.NotNil(err, "should give an error while trying to load non-existing config file")
 }
+
+func TestLoadYAMLFile_Options(t *testing.T) {
+	assert := assert.New(t)
+
+	yc, err := LoadYAMLFile("../fixtures/config/config.yaml.options")
+
+	assert.Nil(err, "should NOT give an error while loading valid config file with options")
+
+	if yc == nil {
+		t.Fatalf("should load config from valid config file with options")
+	}
+
+	assert.Equal([]string{"busybox", "quay.io/coreos/awscli=master,latest,edge"}, yc.Repositories)
+
+	assert.Equal("registry.company.io", *yc.PushRegistry)
+	assert.Equal("/mirror", *yc.PushPrefix)
+	assert.Equal("{{ .Tag }}-mirror", *yc.PushTagTemplate)
+	assert.Equal(true, *yc.PushUpdate)
+	assert.Equal(`^v?[0-9]+\.[0-9]+\.[0-9]+$`, *yc.PushProtectedTags)
+	assert.Equal(true, *yc.PushCleanup)
+	assert.Equal(true, *yc.Prune)
+	assert.Equal(3, *yc.PruneKeepNewest)
+	assert.Equal(4, *yc.ConcurrentRequests)
+	assert.Equal(2.5, *yc.RequestRate)
+	assert.Equal(5, *yc.RetryRequests)
+	assert.Equal(10*time.Second, *yc.RetryDelay)
+	assert.Equal(`^registry\.local$`, *yc.InsecureRegistryEx)
+	assert.Equal("/var/run/secrets/registry/.dockerconfigjson", *yc.KubeSecret)
+	assert.Equal("~/.netrc", *yc.Netrc)
+	assert.Equal(true, *yc.DaemonMode)
+	assert.Equal(5*time.Minute, *yc.PollingInterval)
+	assert.Equal("~/.lstags/oci", *yc.OCILayout)
+	assert.Equal("~/.lstags/cache", *yc.CacheDir)
+	assert.Equal(72*time.Hour, *yc.CacheTTL)
+	assert.Equal("json", *yc.OutputFormat)
+
+	assert.Nil(yc.PushPathTemplate, "should leave unset options nil")
+	assert.Nil(yc.Pull, "should leave unset options nil")
+}
+
+func TestLoadYAMLFile_NoOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	yc, err := LoadYAMLFile("../fixtures/config/config.yaml")
+
+	assert.Nil(err)
+
+	if yc != nil {
+		assert.Nil(yc.PushRegistry, "should leave unset options nil")
+		assert.Nil(yc.ConcurrentRequests, "should leave unset options nil")
+	}
+}
+
+func TestLoadYAMLFile_RepositorySettings(t *testing.T) {
+	assert := assert.New(t)
+
+	yc, err := LoadYAMLFile("../fixtures/config/config.yaml.settings")
+
+	assert.Nil(err, "should NOT give an error while loading valid config file with repository settings")
+
+	if yc == nil {
+		t.Fatalf("should load config from valid config file with repository settings")
+	}
+
+	assert.Equal(
+		[]string{"busybox", "quay.io/coreos/flannel~/^v0\\.10/", "nginx=stable,latest"},
+		yc.Repositories,
+	)
+
+	assert.True(yc.HasPushRegistry())
+
+	busybox := yc.Repository("busybox")
+	assert.NotNil(busybox)
+	assert.Nil(busybox.PushPrefix, "should leave unset settings nil")
+
+	flannel := yc.Repository("quay.io/coreos/flannel~/^v0\\.10/")
+	assert.NotNil(flannel)
+	assert.Equal("/quay", *flannel.PushPrefix)
+	assert.Equal(true, *flannel.PushUpdate)
+	assert.Nil(flannel.PushRegistry)
+
+	nginx := yc.Repository("nginx=stable,latest")
+	assert.NotNil(nginx)
+	assert.Equal("registry.hub.company.io", *nginx.PushRegistry)
+	assert.Equal("{{ .Tag }}-hub", *nginx.PushTagTemplate)
+	assert.Equal("~/.docker/hub-mirror.json", *nginx.PushDockerJSON)
+
+	assert.Nil(yc.Repository("alpine"))
+}
+
+func TestLoadYAMLFile_RepositorySettingsInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	yc, err := LoadYAMLFile("../fixtures/config/config.yaml.settings.invalid")
+
+	assert.Nil(yc, "should NOT load config with conflicting repository settings")
+
+	assert.NotNil(err, "should give an error while loading config with conflicting repository settings")
+}
+
+func TestLoadYAMLFile_PushTargets(t *testing.T) {
+	assert := assert.New(t)
+
+	yc, err := LoadYAMLFile("../fixtures/config/config.yaml.targets")
+
+	assert.Nil(err, "should NOT give an error while loading valid config file with push targets")
+
+	if yc == nil {
+		t.Fatalf("should load config from valid config file with push targets")
+	}
+
+	assert.True(yc.HasPushRegistry())
+
+	if assert.Len(yc.PushTargets, 3) {
+		eu, us, ap := yc.PushTargets[0], yc.PushTargets[1], yc.PushTargets[2]
+
+		assert.Equal("registry.eu.company.io", eu.Registry)
+		assert.Nil(eu.Prefix, "should leave unset settings nil")
+		assert.Nil(eu.Update, "should leave unset settings nil")
+		assert.Nil(eu.ProtectedTags, "should leave unset settings nil")
+
+		assert.Equal("registry.us.company.io", us.Registry)
+		assert.Equal("/us", *us.Prefix)
+		assert.Equal("{{ .Tag }}-us", *us.TagTemplate)
+		assert.Equal("~/.docker/us.json", *us.DockerJSON)
+
+		assert.Equal("registry.ap.company.io", ap.Registry)
+		assert.Equal(false, *ap.Update)
+		assert.Equal("^v[0-9]+", *ap.ProtectedTags)
+	}
+}
+
+func TestLoadYAMLFile_PushTargetsInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	yc, err := LoadYAMLFile("../fixtures/config/config.yaml.targets.invalid")
+
+	assert.Nil(yc, "should NOT load config with repository push registry and push targets")
+
+	assert.NotNil(err, "should give an error while loading config with repository push registry and push targets")
+}
+
+func TestPushConfigs(t *testing.T) {
+	assert := assert.New(t)
+
+	yc, err := LoadYAMLFile("../fixtures/config/config.yaml.targets")
+	if err != nil {
+		t.Fatalf("should load config from valid config file with push targets: %s", err.Error())
+	}
+
+	push := v1.PushConfig{
+		Prefix:        "/mirror",
+		PathSeparator: "/",
+		PathTemplate:  "{{ .Prefix }}{{ .Path }}",
+		TagTemplate:   "{{ .Tag }}",
+		UpdateChanged: true,
+	}
+
+	pushConfigs := yc.PushConfigs(push)
+
+	if assert.Len(pushConfigs, 3) {
+		eu, us, ap := pushConfigs[0], pushConfigs[1], pushConfigs[2]
+
+		assert.Equal("registry.eu.company.io", eu.Registry)
+		assert.Equal("/mirror", eu.Prefix, "should take unset target settings from global options")
+		assert.True(eu.UpdateChanged, "should take unset target settings from global options")
+
+		assert.Equal("/us", us.Prefix)
+		assert.Equal("{{ .Tag }}-us", us.TagTemplate)
+		assert.Equal("~/.docker/us.json", us.DockerJSONConfigFile)
+
+		assert.False(ap.UpdateChanged)
+		assert.Equal("^v[0-9]+", ap.ProtectedTags)
+
+		for _, pushConfig := range pushConfigs {
+			override := pushConfig.ForRef("quay.io/coreos/flannel~/^v0\\.10/")
+
+			assert.Equal(pushConfig.Registry, override.Registry)
+			assert.Equal("/quay", override.Prefix, "repository settings should override target ones")
+			assert.Equal(pushConfig.TagTemplate, override.TagTemplate)
+
+			assert.Equal(pushConfig, pushConfig.ForRef("busybox"), "should not override repositories with no settings")
+		}
+	}
+
+	var nc *Config
+
+	assert.Equal([]v1.PushConfig{push}, nc.PushConfigs(push))
+}
+
+func TestPushTargetValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(PushTarget{Registry: "registry.company.io"}.Validate())
+	assert.NotNil(PushTarget{}.Validate())
+}
+
+func TestRepositoryFullRef(t *testing.T) {
+	var testCases = []struct {
+		repository Repository
+		fullRef    string
+		isCorrect  bool
+	}{
+		{Repository{Ref: "alpine"}, "alpine", true},
+		{Repository{Ref: "alpine:3.7"}, "alpine:3.7", true},
+		{Repository{Ref: "alpine", Filter: "^3"}, "alpine~/^3/", true},
+		{Repository{Ref: "alpine", Tags: []string{"3.6", "3.7"}}, "alpine=3.6,3.7", true},
+		{Repository{Ref: "alpine", Tags: []string{"3.7"}, Filter: "^3"}, "", false},
+		{Repository{Ref: "alpine~/^3/", Filter: "^3"}, "", false},
+		{Repository{Filter: "^3"}, "", false},
+	}
+
+	assert := assert.New(t)
+
+	for _, tc := range testCases {
+		fullRef, err := tc.repository.FullRef()
+
+		if tc.isCorrect {
+			assert.Nil(err, "%+v", tc.repository)
+		} else {
+			assert.NotNil(err, "%+v", tc.repository)
+		}
+
+		assert.Equal(tc.fullRef, fullRef)
+	}
+}
+
+func TestLoadYAMLFile_Registries(t *testing.T) {
+	assert := assert.New(t)
+
+	os.Setenv("LSTAGS_TEST_COMPANY_PASSWORD", "s3cr3t")
+	defer os.Unsetenv("LSTAGS_TEST_COMPANY_PASSWORD")
+
+	yc, err := LoadYAMLFile("../fixtures/config/config.yaml.registries")
+
+	assert.Nil(err, "should NOT give an error while loading valid config file with registries")
+
+	if yc == nil {
+		t.Fatalf("should load config from valid config file with registries")
+	}
+
+	assert.Equal(2, len(yc.Registries))
+
+	company := yc.Registries["registry.company.io"]
+	assert.Equal("", company.Scheme)
+	assert.Equal("/etc/ssl/company-ca.pem", company.TLS.CAFile)
+	assert.Equal("robot", company.Auth.Username)
+	assert.Equal("s3cr3t", company.Auth.GetPassword())
+	assert.Equal(4, company.MaxConcurrency)
+	assert.Equal(10.0, company.RequestRate)
+	assert.Equal(3, company.Retry.Requests)
+	assert.Equal(5*time.Second, company.Retry.Delay)
+	assert.Equal([]string{"mirror.company.io"}, company.Mirrors)
+
+	local := yc.Registries["registry.local:5000"]
+	assert.Equal("http", local.Scheme)
+	assert.Equal("~/.docker/local.json", local.Auth.DockerJSON)
+}
+
+func TestLoadYAMLFile_RegistriesInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	yc, err := LoadYAMLFile("../fixtures/config/config.yaml.registries.invalid")
+
+	assert.Nil(yc, "should NOT load config with invalid registry settings")
+
+	assert.NotNil(err, "should give an error while loading config with invalid registry settings")
+}
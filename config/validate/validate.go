@@ -0,0 +1,349 @@
+// Package validate provides validation ("linting") of lstags YAML configuration.
+// Unlike loading config for a real run, it does not stop on the first error,
+// but rather collects all the problems found, with their file/line positions.
+package validate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	v1 "github.com/ivanilves/lstags/api/v1"
+	"github.com/ivanilves/lstags/config"
+	dockerconfig "github.com/ivanilves/lstags/docker/config"
+	"github.com/ivanilves/lstags/repository"
+	"github.com/ivanilves/lstags/util/fix"
+)
+
+const (
+	// Error is a severity of problem that would break the run
+	Error = "ERROR"
+	// Warning is a severity of problem that might break the run
+	Warning = "WARNING"
+)
+
+// Problem is a single problem found in configuration
+type Problem struct {
+	File     string
+	Line     int
+	Severity string
+	Message  string
+}
+
+// String gives us string form of the problem, e.g. "lstags.yaml:12: ERROR: something is wrong"
+func (p Problem) String() string {
+	if p.Line == 0 {
+		return fmt.Sprintf("%s: %s: %s", p.File, p.Severity, p.Message)
+	}
+
+	return fmt.Sprintf("%s:%d: %s: %s", p.File, p.Line, p.Severity, p.Message)
+}
+
+// HasErrors tells us if there are problems with "ERROR" severity among the ones passed
+func HasErrors(problems []Problem) bool {
+	for _, p := range problems {
+		if p.Severity == Error {
+			return true
+		}
+	}
+
+	return false
+}
+
+var lineEx = regexp.MustCompile(`line ([0-9]+): (.*)`)
+
+type validator struct {
+	file     string
+	lines    []string
+	problems []Problem
+}
+
+// lineOf finds the (first) line number where one of the passed strings occurs (0 if none found)
+func (v *validator) lineOf(needles ...string) int {
+	for _, needle := range needles {
+		if needle == "" {
+			continue
+		}
+
+		for i, line := range v.lines {
+			if strings.Contains(line, needle) {
+				return i + 1
+			}
+		}
+	}
+
+	return 0
+}
+
+func (v *validator) add(severity string, line int, format string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{
+		File:     v.file,
+		Line:     line,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// addYAMLError converts YAML parser error(s) into problem(s), extracting line numbers if present
+func (v *validator) addYAMLError(err error) {
+	messages := []string{err.Error()}
+
+	if te, ok := err.(*yaml.TypeError); ok {
+		messages = te.Errors
+	}
+
+	for _, msg := range messages {
+		m := lineEx.FindStringSubmatch(msg)
+		if m == nil {
+			v.add(Error, 0, "%s", msg)
+			continue
+		}
+
+		line, _ := strconv.Atoi(m[1])
+
+		v.add(Error, line, "%s", m[2])
+	}
+}
+
+// PushOptions makes global push configuration (i.e. the one made of push options) a run with the config passed would use
+type PushOptions func(c *config.Config) v1.PushConfig
+
+// YAMLFile validates YAML config file passed, using Docker JSON config file passed to check credentials
+// and push options passed to make push configurations the same way a run does it (see config.PushConfigs)
+func YAMLFile(path, dockerJSON string, pushOptions PushOptions) []Problem {
+	v := &validator{file: path}
+
+	data, err := ioutil.ReadFile(fix.Path(path))
+	if err != nil {
+		v.add(Error, 0, "%s", err.Error())
+
+		return v.problems
+	}
+
+	v.lines = strings.Split(string(data), "\n")
+
+	structure := struct {
+		ConfigRoot *config.Config         `yaml:"lstags"`
+		Others     map[string]interface{} `yaml:",inline"`
+	}{}
+
+	if err := yaml.UnmarshalStrict(data, &structure); err != nil {
+		v.addYAMLError(err)
+
+		// strict mode fails on unknown keys, but we still could validate everything else
+		structure.ConfigRoot = nil
+		if err := yaml.Unmarshal(data, &structure); err != nil {
+			return v.problems
+		}
+	}
+
+	c := structure.ConfigRoot
+	if c == nil {
+		v.add(Error, 0, "no 'lstags' root key found")
+
+		return v.problems
+	}
+
+	dockerConfig, err := dockerconfig.Load(dockerJSON)
+	if err != nil {
+		v.add(Warning, 0, "unable to load Docker JSON config '%s', credentials will not be checked: %s", dockerJSON, err.Error())
+	} else {
+		dockerConfig.WithProviders([]dockerconfig.Provider{dockerconfig.EnvProvider{}}, nil)
+	}
+
+	v.checkOptions(c)
+	v.checkRegistries(c, dockerConfig)
+	v.checkPushTargets(c)
+	v.checkRepositories(c, pushOptions(c), dockerConfig)
+
+	return v.problems
+}
+
+func (v *validator) checkOptions(c *config.Config) {
+	if c.InsecureRegistryEx != nil {
+		if _, err := regexp.Compile(*c.InsecureRegistryEx); err != nil {
+			v.add(Error, v.lineOf("insecure-registry-ex:"), "invalid insecure registry expression: %s", err.Error())
+		}
+	}
+
+	if c.OutputFormat != nil && *c.OutputFormat != "table" && *c.OutputFormat != "json" {
+		v.add(Error, v.lineOf("output-format:"), "unknown output format: %s", *c.OutputFormat)
+	}
+
+	if c.ConcurrentRequests != nil && *c.ConcurrentRequests < 0 {
+		v.add(Error, v.lineOf("concurrent-requests:"), "concurrent requests could not be negative")
+	}
+
+	if c.PruneKeepNewest != nil && *c.PruneKeepNewest < 0 {
+		v.add(Error, v.lineOf("prune-keep-newest:"), "number of images to keep could not be negative")
+	}
+
+	if c.RetryRequests != nil && *c.RetryRequests < 0 {
+		v.add(Error, v.lineOf("retry-requests:"), "retry requests could not be negative")
+	}
+
+	if c.CacheTTL != nil && *c.CacheTTL < 0 {
+		v.add(Error, v.lineOf("cache-ttl:"), "cache TTL could not be negative")
+	}
+}
+
+func (v *validator) checkRegistries(c *config.Config, dockerConfig *dockerconfig.Config) {
+	for hostname, r := range c.Registries {
+		line := v.lineOf(hostname + ":")
+
+		if err := r.Validate(); err != nil {
+			v.add(Error, line, "invalid settings for registry '%s': %s", hostname, err.Error())
+		}
+
+		if r.Auth.PasswordEnv != "" {
+			if _, defined := os.LookupEnv(r.Auth.PasswordEnv); !defined {
+				v.add(Error, v.lineOf(r.Auth.PasswordEnv), "password environment variable for registry '%s' is not set: %s", hostname, r.Auth.PasswordEnv)
+			}
+		}
+
+		if r.Auth.Username != "" && r.Auth.Password == "" && r.Auth.PasswordEnv == "" {
+			v.add(Error, line, "registry '%s' has username, but no password", hostname)
+		}
+
+		if r.Auth.DockerJSON != "" {
+			c, err := dockerconfig.Load(r.Auth.DockerJSON)
+			if err != nil {
+				v.add(Error, v.lineOf(r.Auth.DockerJSON), "unable to load Docker JSON config for registry '%s': %s", hostname, err.Error())
+			} else if _, _, defined := c.GetCredentials(hostname); !defined {
+				v.add(Error, v.lineOf(r.Auth.DockerJSON), "no credentials for registry '%s' in: %s", hostname, r.Auth.DockerJSON)
+			}
+		}
+
+		for _, mirror := range r.Mirrors {
+			if repository.GetRegistry(mirror+"/mirror/check") != mirror {
+				v.add(Error, v.lineOf(mirror), "invalid mirror for registry '%s': %s", hostname, mirror)
+			}
+		}
+	}
+}
+
+func (v *validator) checkPushTargets(c *config.Config) {
+	for i, t := range c.PushTargets {
+		if err := t.Validate(); err != nil {
+			v.add(Error, v.lineOf("push-targets:"), "invalid push target #%d: %s", i+1, err.Error())
+		}
+	}
+}
+
+// hasCredentials tells us if we could find credentials for the registry passed
+func (v *validator) hasCredentials(c *config.Config, registry string, dockerConfig *dockerconfig.Config) bool {
+	if r, defined := c.Registries[registry]; defined {
+		if r.Auth.Username != "" || r.Auth.DockerJSON != "" {
+			return true
+		}
+	}
+
+	if dockerConfig == nil {
+		return true // we could not check it anyway
+	}
+
+	_, _, defined := dockerConfig.GetCredentials(registry)
+
+	return defined
+}
+
+func (v *validator) checkRepositories(c *config.Config, push v1.PushConfig, dockerConfig *dockerconfig.Config) {
+	if len(c.RepositoryEntries) == 0 {
+		v.add(Error, v.lineOf("repositories:"), "no repositories defined")
+	}
+
+	pushConfigs := c.PushConfigs(push)
+
+	checkedTemplates := make(map[string]bool)
+	checkedRegistries := make(map[string]bool)
+
+	for _, r := range c.RepositoryEntries {
+		line := v.lineOf("ref: "+r.Ref, "- "+r.Ref, r.Ref)
+
+		ref, err := r.FullRef()
+		if err != nil {
+			v.add(Error, line, "%s", err.Error())
+			continue
+		}
+
+		repo, err := repository.ParseRef(ref)
+		if err != nil {
+			v.add(Error, line, "%s", err.Error())
+			continue
+		}
+
+		if len(c.PushTargets) != 0 && r.PushRegistry != nil {
+			v.add(Error, line, "repository could not have its own 'push-registry' with 'push-targets' defined: %s", r.Ref)
+		}
+
+		for _, push := range pushConfigs {
+			v.checkPush(c, repo, push.ForRef(ref), dockerConfig, checkedTemplates, checkedRegistries)
+		}
+	}
+}
+
+// checkPush checks push configuration for the repository passed (templates and credentials already checked are skipped)
+func (v *validator) checkPush(
+	c *config.Config,
+	repo *repository.Repository,
+	push v1.PushConfig,
+	dockerConfig *dockerconfig.Config,
+	checkedTemplates, checkedRegistries map[string]bool,
+) {
+	if push.Registry == "" {
+		return
+	}
+
+	for _, check := range []struct {
+		push   v1.PushConfig
+		needle string
+	}{
+		{v1.PushConfig{Prefix: push.Prefix, PathSeparator: push.PathSeparator, PathTemplate: push.PathTemplate, TagTemplate: "{{ .Tag }}"}, push.PathTemplate},
+		{v1.PushConfig{Prefix: "/", PathSeparator: "/", PathTemplate: "{{ .Prefix }}{{ .Path }}", TagTemplate: push.TagTemplate}, push.TagTemplate},
+	} {
+		// templates are checked against repository filter captures, so filter is a part of the key too
+		key := strings.Join([]string{check.push.Prefix, check.push.PathSeparator, check.push.PathTemplate, check.push.TagTemplate, repo.Filter()}, "|")
+		if checkedTemplates[key] {
+			continue
+		}
+		checkedTemplates[key] = true
+
+		if err := check.push.ValidateFor(repo); err != nil {
+			v.add(Error, v.lineOf(check.needle), "invalid push configuration for '%s': %s", repo.Ref(), err.Error())
+		}
+	}
+
+	if push.ProtectedTags != "" && !checkedTemplates["protected|"+push.ProtectedTags] {
+		checkedTemplates["protected|"+push.ProtectedTags] = true
+
+		if _, err := regexp.Compile(push.ProtectedTags); err != nil {
+			v.add(Error, v.lineOf(push.ProtectedTags), "invalid protected tags expression: %s", err.Error())
+		}
+	}
+
+	pushDockerConfig := dockerConfig
+	if push.DockerJSONConfigFile != "" {
+		var err error
+
+		pushDockerConfig, err = dockerconfig.Load(push.DockerJSONConfigFile)
+		if err != nil {
+			v.add(Error, v.lineOf(push.DockerJSONConfigFile), "unable to load push Docker JSON config for '%s': %s", repo.Ref(), err.Error())
+			return
+		}
+	}
+
+	key := push.Registry + "|" + push.DockerJSONConfigFile
+	if checkedRegistries[key] {
+		return
+	}
+	checkedRegistries[key] = true
+
+	if !v.hasCredentials(c, push.Registry, pushDockerConfig) {
+		v.add(Warning, v.lineOf(push.Registry), "no credentials found for push registry: %s", push.Registry)
+	}
+}
@@ -0,0 +1,177 @@
+package validate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	v1 "github.com/ivanilves/lstags/api/v1"
+	"github.com/ivanilves/lstags/config"
+)
+
+const dockerJSON = "../../fixtures/docker/config.json"
+
+// pushOptions makes push configuration like a run with default CLI options and YAML ones applied does
+func pushOptions(c *config.Config) v1.PushConfig {
+	push := v1.PushConfig{
+		PathSeparator: "/",
+		PathTemplate:  "{{ .Prefix }}{{ .Path }}",
+		TagTemplate:   "{{ .Tag }}",
+	}
+
+	for _, s := range []struct {
+		value  *string
+		target *string
+	}{
+		{c.PushRegistry, &push.Registry},
+		{c.PushPrefix, &push.Prefix},
+		{c.PathSeparator, &push.PathSeparator},
+		{c.PushPathTemplate, &push.PathTemplate},
+		{c.PushTagTemplate, &push.TagTemplate},
+		{c.PushProtectedTags, &push.ProtectedTags},
+	} {
+		if s.value != nil {
+			*s.target = *s.value
+		}
+	}
+
+	return push
+}
+
+func TestYAMLFile_Valid(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, file := range []string{
+		"../../fixtures/config/config.yaml",
+		"../../fixtures/config/config.yaml.shared",
+		"../../fixtures/config/config.yaml.options",
+	} {
+		problems := YAMLFile(file, dockerJSON, pushOptions)
+
+		assert.Empty(problems, file)
+		assert.False(HasErrors(problems), file)
+	}
+}
+
+func TestYAMLFile_Invalid(t *testing.T) {
+	assert := assert.New(t)
+
+	const file = "../../fixtures/config/config.yaml.lint"
+
+	problems := YAMLFile(file, dockerJSON, pushOptions)
+
+	assert.True(HasErrors(problems))
+
+	expectedLines := map[int]bool{
+		5:  false, // push tag template with unknown function
+		6:  false, // invalid insecure registry expression
+		7:  false, // unknown option
+		8:  false, // negative number of images to keep
+		11: false, // invalid filter regex
+		12: false, // invalid reference
+		13: false, // both filter in reference and separate filter
+		16: false, // invalid scheme
+		20: false, // unset password environment variable
+	}
+
+	for _, p := range problems {
+		assert.Equal(file, p.File)
+
+		if _, defined := expectedLines[p.Line]; defined {
+			expectedLines[p.Line] = true
+		}
+	}
+
+	for line, found := range expectedLines {
+		assert.True(found, "expected a problem reported at line %d, got: %+v", line, problems)
+	}
+}
+
+func TestYAMLFile_PushTargets(t *testing.T) {
+	assert := assert.New(t)
+
+	const file = "../../fixtures/config/config.yaml.targets.invalid"
+
+	problems := YAMLFile(file, dockerJSON, pushOptions)
+
+	assert.True(HasErrors(problems))
+
+	expectedLines := map[int]bool{
+		2:  false, // push target with no registry
+		4:  false, // push target protected tags with invalid expression
+		6:  false, // push target tag template with unknown function
+		10: false, // repository push registry with push targets defined
+	}
+
+	for _, p := range problems {
+		if _, defined := expectedLines[p.Line]; defined {
+			expectedLines[p.Line] = true
+		}
+	}
+
+	for line, found := range expectedLines {
+		assert.True(found, "expected a problem reported at line %d, got: %+v", line, problems)
+	}
+}
+
+func TestYAMLFile_FilterCaptures(t *testing.T) {
+	assert := assert.New(t)
+
+	const file = "../../fixtures/config/config.yaml.captures"
+
+	problems := YAMLFile(file, dockerJSON, pushOptions)
+
+	assert.True(HasErrors(problems))
+
+	problemLines := make(map[int]bool)
+	for _, p := range problems {
+		problemLines[p.Line] = true
+	}
+
+	assert.False(problemLines[5], "should accept captures repository filter has, got: %+v", problems)
+	assert.True(problemLines[7], "should reject capture repository filter does not have, got: %+v", problems)
+	assert.True(problemLines[9], "should reject capture for repository with no filter, got: %+v", problems)
+}
+
+func TestYAMLFile_PushOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	const file = "../../fixtures/config/config.yaml"
+
+	// e.g. set by CLI options
+	withOptions := func(tagTemplate string) PushOptions {
+		return func(c *config.Config) v1.PushConfig {
+			push := pushOptions(c)
+			push.Registry = "registry.company.io"
+			push.TagTemplate = tagTemplate
+
+			return push
+		}
+	}
+
+	assert.False(HasErrors(YAMLFile(file, dockerJSON, withOptions("{{ .Tag }}"))))
+
+	problems := YAMLFile(file, dockerJSON, withOptions("{{ .Tag | nosuchfunc }}"))
+
+	assert.True(HasErrors(problems), "should validate push configurations made of push options passed")
+}
+
+func TestYAMLFile_NonExisting(t *testing.T) {
+	problems := YAMLFile("/i/do/not/exist/sorry", dockerJSON, pushOptions)
+
+	assert.Equal(t, 1, len(problems))
+	assert.True(t, HasErrors(problems))
+}
+
+func TestYAMLFile_Irrelevant(t *testing.T) {
+	problems := YAMLFile("../../fixtures/config/config.yaml.irrelevant", dockerJSON, pushOptions)
+
+	assert.True(t, HasErrors(problems))
+}
+
+func TestProblemString(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("a.yaml:3: ERROR: bad", Problem{File: "a.yaml", Line: 3, Severity: Error, Message: "bad"}.String())
+	assert.Equal("a.yaml: WARNING: meh", Problem{File: "a.yaml", Severity: Warning, Message: "meh"}.String())
+}
@@ -49,6 +49,11 @@ func (dc *DockerClient) Config() *config.Config {
 	return dc.cnf
 }
 
+// WithConfig creates a copy of DockerClient using Docker client configuration passed
+func (dc *DockerClient) WithConfig(cnf *config.Config) *DockerClient {
+	return &DockerClient{cli: dc.cli, cnf: cnf}
+}
+
 // ListImagesForRepo lists images present locally for the repo specified
 func (dc *DockerClient) ListImagesForRepo(repo string) ([]types.ImageSummary, error) {
 	listOptions, err := buildImageListOptions(repo)
@@ -157,3 +162,14 @@ func (dc *DockerClient) ForceRemove(id string) error {
 		types.ContainerRemoveOptions{Force: true},
 	)
 }
+
+// RemoveImage removes (untags) local Docker image having the reference specified (like "docker rmi")
+func (dc *DockerClient) RemoveImage(ref string) error {
+	_, err := dc.cli.ImageRemove(
+		context.Background(),
+		ref,
+		types.ImageRemoveOptions{PruneChildren: true},
+	)
+
+	return err
+}
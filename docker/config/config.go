@@ -5,30 +5,56 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
+	"sync"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/ivanilves/lstags/docker/config/credhelper"
 	"github.com/moby/moby/api/types"
 
 	"github.com/ivanilves/lstags/util/fix"
+	"github.com/ivanilves/lstags/util/redact"
 )
 
 // DefaultDockerJSON is the defalt path for Docker JSON config file
 var DefaultDockerJSON = "~/.docker/config.json"
 
+// HubServerAddress is the key Docker CLI stores Docker Hub credentials under
+const HubServerAddress = "https://index.docker.io/v1/"
+
+// hubRegistry is the canonical (normalized) Docker Hub registry name
+const hubRegistry = "registry.hub.docker.com"
+
+// hubAliases are the registry names Docker Hub is known under
+var hubAliases = map[string]bool{
+	"index.docker.io":         true,
+	"registry-1.docker.io":    true,
+	"docker.io":               true,
+	"registry.hub.docker.com": true,
+}
+
 // Config encapsulates configuration loaded from Docker 'config.json' file
 type Config struct {
 	Auths       map[string]Auth `json:"auths"`
 	usernames   map[string]string
 	passwords   map[string]string
+	serverKeys  map[string]string
 	CredsStore  string            `json:"credsStore,omitempty"`
 	CredHelpers map[string]string `json:"credHelpers,omitempty"`
+
+	helpers map[string]*credhelper.Helper
+	before  []Provider
+	after   []Provider
+	mux     sync.Mutex
 }
 
 // Auth contains Docker registry username and password in base64-encoded form
+// (or in plain form, as Kubernetes "kubernetes.io/dockerconfigjson" secrets could have them)
 type Auth struct {
-	B64Auth string `json:"auth"`
+	B64Auth  string `json:"auth"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
 }
 
 // IsEmpty return true if structure has no relevant data inside
@@ -36,23 +62,170 @@ func (c *Config) IsEmpty() bool {
 	return len(c.Auths) == 0
 }
 
-// GetCredentials gets per-registry credentials from loaded Docker config
-func (c *Config) GetCredentials(registry string) (string, string, bool) {
-	if _, defined := c.usernames[registry]; !defined {
-		username, password, err := credhelper.GetCredentials(
-			registry,
-			c.CredsStore,
-			c.CredHelpers,
-		)
+// NormalizeRegistry brings registry name (or Docker config key) to the canonical form,
+// the same way Docker CLI does: strips URL scheme and path, lowercases the name and
+// maps all Docker Hub aliases (incl. "https://index.docker.io/v1/") to a single name
+func NormalizeRegistry(registry string) string {
+	r := strings.TrimSpace(registry)
 
-		if err != nil {
-			return "", "", false
+	for _, scheme := range []string{"http://", "https://"} {
+		if strings.HasPrefix(strings.ToLower(r), scheme) {
+			r = r[len(scheme):]
+			break
+		}
+	}
+
+	if i := strings.Index(r, "/"); i != -1 {
+		r = r[:i]
+	}
+
+	r = strings.ToLower(r)
+
+	if hubAliases[r] {
+		return hubRegistry
+	}
+
+	return r
+}
+
+// serverKey gets the key to query credential helpers with for the (normalized) registry passed
+func (c *Config) serverKey(registry string) string {
+	if key, defined := c.serverKeys[registry]; defined {
+		return key
+	}
+
+	if registry == hubRegistry {
+		return HubServerAddress
+	}
+
+	return registry
+}
+
+// credHelpers gets "credHelpers" map with keys normalized
+func (c *Config) credHelpers() map[string]string {
+	credHelpers := make(map[string]string, len(c.CredHelpers))
+
+	for _, key := range sortedKeys(c.CredHelpers) {
+		registry := NormalizeRegistry(key)
+		if _, defined := credHelpers[registry]; !defined {
+			credHelpers[registry] = c.CredHelpers[key]
 		}
+	}
+
+	return credHelpers
+}
+
+// helperName gets name of the credential helper configured for the (normalized) registry passed, if any
+// NB! Per-registry "credHelpers" take precedence over the global "credsStore", like in Docker CLI
+func (c *Config) helperName(registry string) string {
+	if name, defined := c.credHelpers()[registry]; defined {
+		return name
+	}
+
+	return c.CredsStore
+}
+
+// helper gets credential helper by name passed (helpers are created only once, to cache their results)
+func (c *Config) helper(name string) *credhelper.Helper {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.helpers == nil {
+		c.helpers = make(map[string]*credhelper.Helper)
+	}
+
+	h, defined := c.helpers[name]
+	if !defined {
+		h = credhelper.New(name)
+		c.helpers[name] = h
+	}
+
+	return h
+}
+
+// GetCredentials gets per-registry credentials consulting (in this order):
+// * providers set to be consulted before config (see WithProviders)
+// * credentials stored in loaded Docker config
+// * credential helpers configured in loaded Docker config
+// * providers set to be consulted after config (see WithProviders)
+func (c *Config) GetCredentials(registry string) (string, string, bool) {
+	registry = NormalizeRegistry(registry)
+
+	c.mux.Lock()
+	before, after := c.before, c.after
+	c.mux.Unlock()
+
+	if username, password, defined := getProvidedCredentials(before, registry); defined {
+		return username, password, true
+	}
 
+	if username, password, defined := c.getOwnCredentials(registry); defined {
 		return username, password, true
 	}
 
-	return c.usernames[registry], c.passwords[registry], true
+	return getProvidedCredentials(after, registry)
+}
+
+// getOwnCredentials gets credentials from loaded Docker config itself (incl. credential helpers)
+func (c *Config) getOwnCredentials(registry string) (string, string, bool) {
+	if _, defined := c.usernames[registry]; defined {
+		return c.usernames[registry], c.passwords[registry], true
+	}
+
+	name := c.helperName(registry)
+	if name == "" {
+		return "", "", false
+	}
+
+	username, password, err := c.helper(name).Get(c.serverKey(registry))
+	if err != nil {
+		if err != credhelper.ErrCredentialsNotFound {
+			log.Warnf("[credhelper][%s] unable to get credentials for '%s': %s", name, registry, err.Error())
+		}
+
+		return "", "", false
+	}
+
+	return username, password, true
+}
+
+// StoreCredentials stores credentials for the registry passed with the configured credential helper
+func (c *Config) StoreCredentials(registry, username, password string) error {
+	registry = NormalizeRegistry(registry)
+
+	name := c.helperName(registry)
+	if name == "" {
+		return fmt.Errorf("no credential helper configured to store credentials for registry: %s", registry)
+	}
+
+	return c.helper(name).Store(c.serverKey(registry), username, password)
+}
+
+// EraseCredentials erases credentials for the registry passed from the configured credential helper
+func (c *Config) EraseCredentials(registry string) error {
+	registry = NormalizeRegistry(registry)
+
+	name := c.helperName(registry)
+	if name == "" {
+		return fmt.Errorf("no credential helper configured to erase credentials for registry: %s", registry)
+	}
+
+	err := c.helper(name).Erase(c.serverKey(registry))
+	if err == credhelper.ErrCredentialsNotFound {
+		return nil
+	}
+
+	return err
+}
+
+// ResetCredentialsCache makes credential helpers forget all results cached, e.g. before the next run
+func (c *Config) ResetCredentialsCache() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	for _, h := range c.helpers {
+		h.ResetCache()
+	}
 }
 
 func getAuthJSONString(username, password string) string {
@@ -78,7 +251,7 @@ func (c *Config) GetRegistryAuth(registry string) string {
 		return ""
 	}
 	authJSONString := getAuthJSONString(username, password)
-	log.Debugf("JSON Auth String: %s", authJSONString)
+	log.Debugf("JSON Auth String: %s", redact.JSON(authJSONString))
 
 	return base64.StdEncoding.EncodeToString(
 		[]byte(authJSONString),
@@ -103,7 +276,25 @@ func Load(fileName string) (*Config, error) {
 
 	c.usernames = make(map[string]string)
 	c.passwords = make(map[string]string)
-	for registry, a := range c.Auths {
+	c.serverKeys = make(map[string]string)
+	for _, key := range sortedKeys(c.Auths) {
+		a := c.Auths[key]
+		registry := NormalizeRegistry(key)
+
+		// the first key (in sorted order) having credentials wins, if multiple keys point to the same registry
+		if _, defined := c.usernames[registry]; defined {
+			continue
+		}
+		if _, defined := c.serverKeys[registry]; !defined {
+			c.serverKeys[registry] = key
+		}
+
+		if a.B64Auth == "" && a.Username != "" {
+			c.usernames[registry] = a.Username
+			c.passwords[registry] = a.Password
+			continue
+		}
+
 		b, err := base64.StdEncoding.DecodeString(a.B64Auth)
 		if err != nil {
 			return nil, err
@@ -127,9 +318,9 @@ func Load(fileName string) (*Config, error) {
 			errStr := "Invalid auth for Docker registry: %s\nBase64-encoded string is wrong: %s (%s)\n"
 			return nil, fmt.Errorf(
 				errStr,
-				registry,
-				a.B64Auth,
-				authenticationToken,
+				key,
+				redact.Secret(a.B64Auth),
+				redact.Secret(authenticationToken),
 			)
 		}
 	}
@@ -137,6 +328,25 @@ func Load(fileName string) (*Config, error) {
 	return c, nil
 }
 
+func sortedKeys(m interface{}) []string {
+	keys := make([]string, 0)
+
+	switch m := m.(type) {
+	case map[string]Auth:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]string:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
 func parseConfig(f *os.File) (*Config, error) {
 	c := &Config{}
 
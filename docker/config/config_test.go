@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"io/ioutil"
+	"strings"
 	"testing"
 )
 
@@ -127,6 +128,11 @@ func TestLoadWithBadAuthConfigFile(t *testing.T) {
 	if err == nil {
 		t.Fatalf("Expected to fail while loading config file with incorrect auth")
 	}
+	for _, secret := range []string{"bWFjb3N1YXU=", "macosuau"} {
+		if strings.Contains(err.Error(), secret) {
+			t.Fatalf("Should NOT reveal auth in error message: %s", err.Error())
+		}
+	}
 
 	DefaultDockerJSON = badAuthConfigFile
 
@@ -162,3 +168,124 @@ func TestLoadWithCorruptConfigFile(t *testing.T) {
 		)
 	}
 }
+
+func TestNormalizeRegistry(t *testing.T) {
+	examples := map[string]string{
+		"registry.company.io":             "registry.company.io",
+		"https://registry.company.io/v2/": "registry.company.io",
+		"http://localhost:5000":           "localhost:5000",
+		"localhost:5000/v1/":              "localhost:5000",
+		"Quay.IO":                         "quay.io",
+		"https://index.docker.io/v1/":     "registry.hub.docker.com",
+		"index.docker.io":                 "registry.hub.docker.com",
+		"registry-1.docker.io":            "registry.hub.docker.com",
+		"docker.io":                       "registry.hub.docker.com",
+		"registry.hub.docker.com":         "registry.hub.docker.com",
+	}
+
+	for registry, expected := range examples {
+		normalized := NormalizeRegistry(registry)
+
+		if normalized != expected {
+			t.Fatalf(
+				"Unexpected normalized registry for '%s': %s (expected: %s)",
+				registry,
+				normalized,
+				expected,
+			)
+		}
+	}
+}
+
+func TestLoadWithKeysToNormalize(t *testing.T) {
+	keysConfigFile := "../../fixtures/docker/config.json.keys"
+
+	examples := map[string]string{
+		"registry.hub.docker.com": "hub:hubpass",
+		"docker.io":               "hub:hubpass",
+		"index.docker.io":         "hub:hubpass",
+		"registry.company.io":     "user1:pass1",
+		"localhost:5000":          "local:localpass",
+		"quay.io":                 "quay:quaypass",
+	}
+
+	c, err := Load(keysConfigFile)
+
+	if err != nil {
+		t.Fatalf("Error while loading '%s': %s", keysConfigFile, err.Error())
+	}
+
+	for registry, expected := range examples {
+		username, password, defined := c.GetCredentials(registry)
+
+		if !defined {
+			t.Fatalf("Unable to get credentials from registry: %s", registry)
+		}
+
+		value := username + ":" + password
+
+		if value != expected {
+			t.Fatalf(
+				"Unexpected 'username:password' for registry '%s': '%s' (expected: '%s')",
+				registry,
+				value,
+				expected,
+			)
+		}
+	}
+
+	if _, _, defined := c.GetCredentials("registry.mindundi.org"); defined {
+		t.Fatalf("Expected no credentials for undefined registry")
+	}
+}
+
+func TestServerKey(t *testing.T) {
+	c := &Config{serverKeys: map[string]string{"registry.company.io": "https://registry.company.io/v2/"}}
+
+	examples := map[string]string{
+		"registry.company.io":     "https://registry.company.io/v2/",
+		"registry.hub.docker.com": HubServerAddress,
+		"quay.io":                 "quay.io",
+	}
+
+	for registry, expected := range examples {
+		if key := c.serverKey(registry); key != expected {
+			t.Fatalf("Unexpected server key for '%s': %s (expected: %s)", registry, key, expected)
+		}
+	}
+}
+
+func TestHelperName(t *testing.T) {
+	c := &Config{
+		CredsStore:  "desktop",
+		CredHelpers: map[string]string{"https://us.gcr.io": "gcloud"},
+	}
+
+	examples := map[string]string{
+		"us.gcr.io":               "gcloud",
+		"registry.company.io":     "desktop",
+		"registry.hub.docker.com": "desktop",
+	}
+
+	for registry, expected := range examples {
+		if name := c.helperName(registry); name != expected {
+			t.Fatalf("Unexpected credential helper for '%s': %s (expected: %s)", registry, name, expected)
+		}
+	}
+}
+
+func TestStoreAndEraseCredentialsWithNoHelper(t *testing.T) {
+	c, err := Load(configFile)
+
+	if err != nil {
+		t.Fatalf("Error while loading '%s': %s", configFile, err.Error())
+	}
+
+	if err := c.StoreCredentials("registry.company.io", "user1", "pass1"); err == nil {
+		t.Fatalf("Expected to fail storing credentials with no credential helper configured")
+	}
+
+	if err := c.EraseCredentials("registry.company.io"); err == nil {
+		t.Fatalf("Expected to fail erasing credentials with no credential helper configured")
+	}
+}
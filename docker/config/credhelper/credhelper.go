@@ -2,59 +2,165 @@ package credhelper
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
-	"os"
+	"fmt"
 	"os/exec"
+	"strings"
+	"sync"
+	"time"
 )
 
+// DefaultTimeout is a default time we give credential helper to respond
+const DefaultTimeout = 10 * time.Second
+
+// ErrCredentialsNotFound is returned when helper works, but has no credentials for the server requested
+var ErrCredentialsNotFound = errors.New("credentials not found in native keychain")
+
+// ErrCredentialsMissingServerURL is returned when helper is asked about an empty server URL
+var ErrCredentialsMissingServerURL = errors.New("no credentials server URL")
+
 type storedCredentials struct {
-	Username string
-	Secret   string
+	ServerURL string `json:",omitempty"`
+	Username  string
+	Secret    string
 }
 
-// GetCredentials gets Docker registry credentials either from "credsStore" or "credHelpers"
-func GetCredentials(registry, credsStore string, credHelpers map[string]string) (string, string, error) {
-	if credsStore != "" {
-		c, err := getCredentials(registry, credsStore)
+type result struct {
+	c   *storedCredentials
+	err error
+}
 
-		if err == nil {
-			return c.Username, c.Secret, nil
-		}
+// Helper is a Docker credential helper, i.e. "docker-credential-<NAME>" program,
+// talking the Docker credential helper protocol ("get", "store", "erase" and "list" actions)
+// NB! Results of "get" action are cached, until helper stores or erases something
+type Helper struct {
+	Name    string
+	Timeout time.Duration
 
-		os.Stderr.WriteString("[credhelper][credsStore] Error: " + err.Error() + "\n")
+	program string
+	cache   map[string]result
+	mux     sync.Mutex
+}
+
+// New creates a new helper by name passed, e.g. "osxkeychain" for "docker-credential-osxkeychain"
+func New(name string) *Helper {
+	return &Helper{
+		Name:    name,
+		Timeout: DefaultTimeout,
+		program: "docker-credential-" + name,
+		cache:   make(map[string]result),
 	}
+}
 
-	provider, defined := credHelpers[registry]
-	if defined {
-		c, err := getCredentials(registry, provider)
+func (h *Helper) run(action string, input []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.Timeout)
+	defer cancel()
 
-		if err == nil {
-			return c.Username, c.Secret, nil
+	cmd := exec.CommandContext(ctx, h.program, action)
+
+	var stdout bytes.Buffer
+
+	cmd.Stdin = bytes.NewBuffer(input)
+	cmd.Stdout = &stdout
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("%s %s: timed out after %v", h.program, action, h.Timeout)
 		}
 
-		os.Stderr.WriteString("[credhelper][credHelpers] Error: " + err.Error() + "\n")
+		// helpers report errors on STDOUT, the well-known ones are mapped to our errors
+		message := strings.TrimSpace(stdout.String())
+		switch message {
+		case ErrCredentialsNotFound.Error():
+			return nil, ErrCredentialsNotFound
+		case ErrCredentialsMissingServerURL.Error():
+			return nil, ErrCredentialsMissingServerURL
+		case "":
+			return nil, fmt.Errorf("%s %s: %s", h.program, action, err.Error())
+		}
+
+		return nil, fmt.Errorf("%s %s: %s", h.program, action, message)
 	}
 
-	return "", "", errors.New("No working credential helpers found for this registry: " + registry)
+	return stdout.Bytes(), nil
 }
 
-func getCredentials(registry, provider string) (*storedCredentials, error) {
-	cmd := exec.Command("docker-credential-"+provider, "get")
+// Get gets username and secret stored for the server passed
+func (h *Helper) Get(serverURL string) (string, string, error) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
 
-	var stdout bytes.Buffer
+	r, cached := h.cache[serverURL]
+	if !cached {
+		r = result{}
 
-	cmd.Stdin = bytes.NewBuffer([]byte(registry))
-	cmd.Stdout = &stdout
+		data, err := h.run("get", []byte(serverURL))
+		if err == nil {
+			r.c = &storedCredentials{}
+			err = json.Unmarshal(data, r.c)
+		}
+		r.err = err
 
-	if err := cmd.Run(); err != nil {
+		h.cache[serverURL] = r
+	}
+
+	if r.err != nil {
+		return "", "", r.err
+	}
+
+	return r.c.Username, r.c.Secret, nil
+}
+
+// List lists servers helper has credentials for (as a map of server URLs to usernames)
+func (h *Helper) List() (map[string]string, error) {
+	data, err := h.run("list", nil)
+	if err != nil {
 		return nil, err
 	}
 
-	var c storedCredentials
-	if err := json.NewDecoder(&stdout).Decode(&c); err != nil {
+	servers := make(map[string]string)
+	if err := json.Unmarshal(data, &servers); err != nil {
 		return nil, err
 	}
 
-	return &c, nil
+	return servers, nil
+}
+
+// Store stores username and secret for the server passed
+func (h *Helper) Store(serverURL, username, secret string) error {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	input, err := json.Marshal(storedCredentials{ServerURL: serverURL, Username: username, Secret: secret})
+	if err != nil {
+		return err
+	}
+
+	delete(h.cache, serverURL)
+
+	_, err = h.run("store", input)
+
+	return err
+}
+
+// Erase erases credentials stored for the server passed
+func (h *Helper) Erase(serverURL string) error {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	delete(h.cache, serverURL)
+
+	_, err := h.run("erase", []byte(serverURL))
+
+	return err
+}
+
+// ResetCache forgets all cached "get" results, so helper will be asked again
+func (h *Helper) ResetCache() {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	h.cache = make(map[string]result)
 }
@@ -0,0 +1,186 @@
+package credhelper
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeHelperEnv tells test binary to act as a fake credential helper, keeping credentials in a file passed
+const fakeHelperEnv = "LSTAGS_FAKE_CREDHELPER_STORE"
+
+// fakeHelperSleepEnv tells fake credential helper to hang for a while before doing anything
+const fakeHelperSleepEnv = "LSTAGS_FAKE_CREDHELPER_SLEEP"
+
+// fakeHelperCountEnv tells fake credential helper to count its invocations in a file passed
+const fakeHelperCountEnv = "LSTAGS_FAKE_CREDHELPER_COUNT"
+
+func TestMain(m *testing.M) {
+	if storeFile := os.Getenv(fakeHelperEnv); storeFile != "" {
+		os.Exit(runFakeHelper(storeFile, os.Args[len(os.Args)-1]))
+	}
+
+	os.Exit(m.Run())
+}
+
+func runFakeHelper(storeFile, action string) int {
+	if d, err := time.ParseDuration(os.Getenv(fakeHelperSleepEnv)); err == nil {
+		time.Sleep(d)
+	}
+
+	if countFile := os.Getenv(fakeHelperCountEnv); countFile != "" {
+		f, _ := os.OpenFile(countFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		f.WriteString(action + "\n")
+		f.Close()
+	}
+
+	store := make(map[string]storedCredentials)
+	if data, err := ioutil.ReadFile(storeFile); err == nil {
+		json.Unmarshal(data, &store)
+	}
+
+	input, _ := ioutil.ReadAll(os.Stdin)
+
+	switch action {
+	case "get":
+		c, defined := store[string(input)]
+		if !defined {
+			fmt.Println(ErrCredentialsNotFound.Error())
+			return 1
+		}
+		json.NewEncoder(os.Stdout).Encode(c)
+	case "store":
+		var c storedCredentials
+		json.Unmarshal(input, &c)
+		store[c.ServerURL] = c
+	case "erase":
+		if _, defined := store[string(input)]; !defined {
+			fmt.Println(ErrCredentialsNotFound.Error())
+			return 1
+		}
+		delete(store, string(input))
+	case "list":
+		servers := make(map[string]string)
+		for serverURL, c := range store {
+			servers[serverURL] = c.Username
+		}
+		json.NewEncoder(os.Stdout).Encode(servers)
+		return 0
+	default:
+		fmt.Println("unknown action: " + action)
+		return 1
+	}
+
+	data, _ := json.Marshal(store)
+	ioutil.WriteFile(storeFile, data, 0600)
+
+	return 0
+}
+
+func newFakeHelper(t *testing.T) (*Helper, string, func()) {
+	dir, err := ioutil.TempDir("", "lstags-credhelper-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv(fakeHelperEnv, filepath.Join(dir, "store.json"))
+
+	h := New("fake")
+	h.program = os.Args[0]
+
+	return h, dir, func() {
+		os.Unsetenv(fakeHelperEnv)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestHelperStoreGetListErase(t *testing.T) {
+	assert := assert.New(t)
+
+	h, _, cleanup := newFakeHelper(t)
+	defer cleanup()
+
+	_, _, err := h.Get("registry.company.io")
+	assert.Equal(ErrCredentialsNotFound, err)
+
+	assert.Nil(h.Store("registry.company.io", "user1", "pass1"))
+	assert.Nil(h.Store("https://index.docker.io/v1/", "hub", "hubpass"))
+
+	username, secret, err := h.Get("registry.company.io")
+	assert.Nil(err)
+	assert.Equal("user1", username)
+	assert.Equal("pass1", secret)
+
+	servers, err := h.List()
+	assert.Nil(err)
+	assert.Equal(
+		map[string]string{"registry.company.io": "user1", "https://index.docker.io/v1/": "hub"},
+		servers,
+	)
+
+	assert.Nil(h.Erase("registry.company.io"))
+	assert.Equal(ErrCredentialsNotFound, h.Erase("registry.company.io"))
+
+	_, _, err = h.Get("registry.company.io")
+	assert.Equal(ErrCredentialsNotFound, err)
+}
+
+func TestHelperCache(t *testing.T) {
+	assert := assert.New(t)
+
+	h, dir, cleanup := newFakeHelper(t)
+	defer cleanup()
+
+	countFile := filepath.Join(dir, "count")
+	os.Setenv(fakeHelperCountEnv, countFile)
+	defer os.Unsetenv(fakeHelperCountEnv)
+
+	assert.Nil(h.Store("registry.company.io", "user1", "pass1"))
+
+	for i := 0; i < 3; i++ {
+		_, _, err := h.Get("registry.company.io")
+		assert.Nil(err)
+		_, _, err = h.Get("quay.io")
+		assert.Equal(ErrCredentialsNotFound, err)
+	}
+
+	h.ResetCache()
+
+	_, _, err := h.Get("registry.company.io")
+	assert.Nil(err)
+
+	calls, _ := ioutil.ReadFile(countFile)
+	assert.Equal("store\nget\nget\nget\n", string(calls))
+}
+
+func TestHelperTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	h, _, cleanup := newFakeHelper(t)
+	defer cleanup()
+	h.Timeout = 100 * time.Millisecond
+
+	os.Setenv(fakeHelperSleepEnv, "5s")
+	defer os.Unsetenv(fakeHelperSleepEnv)
+
+	_, _, err := h.Get("registry.company.io")
+	assert.NotNil(err)
+	assert.NotEqual(ErrCredentialsNotFound, err)
+	assert.Contains(err.Error(), "timed out")
+}
+
+func TestHelperAbsent(t *testing.T) {
+	assert := assert.New(t)
+
+	h := New("i-do-not-exist-sorry")
+
+	_, _, err := h.Get("registry.company.io")
+	assert.NotNil(err)
+	assert.NotEqual(ErrCredentialsNotFound, err)
+}
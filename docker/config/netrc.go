@@ -0,0 +1,99 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ivanilves/lstags/util/fix"
+)
+
+// Netrc holds per-registry credentials loaded from netrc-style file ("machine HOST login USER password PASS")
+// NB! "default" entry is ignored on purpose: we never send the same credentials to any registry
+type Netrc struct {
+	usernames map[string]string
+	passwords map[string]string
+}
+
+// LoadNetrc loads netrc-style file specified
+func LoadNetrc(fileName string) (*Netrc, error) {
+	f, err := os.Open(fix.Path(fileName))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	n := &Netrc{usernames: make(map[string]string), passwords: make(map[string]string)}
+
+	var machine string
+	var isMacro bool
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		// macro definition lasts till the empty line
+		if isMacro {
+			isMacro = line != ""
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			key := fields[i]
+
+			switch key {
+			case "default":
+				machine = ""
+				continue
+			case "macdef":
+				isMacro = true
+				i = len(fields)
+				continue
+			}
+
+			if i+1 >= len(fields) {
+				return nil, fmt.Errorf("invalid netrc file '%s': no value for '%s'", fileName, key)
+			}
+			i++
+			value := fields[i]
+
+			switch key {
+			case "machine":
+				machine = NormalizeRegistry(value)
+			case "login":
+				if machine != "" {
+					n.usernames[machine] = value
+				}
+			case "password":
+				if machine != "" {
+					n.passwords[machine] = value
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return n, nil
+}
+
+// GetCredentials gets credentials for the registry passed (both login and password have to be set)
+func (n *Netrc) GetCredentials(registry string) (string, string, bool) {
+	registry = NormalizeRegistry(registry)
+
+	username, usernameDefined := n.usernames[registry]
+	password, passwordDefined := n.passwords[registry]
+
+	if !usernameDefined || !passwordDefined {
+		return "", "", false
+	}
+
+	return username, password, true
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Provider is an additional source of registry credentials, consulted by Config.GetCredentials
+// NB! Registry passed to provider is always normalized (see NormalizeRegistry)
+type Provider interface {
+	GetCredentials(registry string) (string, string, bool)
+}
+
+// EnvPrefix is a prefix of environment variables holding per-registry credentials
+const EnvPrefix = "LSTAGS_REGISTRY_"
+
+var envNameEx = regexp.MustCompile("[^A-Z0-9]+")
+
+// EnvProvider provides credentials from per-registry environment variables, e.g. for "registry.company.io:5000":
+// LSTAGS_REGISTRY_REGISTRY_COMPANY_IO_5000_USERNAME and LSTAGS_REGISTRY_REGISTRY_COMPANY_IO_5000_PASSWORD
+type EnvProvider struct{}
+
+// EnvNames gets names of username and password environment variables for the registry passed
+func EnvNames(registry string) (string, string) {
+	name := EnvPrefix + envNameEx.ReplaceAllString(strings.ToUpper(NormalizeRegistry(registry)), "_")
+
+	return name + "_USERNAME", name + "_PASSWORD"
+}
+
+// GetCredentials gets credentials for the registry passed from environment variables (both have to be set)
+func (EnvProvider) GetCredentials(registry string) (string, string, bool) {
+	usernameName, passwordName := EnvNames(registry)
+
+	username, usernameDefined := os.LookupEnv(usernameName)
+	password, passwordDefined := os.LookupEnv(passwordName)
+
+	if !usernameDefined || !passwordDefined || username == "" {
+		return "", "", false
+	}
+
+	return username, password, true
+}
+
+// WithProviders sets providers consulted before and after credentials of the config itself (incl. credential helpers)
+func (c *Config) WithProviders(before, after []Provider) *Config {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.before = before
+	c.after = after
+
+	return c
+}
+
+func getProvidedCredentials(providers []Provider, registry string) (string, string, bool) {
+	for _, p := range providers {
+		if username, password, defined := p.GetCredentials(registry); defined {
+			return username, password, true
+		}
+	}
+
+	return "", "", false
+}
@@ -0,0 +1,137 @@
+package config
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeProvider map[string]string
+
+func (p fakeProvider) GetCredentials(registry string) (string, string, bool) {
+	password, defined := p[registry]
+	if !defined {
+		return "", "", false
+	}
+
+	return "fake", password, true
+}
+
+func TestEnvNames(t *testing.T) {
+	assert := assert.New(t)
+
+	examples := map[string]string{
+		"registry.company.io:5000": "LSTAGS_REGISTRY_REGISTRY_COMPANY_IO_5000",
+		"https://quay.io/v2/":      "LSTAGS_REGISTRY_QUAY_IO",
+		"docker.io":                "LSTAGS_REGISTRY_REGISTRY_HUB_DOCKER_COM",
+	}
+
+	for registry, expected := range examples {
+		usernameName, passwordName := EnvNames(registry)
+
+		assert.Equal(expected+"_USERNAME", usernameName)
+		assert.Equal(expected+"_PASSWORD", passwordName)
+	}
+}
+
+func TestEnvProvider(t *testing.T) {
+	assert := assert.New(t)
+
+	os.Setenv("LSTAGS_REGISTRY_REGISTRY_COMPANY_IO_USERNAME", "envuser")
+	os.Setenv("LSTAGS_REGISTRY_REGISTRY_COMPANY_IO_PASSWORD", "envpass")
+	os.Setenv("LSTAGS_REGISTRY_QUAY_IO_USERNAME", "nopassword")
+	defer os.Unsetenv("LSTAGS_REGISTRY_REGISTRY_COMPANY_IO_USERNAME")
+	defer os.Unsetenv("LSTAGS_REGISTRY_REGISTRY_COMPANY_IO_PASSWORD")
+	defer os.Unsetenv("LSTAGS_REGISTRY_QUAY_IO_USERNAME")
+
+	username, password, defined := EnvProvider{}.GetCredentials("registry.company.io")
+	assert.True(defined)
+	assert.Equal("envuser", username)
+	assert.Equal("envpass", password)
+
+	_, _, defined = EnvProvider{}.GetCredentials("quay.io")
+	assert.False(defined, "should need both username and password")
+
+	_, _, defined = EnvProvider{}.GetCredentials("us.gcr.io")
+	assert.False(defined)
+}
+
+func TestLoadNetrc(t *testing.T) {
+	assert := assert.New(t)
+
+	n, err := LoadNetrc("../../fixtures/docker/netrc")
+	assert.Nil(err)
+
+	examples := map[string]string{
+		"registry.company.io":     "netuser:netpass",
+		"registry.hub.docker.com": "hubnet:hubnetpass",
+	}
+
+	for registry, expected := range examples {
+		username, password, defined := n.GetCredentials(registry)
+
+		assert.True(defined, registry)
+		assert.Equal(expected, username+":"+password)
+	}
+
+	for _, registry := range []string{"quay.io", "ignored.io", "us.gcr.io"} {
+		_, _, defined := n.GetCredentials(registry)
+
+		assert.False(defined, registry)
+	}
+
+	_, err = LoadNetrc("/i/do/not/exist/sorry")
+	assert.NotNil(err)
+}
+
+func TestLoadKubernetesSecret(t *testing.T) {
+	assert := assert.New(t)
+
+	c, err := Load("../../fixtures/docker/kubernetes.dockerconfigjson")
+	assert.Nil(err)
+
+	examples := map[string]string{
+		"registry.kube.io":    "kubeuser:kubepass",
+		"registry.company.io": "kube1:kpass1",
+	}
+
+	for registry, expected := range examples {
+		username, password, defined := c.GetCredentials(registry)
+
+		assert.True(defined, registry)
+		assert.Equal(expected, username+":"+password)
+	}
+}
+
+func TestGetCredentialsWithProviders(t *testing.T) {
+	assert := assert.New(t)
+
+	c, err := Load(configFile)
+	assert.Nil(err)
+
+	c.WithProviders(
+		[]Provider{fakeProvider{"quay.io": "before1"}, fakeProvider{"quay.io": "before2", "registry.company.io": "before2"}},
+		[]Provider{fakeProvider{"us.gcr.io": "after", "eu.gcr.io": "after"}},
+	)
+
+	examples := map[string]string{
+		"quay.io":             "fake:before1",
+		"registry.company.io": "fake:before2",
+		"docker.io":           "user2:pass2",
+		"eu.gcr.io":           "fake:after",
+	}
+
+	for registry, expected := range examples {
+		username, password, defined := c.GetCredentials(registry)
+
+		assert.True(defined, registry)
+		assert.Equal(expected, username+":"+password)
+	}
+
+	username, _, _ := c.GetCredentials("us.gcr.io")
+	assert.Equal("_json_key", username, "Docker config should take precedence over the providers after it")
+
+	_, _, defined := c.GetCredentials("registry.mindundi.org")
+	assert.False(defined)
+}
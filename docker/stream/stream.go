@@ -0,0 +1,262 @@
+// Package stream parses JSON message streams returned by Docker daemon on image pull and push
+// (concatenated "jsonmessage" objects) into typed events and aggregates them into per-image progress.
+package stream
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Kinds of events we distinguish
+const (
+	// Status is a plain status message, e.g. "Pulling from library/alpine" or "Pull complete"
+	Status = "status"
+	// Progress is a layer download/upload progress message, e.g. "Downloading" with byte counts
+	Progress = "progress"
+	// Error is an error message, it terminates the stream processing
+	Error = "error"
+)
+
+// Event is a single typed event from Docker daemon stream
+type Event struct {
+	Kind    string
+	ID      string // layer ID (if event is layer-specific)
+	Status  string
+	Current int64
+	Total   int64
+	Error   string
+}
+
+// String gives us a human-readable event representation (used for logging)
+func (e Event) String() string {
+	prefix := ""
+	if e.ID != "" {
+		prefix = e.ID + ": "
+	}
+
+	switch e.Kind {
+	case Progress:
+		return fmt.Sprintf("%s%s %d/%d", prefix, e.Status, e.Current, e.Total)
+	case Error:
+		return prefix + "ERROR: " + e.Error
+	}
+
+	return prefix + e.Status
+}
+
+type message struct {
+	ID             string `json:"id"`
+	Status         string `json:"status"`
+	ProgressDetail struct {
+		Current int64 `json:"current"`
+		Total   int64 `json:"total"`
+	} `json:"progressDetail"`
+	Error       string `json:"error"`
+	ErrorDetail *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+	Aux *struct {
+		Digest string `json:"Digest"`
+	} `json:"aux"`
+}
+
+func (m message) event() Event {
+	if m.ErrorDetail != nil || m.Error != "" {
+		e := Event{Kind: Error, ID: m.ID, Error: m.Error}
+		if m.ErrorDetail != nil && m.ErrorDetail.Message != "" {
+			e.Error = m.ErrorDetail.Message
+		}
+
+		return e
+	}
+
+	if m.ProgressDetail.Total > 0 || m.ProgressDetail.Current > 0 {
+		return Event{
+			Kind:    Progress,
+			ID:      m.ID,
+			Status:  m.Status,
+			Current: m.ProgressDetail.Current,
+			Total:   m.ProgressDetail.Total,
+		}
+	}
+
+	return Event{Kind: Status, ID: m.ID, Status: m.Status}
+}
+
+// layer statuses meaning that layer is transferred (or does not need to be)
+var layerDoneStatuses = []string{
+	"Pull complete",
+	"Already exists",
+	"Pushed",
+	"Layer already exists",
+	"Mounted from",
+}
+
+func isLayerDone(status string) bool {
+	for _, s := range layerDoneStatuses {
+		if strings.HasPrefix(status, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Summary is an aggregated progress of the image pull or push
+type Summary struct {
+	Layers     int
+	LayersDone int
+	BytesDone  int64
+	BytesTotal int64
+	Digest     string
+
+	layers map[string]*layer
+}
+
+type layer struct {
+	current int64
+	total   int64
+	done    bool
+}
+
+// String gives us a human-readable summary, e.g. "3/3 layers, 2.7 MB/2.7 MB"
+func (s *Summary) String() string {
+	str := fmt.Sprintf("%d/%d layers, %s/%s", s.LayersDone, s.Layers, humanSize(s.BytesDone), humanSize(s.BytesTotal))
+
+	if s.Digest != "" {
+		str = str + ", digest: " + s.Digest
+	}
+
+	return str
+}
+
+func humanSize(n int64) string {
+	const unit = 1000
+
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "kMGTPE"[exp])
+}
+
+func (s *Summary) layer(id string) *layer {
+	l, defined := s.layers[id]
+	if !defined {
+		l = &layer{}
+		s.layers[id] = l
+	}
+
+	return l
+}
+
+// add updates summary with event passed
+func (s *Summary) add(e Event) {
+	if e.Kind == Status && strings.HasPrefix(e.Status, "Digest: ") {
+		s.Digest = strings.TrimPrefix(e.Status, "Digest: ")
+	}
+
+	// layer events have IDs, but so have events related to the whole image (e.g. "latest: Pulling from ...")
+	if e.ID == "" || strings.HasPrefix(e.Status, "Pulling from") || strings.HasPrefix(e.Status, "The push refers to") {
+		return
+	}
+
+	l := s.layer(e.ID)
+
+	if e.Kind == Progress {
+		if e.Total > l.total {
+			l.total = e.Total
+		}
+		// the same layer is first downloaded and then extracted, we only count download bytes
+		if strings.HasPrefix(e.Status, "Downloading") || strings.HasPrefix(e.Status, "Pushing") {
+			l.current = e.Current
+		}
+	}
+
+	if e.Kind == Status && isLayerDone(e.Status) {
+		l.done = true
+		if l.current < l.total {
+			l.current = l.total
+		}
+	}
+
+	s.recalculate()
+}
+
+func (s *Summary) recalculate() {
+	s.Layers, s.LayersDone, s.BytesDone, s.BytesTotal = len(s.layers), 0, 0, 0
+
+	for _, l := range s.layers {
+		if l.done {
+			s.LayersDone++
+		}
+
+		s.BytesDone += l.current
+		s.BytesTotal += l.total
+	}
+}
+
+// Decode decodes the stream passed, calling function passed (if not nil) for every event decoded,
+// and returns aggregated summary. Error is returned on malformed stream or any error event received.
+func Decode(r io.Reader, handle func(Event)) (*Summary, error) {
+	s := &Summary{layers: make(map[string]*layer)}
+
+	dec := json.NewDecoder(r)
+	for {
+		var m message
+
+		if err := dec.Decode(&m); err != nil {
+			if err == io.EOF {
+				return s, nil
+			}
+
+			return s, fmt.Errorf("malformed Docker daemon stream: %s", err.Error())
+		}
+
+		e := m.event()
+
+		if handle != nil {
+			handle(e)
+		}
+
+		if e.Kind == Error {
+			return s, errors.New(e.Error)
+		}
+
+		if m.Aux != nil && m.Aux.Digest != "" {
+			s.Digest = m.Aux.Digest
+		}
+
+		s.add(e)
+	}
+}
+
+// Log decodes (and closes) the stream passed, logging every event as a debug message prefixed with label passed
+func Log(r io.ReadCloser, label string) (*Summary, error) {
+	return Watch(r, label, nil)
+}
+
+// Watch is the same as Log, but it also calls function passed (if not nil) for every event decoded
+func Watch(r io.ReadCloser, label string, handle func(Event)) (*Summary, error) {
+	defer r.Close()
+
+	return Decode(r, func(e Event) {
+		log.Debugf("[%s] %s", label, e.String())
+
+		if handle != nil {
+			handle(e)
+		}
+	})
+}
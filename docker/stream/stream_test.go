@@ -0,0 +1,107 @@
+package stream
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const fixtureDir = "../../fixtures/docker/stream/"
+
+func decodeFixture(t *testing.T, name string) (*Summary, []Event, error) {
+	f, err := os.Open(fixtureDir + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	events := make([]Event, 0)
+
+	s, err := Decode(f, func(e Event) { events = append(events, e) })
+
+	return s, events, err
+}
+
+func TestDecode_Pull(t *testing.T) {
+	assert := assert.New(t)
+
+	s, events, err := decodeFixture(t, "pull.json")
+	assert.Nil(err)
+	assert.Equal(13, len(events))
+
+	assert.Equal(2, s.Layers)
+	assert.Equal(2, s.LayersDone)
+	assert.Equal(int64(2065537), s.BytesDone)
+	assert.Equal(int64(2065537), s.BytesTotal)
+	assert.Equal("sha256:8421d9a84432575381bfabd248f1eb56f3aa21d9d7cd2511583c68c9b7511d10", s.Digest)
+	assert.True(strings.HasPrefix(s.String(), "2/2 layers, 2.1 MB/2.1 MB"), s.String())
+
+	assert.Equal(Progress, events[3].Kind)
+	assert.Equal("ff3a5c916c92: Downloading 1000000/2065537", events[3].String())
+	assert.Equal(Status, events[4].Kind)
+}
+
+func TestDecode_Push(t *testing.T) {
+	assert := assert.New(t)
+
+	s, _, err := decodeFixture(t, "push.json")
+	assert.Nil(err)
+
+	assert.Equal(1, s.Layers)
+	assert.Equal(1, s.LayersDone)
+	assert.Equal(int64(4413370), s.BytesDone)
+	assert.Equal("sha256:8421d9a84432575381bfabd248f1eb56f3aa21d9d7cd2511583c68c9b7511d10", s.Digest)
+}
+
+func TestDecode_Error(t *testing.T) {
+	assert := assert.New(t)
+
+	_, events, err := decodeFixture(t, "pull.error.json")
+	assert.NotNil(err)
+	assert.Equal("unauthorized: authentication required", err.Error())
+	assert.Equal(Error, events[len(events)-1].Kind, "should stop on the first error")
+}
+
+func TestWatch(t *testing.T) {
+	assert := assert.New(t)
+
+	f, err := os.Open(fixtureDir + "pull.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := make([]Event, 0)
+
+	s, err := Watch(f, "alpine:latest", func(e Event) { events = append(events, e) })
+	assert.Nil(err)
+	assert.Equal(13, len(events))
+	assert.Equal(2, s.LayersDone)
+
+	_, err = Log(ioutil.NopCloser(strings.NewReader("")), "alpine:latest")
+	assert.Nil(err, "should accept no handler")
+}
+
+func TestDecode_Malformed(t *testing.T) {
+	_, _, err := decodeFixture(t, "pull.malformed.json")
+
+	assert.NotNil(t, err)
+}
+
+func TestHumanSize(t *testing.T) {
+	assert := assert.New(t)
+
+	examples := map[int64]string{
+		0:          "0 B",
+		999:        "999 B",
+		1000:       "1.0 kB",
+		2065537:    "2.1 MB",
+		4413370000: "4.4 GB",
+	}
+
+	for n, expected := range examples {
+		assert.Equal(expected, humanSize(n))
+	}
+}
@@ -2,23 +2,34 @@ package main
 
 import (
 	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
+	"reflect"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/jessevdk/go-flags"
 	log "github.com/sirupsen/logrus"
 
 	v1 "github.com/ivanilves/lstags/api/v1"
-	"github.com/ivanilves/lstags/api/v1/registry/client/auth"
+	"github.com/ivanilves/lstags/api/v1/collection"
+	"github.com/ivanilves/lstags/api/v1/plan"
 	"github.com/ivanilves/lstags/config"
+	"github.com/ivanilves/lstags/config/validate"
+	dockerconfig "github.com/ivanilves/lstags/docker/config"
+	"github.com/ivanilves/lstags/notification"
+	"github.com/ivanilves/lstags/repository"
+	"github.com/ivanilves/lstags/tag"
 )
 
 // Options represents configuration options we extract from passed command line arguments
 type Options struct {
-	YAMLConfig         string        `short:"f" long:"yaml-config" description:"YAML file to load repositories from" env:"YAML_CONFIG"`
+	YAMLConfig         string        `short:"f" long:"yaml-config" description:"YAML file to load repositories and options from" env:"YAML_CONFIG"`
 	DockerJSON         string        `short:"j" long:"docker-json" default:"~/.docker/config.json" description:"JSON file with credentials" env:"DOCKER_JSON"`
 	Pull               bool          `short:"p" long:"pull" description:"Pull Docker images matched by filter (will use local Docker deamon)" env:"PULL"`
 	Push               bool          `short:"P" long:"push" description:"Push Docker images matched by filter to some registry (See 'push-registry')" env:"PUSH"`
@@ -28,19 +39,42 @@ type Options struct {
 	PushPathTemplate   string        `long:"push-path-template" default:"{{ .Prefix }}{{ .Path }}" description:"[Re]Push pulled images with a go template to change repo path, sprig functions are supported" env:"PUSH_PATH_TEMPLATE"`
 	PushTagTemplate    string        `long:"push-tag-template" default:"{{ .Tag }}" description:"[Re]Push pulled images with a go template to change repo tag, sprig functions are supported" env:"PUSH_TAG_TEMPLATE"`
 	NoSSLVerify        bool          `short:"k" long:"no-ssl-verify" description:"Allow registry without certificate verify" env:"NO_SSL_VERIFY"`
+	PushCleanup        bool          `long:"push-cleanup" description:"Remove local images pulled solely to be [re]pushed" env:"PUSH_CLEANUP"`
 	PushUpdate         bool          `short:"U" long:"push-update" description:"Update our pushed images if remote image digest changes" env:"PUSH_UPDATE"`
+	PushProtectedTags  string        `long:"push-protected-tags" description:"Expression to match tags (as they are pushed) never to be overwritten in push registry (even with 'push-update'), e.g. release ones" env:"PUSH_PROTECTED_TAGS"`
 	PathSeparator      string        `short:"s" long:"path-separator" default:"/" description:"Configure path separator for registries that only allow single folder depth" env:"PATH_SEPARATOR"`
 	ConcurrentRequests int           `short:"c" long:"concurrent-requests" default:"16" description:"Limit of concurrent requests to the registry" env:"CONCURRENT_REQUESTS"`
-	WaitBetween        time.Duration `short:"w" long:"wait-between" default:"0" description:"Time to wait between batches of requests (incl. pulls and pushes)" env:"WAIT_BETWEEN"`
+	RequestRate        float64       `long:"request-rate" default:"0" description:"Limit of requests per second to every registry (0 means no limit)" env:"REQUEST_RATE"`
+	WaitBetween        time.Duration `short:"w" long:"wait-between" default:"0" description:"Time to wait between requests to the same registry (if no 'request-rate' set)" env:"WAIT_BETWEEN"`
 	RetryRequests      int           `short:"y" long:"retry-requests" default:"2" description:"Number of retries for failed Docker registry requests" env:"RETRY_REQUESTS"`
 	RetryDelay         time.Duration `short:"D" long:"retry-delay" default:"2s" description:"Delay between retries of failed registry requests" env:"RETRY_DELAY"`
 	InsecureRegistryEx string        `short:"I" long:"insecure-registry-ex" description:"Expression to match insecure registry hostnames" env:"INSECURE_REGISTRY_EX"`
 	BasicAuth          []string      `short:"B" long:"basic-auth" description:"Set per-registry BASIC auth username:password pair" env:"BASIC_AUTH"`
+	KubeSecret         string        `long:"kube-secret" description:"Mounted Kubernetes 'kubernetes.io/dockerconfigjson' secret FILE to take credentials from" env:"KUBE_SECRET"`
+	Netrc              string        `long:"netrc" description:"Netrc-style FILE to take credentials from ('machine HOST login USER password PASS')" env:"NETRC_FILE"`
 	TraceRequests      bool          `short:"T" long:"trace-requests" description:"Trace Docker registry HTTP requests" env:"TRACE_REQUESTS"`
 	DoNotFail          bool          `short:"N" long:"do-not-fail" description:"Do not fail on non-critical errors (could be dangerous!)" env:"DO_NOT_FAIL"`
 	DaemonMode         bool          `short:"d" long:"daemon-mode" description:"Run as daemon instead of just execute and exit" env:"DAEMON_MODE"`
 	PollingInterval    time.Duration `short:"i" long:"polling-interval" default:"60s" description:"Wait between polls when running in daemon mode" env:"POLLING_INTERVAL"`
+	NotificationListen string        `short:"L" long:"notification-listen" description:"Listen for Docker registry notifications on ADDR[:PORT] (path: /notifications) to sync pushed images instantly (daemon mode only)" env:"NOTIFICATION_LISTEN"`
+	OCILayout          string        `long:"oci-layout" description:"Use OCI image layout directory as a local image store instead of Docker daemon" env:"OCI_LAYOUT"`
+	CacheDir           string        `long:"cache-dir" description:"Keep tag metadata cache in DIR to revalidate tags instead of fetching them again" env:"CACHE_DIR"`
+	CacheTTL           time.Duration `long:"cache-ttl" default:"168h" description:"Evict metadata of tags not seen for longer than DURATION from cache (See 'cache-dir')" env:"CACHE_TTL"`
+	Prune              bool          `long:"prune" description:"Prune local images of the repositories specified (See 'prune-*' options for rules)" env:"PRUNE"`
+	PruneKeepNewest    int           `long:"prune-keep-newest" default:"0" description:"Keep N newest local images of every repository, prune the older ones" env:"PRUNE_KEEP_NEWEST"`
+	PruneKeepUpstream  bool          `long:"prune-keep-upstream" description:"Never prune local images present in registry with the same digest" env:"PRUNE_KEEP_UPSTREAM"`
+	PruneLocalOnly     bool          `long:"prune-local-only" description:"Prune local images absent in registry (LOCAL_ONLY)" env:"PRUNE_LOCAL_ONLY"`
+	PruneChanged       bool          `long:"prune-changed" description:"Prune stale local images having different digest in registry (CHANGED)" env:"PRUNE_CHANGED"`
+	Export             string        `long:"export" description:"Export images matched by filter into an air-gapped bundle FILE (tar archive)" env:"EXPORT"`
+	Import             string        `long:"import" description:"Import images from an air-gapped bundle FILE and push them to a specified registry (See 'push-registry'), or to all YAML config push targets" env:"IMPORT"`
+	Plan               string        `long:"plan" description:"Write sync plan FILE (what would be pushed and why) instead of pushing, to be reviewed and applied later (See 'apply')" env:"PLAN"`
+	Apply              string        `long:"apply" description:"Push exactly what is planned in sync plan FILE, refusing entries whose source digest has moved since planning" env:"APPLY"`
+	Login              string        `long:"login" description:"Store credentials for a REGISTRY with the configured Docker credential helper (password is read from STDIN) and exit" env:"LOGIN"`
+	LoginUsername      string        `long:"login-username" description:"Username to store credentials for (See 'login')" env:"LOGIN_USERNAME"`
+	Logout             string        `long:"logout" description:"Erase credentials for a REGISTRY from the configured Docker credential helper and exit" env:"LOGOUT"`
+	OutputFormat       string        `short:"o" long:"output-format" default:"table" choice:"table" choice:"json" description:"Output format for the collected tags" env:"OUTPUT_FORMAT"`
 	Verbose            bool          `short:"v" long:"verbose" description:"Give verbose output while running application" env:"VERBOSE"`
+	Validate           bool          `long:"validate" description:"Validate YAML config (see 'yaml-config'), report all problems found and exit" env:"VALIDATE"`
 	Version            bool          `short:"V" long:"version" description:"Show version and exit"`
 	Positional         struct {
 		Repositories []string `positional-arg-name:"REPO1 REPO2 REPOn" description:"Docker repositories to operate on, e.g.: alpine nginx~/1\\.13\\.5$/ busybox~/1.27.2/"`
@@ -61,12 +95,53 @@ func suicide(err error, critical bool) {
 	exitCode = 254 // not typical error code, for "git grep" friendliness
 }
 
-func parseFlags() (*Options, error) {
+// isSetExplicitly tells us if option was set explicitly either by CLI flag or by environment variable
+func isSetExplicitly(option *flags.Option) bool {
+	if option.IsSet() && !option.IsSetDefault() {
+		return true
+	}
+
+	if option.EnvDefaultKey != "" {
+		if _, defined := os.LookupEnv(option.EnvDefaultKey); defined {
+			return true
+		}
+	}
+
+	return false
+}
+
+// applyYAMLConfig sets options from YAML config, unless they are already set by CLI flags or environment variables
+// (options are mapped by YAML keys being equal to option long names, e.g. "push-registry")
+func applyYAMLConfig(parser *flags.Parser, o *Options, yc *config.Config) {
+	ov := reflect.ValueOf(o).Elem()
+	yv := reflect.ValueOf(yc).Elem()
+
+	for i := 0; i < yv.NumField(); i++ {
+		name := yv.Type().Field(i).Tag.Get("yaml")
+		value := yv.Field(i)
+
+		if value.Kind() != reflect.Ptr || value.IsNil() {
+			continue
+		}
+
+		option := parser.FindOptionByLongName(name)
+		if option == nil || isSetExplicitly(option) {
+			continue
+		}
+
+		ov.FieldByIndex(option.Field().Index).Set(value.Elem())
+	}
+}
+
+func parseFlags() (*Options, *config.Config, error) {
 	var err error
+	var yc *config.Config
 
 	o := &Options{}
 
-	_, err = flags.Parse(o)
+	parser := flags.NewParser(o, flags.Default)
+
+	_, err = parser.Parse()
 	if err != nil {
 		os.Exit(1) // YES! Just exit! Flags will compain on errors on it's own behalf
 	}
@@ -76,44 +151,248 @@ func parseFlags() (*Options, error) {
 		os.Exit(0)
 	}
 
+	if o.Validate {
+		if o.YAMLConfig == "" {
+			return nil, nil, errors.New("Need YAML config to validate (see '--yaml-config')")
+		}
+
+		validateYAMLConfig(o.YAMLConfig, o.DockerJSON, func(c *config.Config) v1.PushConfig {
+			vo := *o
+
+			applyYAMLConfig(parser, &vo, c)
+
+			return makePushConfig(&vo)
+		})
+	}
+
+	if o.YAMLConfig != "" {
+		yc, err = config.LoadYAMLFile(o.YAMLConfig)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		applyYAMLConfig(parser, o, yc)
+	}
+
+	enablePush(o, yc)
+
+	if o.Login != "" || o.Logout != "" {
+		if o.Login != "" && o.Logout != "" {
+			return nil, nil, errors.New("You either '--login' or '--logout', not both")
+		}
+
+		if o.Login != "" && o.LoginUsername == "" {
+			return nil, nil, errors.New("You can only '--login' with '--login-username'")
+		}
+
+		return o, yc, nil
+	}
+
+	if o.Apply != "" {
+		if o.Plan != "" || o.Import != "" || o.DaemonMode {
+			return nil, nil, errors.New("You can only '--apply' without '--plan' or '--import' and not in '--daemon-mode'")
+		}
+
+		return o, yc, nil
+	}
+
+	if o.Import != "" {
+		if o.Export != "" {
+			return nil, nil, errors.New("You either '--export' or '--import', not both")
+		}
+
+		if (o.PushRegistry == "" && (yc == nil || !yc.HasPushRegistry())) || o.DaemonMode {
+			return nil, nil, errors.New("You can only '--import' with '--push-registry' (or YAML push targets) and not in '--daemon-mode'")
+		}
+
+		return o, yc, nil
+	}
+
 	if len(o.Positional.Repositories) == 0 && o.YAMLConfig == "" {
-		return nil, errors.New(`Need at least one repository name, e.g. 'nginx~/^1\.13/' or 'mesosphere/chronos'`)
+		return nil, nil, errors.New(`Need at least one repository name, e.g. 'nginx~/^1\.13/' or 'mesosphere/chronos'`)
 	}
 
 	if len(o.Positional.Repositories) != 0 && o.YAMLConfig != "" {
-		return nil, errors.New("Load repositories from YAML or from CLI args, not from both at the same time")
+		return nil, nil, errors.New("Load repositories from YAML or from CLI args, not from both at the same time")
+	}
+
+	if err := checkRunOptions(o); err != nil {
+		return nil, nil, err
+	}
+
+	if o.RequestRate < 0 {
+		return nil, nil, errors.New("You could not limit request rate with a negative value ('--request-rate')")
+	}
+
+	if o.CacheTTL < 0 {
+		return nil, nil, errors.New("You could not keep cached metadata for a negative time ('--cache-ttl')")
+	}
+
+	if o.NotificationListen != "" && !o.DaemonMode {
+		return nil, nil, errors.New("You can only listen for notifications in '--daemon-mode'")
+	}
+
+	doNotFail = o.DoNotFail || o.DaemonMode
+
+	return o, yc, nil
+}
+
+// enablePush enables push, if push registry is set either by options or by YAML config (unless we pull)
+func enablePush(o *Options, yc *config.Config) {
+	if yc != nil && yc.HasPushRegistry() && !o.Pull {
+		o.Push = true
 	}
 
 	if o.PushRegistry != "localhost:5000" && o.PushRegistry != "" {
 		o.Push = true
 	}
+}
 
+// checkRunOptions checks options every run (incl. daemon polls) depends on
+func checkRunOptions(o *Options) error {
 	if o.Pull && o.Push {
-		return nil, errors.New("You either '--pull' or '--push', not both")
+		return errors.New("You either '--pull' or '--push', not both")
 	}
 
-	doNotFail = o.DoNotFail || o.DaemonMode
+	if o.Plan != "" && (!o.Push || o.DaemonMode) {
+		return errors.New("You can only '--plan' with '--push' and not in '--daemon-mode'")
+	}
+
+	if o.PruneKeepNewest < 0 {
+		return errors.New("You could not keep negative number of images ('--prune-keep-newest')")
+	}
+
+	return nil
+}
 
-	return o, nil
+// reloadOptions makes options anew from CLI flags and environment variables, with YAML config passed re-applied,
+// so options changed in YAML config take effect on the next daemon poll (options given are kept, if new ones are invalid)
+// NB! Options API and daemon are set up with (e.g. "concurrent-requests", "registries", "polling-interval") are not reloaded.
+func reloadOptions(o *Options, yc *config.Config) (*Options, error) {
+	ro := &Options{}
+
+	parser := flags.NewParser(ro, flags.Default)
+	if _, err := parser.Parse(); err != nil {
+		return o, err
+	}
+
+	applyYAMLConfig(parser, ro, yc)
+
+	ro.DaemonMode = o.DaemonMode
+
+	enablePush(ro, yc)
+
+	if err := checkRunOptions(ro); err != nil {
+		return o, fmt.Errorf("unable to reload options from YAML config: %s", err.Error())
+	}
+
+	return ro, nil
+}
+
+// validateYAMLConfig validates YAML config, reports all problems found and exits
+func validateYAMLConfig(path, dockerJSON string, pushOptions validate.PushOptions) {
+	problems := validate.YAMLFile(path, dockerJSON, pushOptions)
+
+	for _, p := range problems {
+		fmt.Println(p.String())
+	}
+
+	if validate.HasErrors(problems) {
+		os.Exit(1)
+	}
+
+	fmt.Printf("OK: %s\n", path)
+	os.Exit(0)
+}
+
+// loginOrLogout stores credentials (password is read from STDIN) or erases them
+// with the credential helper configured in Docker JSON config file
+func loginOrLogout(o *Options) error {
+	dockerConfig, err := dockerconfig.Load(o.DockerJSON)
+	if err != nil {
+		return err
+	}
+
+	if o.Logout != "" {
+		if err := dockerConfig.EraseCredentials(o.Logout); err != nil {
+			return err
+		}
+
+		fmt.Printf("LOGGED OUT: %s\n", o.Logout)
+
+		return nil
+	}
+
+	b, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	password := strings.TrimRight(string(b), "\r\n")
+	if password == "" {
+		return errors.New("Need a password on STDIN to '--login'")
+	}
+
+	if err := dockerConfig.StoreCredentials(o.Login, o.LoginUsername, password); err != nil {
+		return err
+	}
+
+	fmt.Printf("LOGGED IN: %s\n", o.Login)
+
+	return nil
 }
 
 func getVersion() string {
 	return VERSION
 }
 
+// makeRegistryConfigs makes registry-specific API configuration from YAML config (if any)
+func makeRegistryConfigs(yc *config.Config) map[string]v1.RegistryConfig {
+	if yc == nil {
+		return nil
+	}
+
+	registryConfigs := make(map[string]v1.RegistryConfig)
+
+	for registry, r := range yc.Registries {
+		registryConfigs[registry] = v1.RegistryConfig{
+			Scheme:               r.Scheme,
+			InsecureSkipVerify:   r.TLS.InsecureSkipVerify,
+			CAFile:               r.TLS.CAFile,
+			CertFile:             r.TLS.CertFile,
+			KeyFile:              r.TLS.KeyFile,
+			Username:             r.Auth.Username,
+			Password:             r.Auth.GetPassword(),
+			DockerJSONConfigFile: r.Auth.DockerJSON,
+			ConcurrentRequests:   r.MaxConcurrency,
+			RequestRate:          r.RequestRate,
+			RetryRequests:        r.Retry.Requests,
+			RetryDelay:           r.Retry.Delay,
+			Mirrors:              r.Mirrors,
+		}
+	}
+
+	return registryConfigs
+}
+
 func main() {
-	o, err := parseFlags()
+	o, yc, err := parseFlags()
 	if err != nil {
 		suicide(err, true)
 	}
 
-	if err := auth.BasicStore.LoadAll(o.BasicAuth); err != nil {
-		suicide(err, true)
+	if o.Login != "" || o.Logout != "" {
+		if err := loginOrLogout(o); err != nil {
+			suicide(err, true)
+		}
+
+		os.Exit(exitCode)
 	}
 
 	apiConfig := v1.Config{
 		DockerJSONConfigFile: o.DockerJSON,
 		ConcurrentRequests:   o.ConcurrentRequests,
+		RequestRate:          o.RequestRate,
 		WaitBetween:          o.WaitBetween,
 		TraceRequests:        o.TraceRequests,
 		RetryRequests:        o.RetryRequests,
@@ -121,6 +400,13 @@ func main() {
 		InsecureRegistryEx:   o.InsecureRegistryEx,
 		VerboseLogging:       o.Verbose,
 		DryRun:               o.DryRun,
+		Registries:           makeRegistryConfigs(yc),
+		OCILayoutDir:         o.OCILayout,
+		KubernetesSecretFile: o.KubeSecret,
+		NetrcFile:            o.Netrc,
+		BasicAuth:            o.BasicAuth,
+		CacheDir:             o.CacheDir,
+		CacheTTL:             o.CacheTTL,
 	}
 
 	if o.NoSSLVerify {
@@ -131,76 +417,441 @@ func main() {
 		suicide(err, true)
 	}
 
+	if o.Import != "" {
+		// bundle is imported into every push target defined in YAML config (if any), failed targets do not stop the rest
+		pushConfigs := makePushConfigs(o, yc)
+
+		failed := 0
+		for _, pushConfig := range pushConfigs {
+			if err := api.ImportTags(o.Import, pushConfig); err != nil {
+				log.Errorf("IMPORT %s => %s: %s", o.Import, pushConfig.Registry, err.Error())
+
+				failed++
+			}
+		}
+
+		if failed != 0 {
+			suicide(fmt.Errorf("failed to import bundle '%s' into %d of %d push targets", o.Import, failed, len(pushConfigs)), true)
+		}
+
+		os.Exit(exitCode)
+	}
+
+	if o.Apply != "" {
+		p, err := plan.Load(o.Apply)
+		if err != nil {
+			suicide(err, true)
+		}
+
+		summary := &pushSummary{}
+		api.Subscribe(summary.handle)
+
+		err = api.ApplyPlan(p, makePushConfigs(o, yc))
+
+		if perr := summary.print(o.OutputFormat); perr != nil {
+			suicide(perr, true)
+		}
+
+		if err != nil {
+			suicide(err, true)
+		}
+
+		os.Exit(exitCode)
+	}
+
+	var receiver *notification.Receiver
+	var triggers <-chan []string
+
+	if o.NotificationListen != "" {
+		receiver = notification.NewReceiver()
+		triggers = receiver.Triggers()
+
+		go func() {
+			suicide(receiver.Listen(o.NotificationListen), true)
+		}()
+	}
+
+	var scheduler *notification.Scheduler
+
+	if o.DaemonMode {
+		if o.PollingInterval <= 0 {
+			suicide(fmt.Errorf("polling interval must be positive: %v", o.PollingInterval), true)
+		}
+
+		// the same schedule for the whole daemon run, so triggers never postpone the next poll
+		scheduler = notification.NewScheduler(o.PollingInterval, triggers)
+		defer scheduler.Stop()
+	}
+
+	var triggeredRepositories []string
+
+	runOptions := o
+
 	for {
 		repositories := o.Positional.Repositories
 
 		if o.YAMLConfig != "" {
-			yc, err := config.LoadYAMLFile(o.YAMLConfig)
+			yc, err = config.LoadYAMLFile(o.YAMLConfig)
 			if err != nil {
 				suicide(err, !o.DaemonMode)
 			}
 
-			repositories = yc.Repositories
+			if yc != nil {
+				repositories = yc.Repositories
+
+				runOptions, err = reloadOptions(runOptions, yc)
+				if err != nil {
+					suicide(err, !o.DaemonMode)
+				}
+			}
+		}
+
+		if receiver != nil {
+			receiver.SetRefs(repositories)
+		}
+
+		if triggeredRepositories != nil {
+			repositories = triggeredRepositories
+		}
+
+		run(api, runOptions, repositories, makePushConfigs(runOptions, yc))
+
+		if !o.DaemonMode {
+			os.Exit(exitCode)
+		}
+
+		fmt.Printf("WAIT: %v\n-\n", o.PollingInterval)
+
+		triggeredRepositories = scheduler.Next()
+		if triggeredRepositories != nil {
+			fmt.Printf("TRIGGERED: %v\n-\n", triggeredRepositories)
+		}
+	}
+}
+
+// makePushConfig makes global push configuration from options (with YAML ones already applied, see applyYAMLConfig)
+func makePushConfig(o *Options) v1.PushConfig {
+	return v1.PushConfig{
+		Registry:      o.PushRegistry,
+		Prefix:        o.PushPrefix,
+		PathTemplate:  o.PushPathTemplate,
+		TagTemplate:   o.PushTagTemplate,
+		UpdateChanged: o.PushUpdate,
+		PathSeparator: o.PathSeparator,
+		Cleanup:       o.PushCleanup,
+		ProtectedTags: o.PushProtectedTags,
+	}
+}
+
+// makePushConfigs makes push configurations for all push targets defined in YAML config
+// (or a single push configuration from options, if there are no push targets defined)
+func makePushConfigs(o *Options, yc *config.Config) []v1.PushConfig {
+	return yc.PushConfigs(makePushConfig(o))
+}
+
+func run(api *v1.API, o *Options, repositories []string, pushConfigs []v1.PushConfig) {
+	collection, err := api.CollectTags(repositories...)
+	if err != nil && collection == nil {
+		suicide(err, !o.DaemonMode)
+
+		return
+	}
+
+	if err := printCollection(collection, o.OutputFormat); err != nil {
+		suicide(err, true)
+	}
+
+	// partial collection: tags of some repositories were not collected, but we still process the rest of them
+	if err != nil {
+		suicide(err, false)
+	}
+
+	if o.Export != "" {
+		if err := api.ExportTags(collection, o.Export); err != nil {
+			suicide(err, false)
+		}
+	}
+
+	if o.Prune {
+		pruneConfig := v1.PruneConfig{
+			KeepNewest:      o.PruneKeepNewest,
+			KeepUpstream:    o.PruneKeepUpstream,
+			RemoveLocalOnly: o.PruneLocalOnly,
+			RemoveChanged:   o.PruneChanged,
+		}
+
+		if err := api.PruneTags(collection, pruneConfig); err != nil {
+			suicide(err, false)
+		}
+	}
+
+	if o.Pull {
+		if err := api.PullTags(collection); err != nil {
+			suicide(err, false)
+		}
+	}
+
+	if o.Push && o.Plan != "" {
+		if err := writePlan(api, collection, pushConfigs, o.Plan); err != nil {
+			suicide(err, false)
+		}
+
+		return
+	}
+
+	if o.Push {
+		summary := &pushSummary{}
+		unsubscribe := api.Subscribe(summary.handle)
+
+		// conflicts (protected tags changed in push registry) do not stop us from pushing other tags
+		pushCollections, err := api.CollectPushTagsForTargets(collection, pushConfigs)
+		if err != nil && !errors.Is(err, v1.ErrTagConflict) {
+			unsubscribe()
+
+			suicide(err, false)
+
+			return
+		}
+
+		if perr := api.PushTagsToTargets(pushCollections, pushConfigs); perr != nil {
+			err = perr
+		}
+
+		unsubscribe()
+
+		if perr := summary.print(o.OutputFormat); perr != nil {
+			suicide(perr, true)
 		}
 
-		collection, err := api.CollectTags(repositories...)
 		if err != nil {
-			suicide(err, !o.DaemonMode)
+			suicide(err, false)
 		}
+	}
+}
 
-		const format = "%-12s %-45s %-15s %-25s %s:%s\n"
-		fmt.Printf("-\n")
-		fmt.Printf(format, "<STATE>", "<DIGEST>", "<(local) ID>", "<Created At>", "<IMAGE>", "<TAG>")
-		for _, ref := range collection.Refs() {
-			repo := collection.Repo(ref)
-			tags := collection.Tags(ref)
-
-			for _, tg := range tags {
-				fmt.Printf(
-					format,
-					tg.GetState(),
-					tg.GetShortDigest(),
-					tg.GetImageID(),
-					tg.GetCreatedString(),
-					repo.Name(),
-					tg.Name(),
-				)
+// writePlan makes sync plan for the collection and push configurations passed, writes it into the file and reports a summary
+func writePlan(api *v1.API, collection *collection.Collection, pushConfigs []v1.PushConfig, fileName string) error {
+	p, err := api.MakePlan(collection, pushConfigs)
+	if err != nil {
+		return err
+	}
+
+	if err := p.Save(fileName); err != nil {
+		return err
+	}
+
+	fmt.Printf(
+		"PLAN: %s (%d to push, %d to update, %d to skip, %d in conflict)\n",
+		fileName, p.Count(plan.Push), p.Count(plan.Update), p.Count(plan.Skip), p.Count(plan.Conflict),
+	)
+
+	return nil
+}
+
+func printCollection(collection *collection.Collection, format string) error {
+	switch format {
+	case "table":
+		printCollectionTable(collection)
+	case "json":
+		return printCollectionJSON(collection)
+	default:
+		return fmt.Errorf("Unknown output format: %s", format)
+	}
+
+	return nil
+}
+
+func printCollectionTable(collection *collection.Collection) {
+	const format = "%-12s %-45s %-15s %-25s %s:%s\n"
+	fmt.Printf("-\n")
+	fmt.Printf(format, "<STATE>", "<DIGEST>", "<(local) ID>", "<Created At>", "<IMAGE>", "<TAG>")
+	for _, ref := range collection.Refs() {
+		repo := collection.Repo(ref)
+		tags := collection.Tags(ref)
+
+		for _, tg := range tags {
+			fmt.Printf(
+				format,
+				tg.GetState(),
+				tg.GetShortDigest(),
+				tg.GetImageID(),
+				tg.GetCreatedString(),
+				repo.Name(),
+				tg.Name(),
+			)
+		}
+
+		if collection.Err(ref) != nil {
+			for _, tagName := range failedTagNames(repo) {
+				fmt.Printf(format, errorState, "-", "-", "-", repo.Name(), tagName)
 			}
 		}
-		fmt.Printf("-\n")
+	}
+	fmt.Printf("-\n")
+}
 
-		if o.Pull {
-			if err := api.PullTags(collection); err != nil {
-				suicide(err, false)
+// errorState is a state we show for repositories (and tags) we failed to collect information on
+const errorState = "ERROR"
+
+// failedTagNames gets tag names to show for repository we failed to collect tags for:
+// either tags specified explicitly or a wildcard ("*") meaning "all tags"
+func failedTagNames(repo *repository.Repository) []string {
+	if repo.HasTags() {
+		return repo.Tags()
+	}
+
+	return []string{"*"}
+}
+
+func printCollectionJSON(collection *collection.Collection) error {
+	type item struct {
+		State   string `json:"state"`
+		Digest  string `json:"digest"`
+		ImageID string `json:"image_id"`
+		Created string `json:"created"`
+		Image   string `json:"image"`
+		Tag     string `json:"tag"`
+		Error   string `json:"error,omitempty"`
+	}
+
+	items := make([]item, 0)
+	for _, ref := range collection.Refs() {
+		repo := collection.Repo(ref)
+
+		for _, tg := range collection.Tags(ref) {
+			items = append(items, item{
+				State:   tg.GetState(),
+				Digest:  tg.GetDigest(),
+				ImageID: tg.GetImageID(),
+				Created: tg.GetCreatedString(),
+				Image:   repo.Name(),
+				Tag:     tg.Name(),
+			})
+		}
+
+		if err := collection.Err(ref); err != nil {
+			for _, tagName := range failedTagNames(repo) {
+				items = append(items, item{
+					State: errorState,
+					Image: repo.Name(),
+					Tag:   tagName,
+					Error: err.Error(),
+				})
 			}
 		}
+	}
+
+	b, err := json.Marshal(items)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(b))
+
+	return nil
+}
 
-		if o.Push {
-			pushConfig := v1.PushConfig{
-				Registry:      o.PushRegistry,
-				Prefix:        o.PushPrefix,
-				PathTemplate:  o.PushPathTemplate,
-				TagTemplate:   o.PushTagTemplate,
-				UpdateChanged: o.PushUpdate,
-				PathSeparator: o.PathSeparator,
+// pushSummary collects results of pushes (verified, failed or in conflict) from API events to report them after the run
+type pushSummary struct {
+	events []v1.Event
+	mux    sync.Mutex
+}
+
+func (s *pushSummary) handle(e v1.Event) {
+	isVerified := e.Type == v1.EventPushVerified
+	isFailed := e.Type == v1.EventError && e.Destination != "" && e.Tag != ""
+
+	if !isVerified && !isFailed {
+		return
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.events = append(s.events, e)
+}
+
+// pushResult gets result of the push we show in summary
+func pushResult(e v1.Event) string {
+	if e.Type == v1.EventPushVerified {
+		return "VERIFIED"
+	}
+
+	if errors.Is(e.Err, v1.ErrTagConflict) {
+		return "CONFLICT"
+	}
+
+	return "FAILED"
+}
+
+// shortDigest gets shorter form of the digest (the same we show for tags), "-" if there is no digest
+func shortDigest(digest string) string {
+	if digest == "" {
+		return "-"
+	}
+
+	return tag.ShortDigest(digest)
+}
+
+// print prints summary of pushes (if there were any) in the output format passed
+func (s *pushSummary) print(format string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if len(s.events) == 0 {
+		return nil
+	}
+
+	switch format {
+	case "table":
+		const rowFormat = "%-12s %-45s %-45s %-15s %s => %s\n"
+		fmt.Printf("-\n")
+		fmt.Printf(rowFormat, "<RESULT>", "<SOURCE DIGEST>", "<PUSHED DIGEST>", "<PLATFORM>", "<SOURCE>", "<DESTINATION>")
+		for _, e := range s.events {
+			platform := e.Platform
+			if platform == "" {
+				platform = "-"
 			}
 
-			pushCollection, err := api.CollectPushTags(collection, pushConfig)
-			if err != nil {
-				suicide(err, false)
+			fmt.Printf(rowFormat, pushResult(e), shortDigest(e.Digest), shortDigest(e.DestinationDigest), platform, e.Ref, e.Destination)
+		}
+		fmt.Printf("-\n")
+	case "json":
+		type item struct {
+			Result            string `json:"result"`
+			Source            string `json:"source"`
+			SourceDigest      string `json:"source_digest"`
+			Destination       string `json:"destination"`
+			DestinationDigest string `json:"destination_digest,omitempty"`
+			Platform          string `json:"platform,omitempty"`
+			Error             string `json:"error,omitempty"`
+		}
+
+		items := make([]item, len(s.events))
+		for i, e := range s.events {
+			items[i] = item{
+				Result:            pushResult(e),
+				Source:            e.Ref,
+				SourceDigest:      e.Digest,
+				Destination:       e.Destination,
+				DestinationDigest: e.DestinationDigest,
+				Platform:          e.Platform,
 			}
 
-			if err := api.PushTags(pushCollection, pushConfig); err != nil {
-				suicide(err, false)
+			if e.Err != nil {
+				items[i].Error = e.Err.Error()
 			}
 		}
 
-		if !o.DaemonMode {
-			os.Exit(exitCode)
+		b, err := json.Marshal(items)
+		if err != nil {
+			return err
 		}
 
-		fmt.Printf("WAIT: %v\n-\n", o.PollingInterval)
-
-		time.Sleep(o.PollingInterval)
+		fmt.Println(string(b))
+	default:
+		return fmt.Errorf("Unknown output format: %s", format)
 	}
+
+	return nil
 }
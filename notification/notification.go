@@ -0,0 +1,193 @@
+// Package notification provides a receiver for Docker Distribution (registry) notifications.
+// It lets us react on image pushes instantly instead of waiting for the next polling cycle
+// (scheduler still keeps polling cycles regular, no matter how often we are triggered).
+package notification
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ivanilves/lstags/repository"
+)
+
+// MediaType is a media type Docker Distribution uses to send notification envelopes
+const MediaType = "application/vnd.docker.distribution.events.v1+json"
+
+// TriggerBuffer is a number of pending triggers we keep before we start to drop new ones
+var TriggerBuffer = 64
+
+// Envelope is a batch of events sent by Docker Distribution in a single request
+type Envelope struct {
+	Events []Event `json:"events"`
+}
+
+// Event is a single Docker Distribution notification event
+type Event struct {
+	ID        string    `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Action    string    `json:"action"`
+	Target    Target    `json:"target"`
+	Request   Request   `json:"request"`
+}
+
+// Target describes the object (manifest or blob) event is about
+type Target struct {
+	MediaType  string `json:"mediaType"`
+	Digest     string `json:"digest"`
+	Repository string `json:"repository"`
+	URL        string `json:"url"`
+	Tag        string `json:"tag"`
+}
+
+// Request describes the registry request that caused the event
+type Request struct {
+	ID     string `json:"id"`
+	Host   string `json:"host"`
+	Method string `json:"method"`
+}
+
+// Decode decodes notification envelope from the passed reader
+func Decode(r io.Reader) (*Envelope, error) {
+	var envelope Envelope
+
+	if err := json.NewDecoder(r).Decode(&envelope); err != nil {
+		return nil, err
+	}
+
+	return &envelope, nil
+}
+
+// IsTagPush tells us if event is about a tagged manifest being pushed
+// (pushes of blobs or manifests by digest are not interesting for us)
+func (e Event) IsTagPush() bool {
+	return e.Action == "push" && e.Target.Tag != ""
+}
+
+// Registry gets registry ADDR[:PORT] the event was sent from
+func (e Event) Registry() string {
+	if e.Request.Host != "" {
+		return e.Request.Host
+	}
+
+	u, err := url.Parse(e.Target.URL)
+	if err != nil {
+		return ""
+	}
+
+	return u.Host
+}
+
+// Matches tells us if event affects repository passed
+func (e Event) Matches(repo *repository.Repository) bool {
+	if !e.IsTagPush() {
+		return false
+	}
+
+	if e.Registry() != repo.Registry() {
+		return false
+	}
+
+	if e.Target.Repository != repo.Path() {
+		return false
+	}
+
+	return repo.MatchTag(e.Target.Tag)
+}
+
+// Match returns (deduplicated) references from the passed ones, affected by any of the events passed
+func Match(events []Event, refs []string) []string {
+	matched := make([]string, 0)
+
+	for _, ref := range refs {
+		repo, err := repository.ParseRef(ref)
+		if err != nil {
+			continue
+		}
+
+		for _, e := range events {
+			if e.Matches(repo) {
+				matched = append(matched, ref)
+				break
+			}
+		}
+	}
+
+	return matched
+}
+
+// Receiver is an HTTP handler receiving notifications and converting them into sync triggers
+type Receiver struct {
+	refs     []string
+	triggers chan []string
+	mux      sync.Mutex
+}
+
+// NewReceiver creates a new instance of Receiver
+func NewReceiver() *Receiver {
+	return &Receiver{triggers: make(chan []string, TriggerBuffer)}
+}
+
+// SetRefs sets repository references we match incoming events against
+func (rc *Receiver) SetRefs(refs []string) {
+	rc.mux.Lock()
+	defer rc.mux.Unlock()
+
+	rc.refs = refs
+}
+
+// Refs gets repository references we match incoming events against
+func (rc *Receiver) Refs() []string {
+	rc.mux.Lock()
+	defer rc.mux.Unlock()
+
+	return rc.refs
+}
+
+// Triggers returns channel to receive affected repository references from
+func (rc *Receiver) Triggers() <-chan []string {
+	return rc.triggers
+}
+
+// ServeHTTP implements http.Handler interface
+func (rc *Receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	envelope, err := Decode(r.Body)
+	if err != nil {
+		log.Warnf("[NOTIFICATION] Unable to decode envelope: %s", err.Error())
+
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	refs := Match(envelope.Events, rc.Refs())
+
+	log.Debugf("[NOTIFICATION] %d event(s) received, matched references: %+v", len(envelope.Events), refs)
+
+	if len(refs) != 0 {
+		select {
+		case rc.triggers <- refs:
+		default:
+			log.Warnf("[NOTIFICATION] Too many pending triggers, dropping: %+v", refs)
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Listen starts HTTP server receiving notifications on the address passed (path: "/notifications")
+func (rc *Receiver) Listen(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/notifications", rc)
+
+	return http.ListenAndServe(addr, mux)
+}
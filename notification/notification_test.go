@@ -0,0 +1,131 @@
+package notification
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ivanilves/lstags/repository"
+)
+
+const eventsFile = "../fixtures/notification/events.json"
+
+func loadEvents(t *testing.T) []Event {
+	f, err := os.Open(eventsFile)
+	if err != nil {
+		t.Fatalf("Unable to open '%s': %s", eventsFile, err.Error())
+	}
+	defer f.Close()
+
+	envelope, err := Decode(f)
+	if err != nil {
+		t.Fatalf("Unable to decode '%s': %s", eventsFile, err.Error())
+	}
+
+	return envelope.Events
+}
+
+func TestDecode(t *testing.T) {
+	assert := assert.New(t)
+
+	events := loadEvents(t)
+
+	assert.Equal(3, len(events))
+
+	assert.Equal("push", events[0].Action)
+	assert.Equal("coreos/flannel", events[0].Target.Repository)
+	assert.Equal("v0.10.0", events[0].Target.Tag)
+	assert.Equal("registry.company.io", events[0].Registry())
+
+	_, err := Decode(strings.NewReader("this is not JSON"))
+	assert.NotNil(err, "should fail to decode invalid envelope")
+}
+
+func TestIsTagPush(t *testing.T) {
+	assert := assert.New(t)
+
+	events := loadEvents(t)
+
+	assert.True(events[0].IsTagPush(), "tagged manifest push")
+	assert.False(events[1].IsTagPush(), "blob push")
+	assert.False(events[2].IsTagPush(), "manifest pull")
+}
+
+func TestRegistry_FromURL(t *testing.T) {
+	e := Event{Target: Target{URL: "http://localhost:5000/v2/foo/bar/manifests/latest"}}
+
+	assert.Equal(t, "localhost:5000", e.Registry())
+}
+
+func TestMatches(t *testing.T) {
+	var testCases = map[string]bool{
+		"registry.company.io/coreos/flannel":             true,
+		"registry.company.io/coreos/flannel~/^v0\\.10/":  true,
+		"registry.company.io/coreos/flannel:v0.10.0":     true,
+		"registry.company.io/coreos/flannel=v0.9,latest": false,
+		"registry.company.io/coreos/flannel~/^v1/":       false,
+		"registry.company.io/coreos/etcd":                false,
+		"quay.io/coreos/flannel":                         false,
+	}
+
+	assert := assert.New(t)
+
+	e := loadEvents(t)[0]
+
+	for ref, expected := range testCases {
+		repo, err := repository.ParseRef(ref)
+		if err != nil {
+			t.Fatalf("Unable to parse reference '%s': %s", ref, err.Error())
+		}
+
+		assert.Equal(expected, e.Matches(repo), ref)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	refs := []string{
+		"alpine",
+		"registry.company.io/coreos/flannel",
+		"registry.company.io/coreos/etcd",
+		"registry.company.io/library/alpine",
+		"!@#$%^&*",
+	}
+
+	assert.Equal(t, []string{"registry.company.io/coreos/flannel"}, Match(loadEvents(t), refs))
+}
+
+func TestReceiver(t *testing.T) {
+	assert := assert.New(t)
+
+	rc := NewReceiver()
+	rc.SetRefs([]string{"registry.company.io/coreos/flannel", "nginx"})
+
+	f, _ := os.Open(eventsFile)
+	defer f.Close()
+
+	w := httptest.NewRecorder()
+	rc.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/notifications", f))
+
+	assert.Equal(http.StatusOK, w.Code)
+
+	select {
+	case refs := <-rc.Triggers():
+		assert.Equal([]string{"registry.company.io/coreos/flannel"}, refs)
+	default:
+		t.Fatalf("Expected to receive a trigger")
+	}
+
+	w = httptest.NewRecorder()
+	rc.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/notifications", strings.NewReader("{{{")))
+
+	assert.Equal(http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	rc.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/notifications", nil))
+
+	assert.Equal(http.StatusMethodNotAllowed, w.Code)
+}
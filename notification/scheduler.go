@@ -0,0 +1,41 @@
+package notification
+
+import (
+	"time"
+)
+
+// Scheduler tells daemon when to run the next synchronization: on every poll (once per polling interval,
+// no matter how many times we were triggered in between) or on trigger (for repositories triggered only)
+// NB! If synchronization takes longer than polling interval, the next poll is due right after it.
+type Scheduler struct {
+	ticker   *time.Ticker
+	triggers <-chan []string
+}
+
+// NewScheduler creates a new Scheduler polling every interval passed (starting from now)
+// and receiving triggers from the channel passed (nil channel means we are never triggered)
+func NewScheduler(interval time.Duration, triggers <-chan []string) *Scheduler {
+	return &Scheduler{ticker: time.NewTicker(interval), triggers: triggers}
+}
+
+// Next waits for the next poll or trigger (whichever comes first) and returns repository references triggered,
+// nil is returned when it is time to poll all the repositories (polls due are never postponed by triggers)
+func (s *Scheduler) Next() []string {
+	select {
+	case <-s.ticker.C:
+		return nil
+	default:
+	}
+
+	select {
+	case <-s.ticker.C:
+		return nil
+	case refs := <-s.triggers:
+		return refs
+	}
+}
+
+// Stop stops scheduler, so no more polls will happen
+func (s *Scheduler) Stop() {
+	s.ticker.Stop()
+}
@@ -0,0 +1,69 @@
+package notification
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduler(t *testing.T) {
+	assert := assert.New(t)
+
+	triggers := make(chan []string, 1)
+
+	s := NewScheduler(time.Hour, triggers)
+	defer s.Stop()
+
+	triggers <- []string{"quay.io/coreos/flannel"}
+
+	assert.Equal([]string{"quay.io/coreos/flannel"}, s.Next())
+}
+
+func TestScheduler_SteadyTriggers(t *testing.T) {
+	assert := assert.New(t)
+
+	const interval = 50 * time.Millisecond
+
+	triggers := make(chan []string)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	// trigger us much more often than we poll
+	go func() {
+		for {
+			select {
+			case triggers <- []string{"quay.io/coreos/flannel"}:
+				time.Sleep(interval / 10)
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	s := NewScheduler(interval, triggers)
+	defer s.Stop()
+
+	var polls, triggered int
+
+	deadline := time.Now().Add(10 * interval)
+	for time.Now().Before(deadline) {
+		if s.Next() == nil {
+			polls++
+		} else {
+			triggered++
+		}
+	}
+
+	assert.True(polls >= 5, "should poll every interval, even if triggered all the time (polls: %d)", polls)
+	assert.True(triggered > polls, "should run on triggers too (triggered: %d, polls: %d)", triggered, polls)
+}
+
+func TestScheduler_NoTriggers(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewScheduler(10*time.Millisecond, nil)
+	defer s.Stop()
+
+	assert.Nil(s.Next())
+}
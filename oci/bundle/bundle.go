@@ -0,0 +1,218 @@
+// Package bundle provides "air-gapped" image bundles: single tar archives holding OCI image layout
+// (manifests, indexes, configs and deduplicated blobs) for a set of images, plus a manifest of refs.
+// Bundles are meant to carry images into isolated networks, where they are pushed to a target registry.
+package bundle
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/ivanilves/lstags/oci/layout"
+)
+
+// RefsFile is a name of the bundle file holding the manifest of refs
+const RefsFile = "refs.json"
+
+var blobNameEx = regexp.MustCompile(`^blobs/sha256/([a-f0-9]{64})$`)
+
+// Ref is a single image reference carried by the bundle
+type Ref struct {
+	// Name is an image reference name, e.g. "quay.io/coreos/flannel:v0.10.0"
+	Name      string `json:"name"`
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+	Created   string `json:"created,omitempty"`
+}
+
+// Descriptor gets layout descriptor for the ref
+func (ref Ref) Descriptor() layout.Descriptor {
+	return layout.Descriptor{MediaType: ref.MediaType, Digest: ref.Digest, Size: ref.Size}
+}
+
+// CreatedTime gets image creation time for the ref (zero time if unknown)
+func (ref Ref) CreatedTime() time.Time {
+	t, err := time.Parse(time.RFC3339, ref.Created)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return t
+}
+
+type manifest struct {
+	Refs []Ref `json:"refs"`
+}
+
+func writeFile(tw *tar.Writer, name string, data []byte) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: time.Now()}); err != nil {
+		return err
+	}
+
+	_, err := tw.Write(data)
+
+	return err
+}
+
+func writeBlob(tw *tar.Writer, l *layout.Layout, d layout.Descriptor) error {
+	r, err := l.ReadBlob(d.Digest)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	f, ok := r.(*os.File)
+	if !ok {
+		return fmt.Errorf("unable to stat blob: %s", d.Digest)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	name := "blobs/sha256/" + d.Digest[len("sha256:"):]
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: fi.Size(), ModTime: fi.ModTime()}); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tw, r)
+
+	return err
+}
+
+// Export writes images tagged with reference names passed from layout to the bundle (tar archive)
+func Export(w io.Writer, l *layout.Layout, refNames []string) ([]Ref, error) {
+	refs := make([]Ref, 0, len(refNames))
+	index := layout.Index{SchemaVersion: 2, Manifests: make([]layout.Descriptor, 0, len(refNames))}
+	blobs := make([]layout.Descriptor, 0)
+	seen := make(map[string]bool)
+
+	for _, refName := range refNames {
+		d, err := l.Resolve(refName)
+		if err != nil {
+			return nil, err
+		}
+
+		refs = append(refs, Ref{
+			Name:      refName,
+			MediaType: d.MediaType,
+			Digest:    d.Digest,
+			Size:      d.Size,
+			Created:   d.Annotations[layout.CreatedAnnotation],
+		})
+		index.Manifests = append(index.Manifests, *d)
+
+		err = l.Walk(*d, func(d layout.Descriptor) error {
+			if !seen[d.Digest] {
+				seen[d.Digest] = true
+				blobs = append(blobs, d)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tw := tar.NewWriter(w)
+
+	layoutData, _ := json.Marshal(struct {
+		Version string `json:"imageLayoutVersion"`
+	}{layout.ImageLayoutVersion})
+	indexData, _ := json.Marshal(index)
+	refsData, _ := json.MarshalIndent(manifest{Refs: refs}, "", "  ")
+
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{
+		{"oci-layout", layoutData},
+		{"index.json", indexData},
+		{RefsFile, refsData},
+	} {
+		if err := writeFile(tw, f.name, f.data); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, d := range blobs {
+		if err := writeBlob(tw, l, d); err != nil {
+			return nil, err
+		}
+	}
+
+	return refs, tw.Close()
+}
+
+// Import reads the bundle (tar archive) into layout passed, verifying all blobs and tagging all refs it carries
+func Import(r io.Reader, l *layout.Layout) ([]Ref, error) {
+	var m *manifest
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if hdr.Name == RefsFile {
+			data, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+
+			m = &manifest{}
+			if err := json.Unmarshal(data, m); err != nil {
+				return nil, fmt.Errorf("invalid bundle manifest of refs: %s", err.Error())
+			}
+
+			continue
+		}
+
+		match := blobNameEx.FindStringSubmatch(hdr.Name)
+		if match == nil {
+			continue // "oci-layout", "index.json" or something we do not care about
+		}
+
+		if err := l.WriteBlob("sha256:"+match[1], tr); err != nil {
+			return nil, err
+		}
+	}
+
+	if m == nil {
+		return nil, fmt.Errorf("no manifest of refs found in bundle: %s", RefsFile)
+	}
+
+	for _, ref := range m.Refs {
+		err := l.Walk(ref.Descriptor(), func(d layout.Descriptor) error {
+			if !l.HasBlob(d.Digest) {
+				return fmt.Errorf("blob missing in bundle for '%s': %s", ref.Name, d.Digest)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if err := l.Tag(ref.Name, ref.Descriptor(), ref.CreatedTime()); err != nil {
+			return nil, err
+		}
+	}
+
+	return m.Refs, nil
+}
@@ -0,0 +1,121 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ivanilves/lstags/oci/layout"
+)
+
+var refNames = []string{"quay.io/coreos/flannel:v0.10.0", "quay.io/coreos/flannel:v0.11.0", "alpine:3.7"}
+
+func tempLayout(t *testing.T) (*layout.Layout, func()) {
+	dir, err := ioutil.TempDir("", "lstags-bundle-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := layout.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return l, func() { os.RemoveAll(dir) }
+}
+
+func TestExportImport(t *testing.T) {
+	assert := assert.New(t)
+
+	src, err := layout.Open("../../fixtures/oci/layout")
+	assert.Nil(err)
+
+	var buf bytes.Buffer
+
+	refs, err := Export(&buf, src, refNames)
+	assert.Nil(err)
+	assert.Equal(len(refNames), len(refs))
+
+	blobCount := 0
+	tr := tar.NewReader(bytes.NewReader(buf.Bytes()))
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		if blobNameEx.MatchString(hdr.Name) {
+			blobCount++
+		}
+	}
+	// index + 2 manifests + 2 configs + 2 layers, "alpine:3.7" shares its manifest with the index
+	assert.Equal(7, blobCount, "blobs should be deduplicated")
+
+	dst, cleanup := tempLayout(t)
+	defer cleanup()
+
+	imported, err := Import(bytes.NewReader(buf.Bytes()), dst)
+	assert.Nil(err)
+	assert.Equal(refs, imported)
+
+	for _, refName := range refNames {
+		expected, err := src.Resolve(refName)
+		assert.Nil(err, refName)
+
+		d, err := dst.Resolve(refName)
+		assert.Nil(err, refName)
+		assert.Equal(expected.Digest, d.Digest, refName)
+		assert.Equal(layout.Created(*expected), layout.Created(*d), refName)
+	}
+}
+
+func TestExport_UnknownRef(t *testing.T) {
+	src, err := layout.Open("../../fixtures/oci/layout")
+	assert.Nil(t, err)
+
+	_, err = Export(ioutil.Discard, src, []string{"alpine:nonexistent"})
+	assert.NotNil(t, err)
+}
+
+func TestImport_Invalid(t *testing.T) {
+	assert := assert.New(t)
+
+	makeBundle := func(files map[string]string) []byte {
+		var buf bytes.Buffer
+
+		tw := tar.NewWriter(&buf)
+		for name, data := range files {
+			writeFile(tw, name, []byte(data))
+		}
+		tw.Close()
+
+		return buf.Bytes()
+	}
+
+	digest := layout.Digest([]byte("manifest"))
+
+	examples := map[string]map[string]string{
+		"no manifest of refs": {
+			"oci-layout": "{}",
+		},
+		"tampered blob": {
+			RefsFile: `{"refs":[]}`,
+			"blobs/sha256/" + digest[len("sha256:"):]: "tampered",
+		},
+		"missing blob": {
+			RefsFile: `{"refs":[{"name":"alpine:3.7","mediaType":"application/octet-stream","digest":"` + digest + `","size":8}]}`,
+		},
+	}
+
+	for name, files := range examples {
+		dst, cleanup := tempLayout(t)
+
+		_, err := Import(bytes.NewReader(makeBundle(files)), dst)
+		assert.NotNil(err, name)
+
+		cleanup()
+	}
+}
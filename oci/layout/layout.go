@@ -0,0 +1,446 @@
+// Package layout provides access to OCI image layout directories
+// (https://github.com/opencontainers/image-spec/blob/master/image-layout.md)
+// to be used as a local image store as an alternative to Docker daemon.
+package layout
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ivanilves/lstags/util/fix"
+)
+
+const (
+	// ImageLayoutVersion is a version of OCI image layout we create
+	ImageLayoutVersion = "1.0.0"
+	// RefNameAnnotation is an annotation to store image reference name (e.g. "quay.io/coreos/flannel:v0.10.0")
+	RefNameAnnotation = "org.opencontainers.image.ref.name"
+	// CreatedAnnotation is an annotation to store image creation time (RFC3339)
+	CreatedAnnotation = "org.opencontainers.image.created"
+)
+
+// MediaTypes of manifests and indexes (both OCI and Docker ones) we know how to handle
+const (
+	MediaTypeOCIManifest    = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex       = "application/vnd.oci.image.index.v1+json"
+	MediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerList     = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+// Platform describes platform image is built for
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// String gives us platform in a string form, e.g. "linux/arm64/v8"
+func (p Platform) String() string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+
+	return s
+}
+
+// Descriptor describes content (blob) addressed by its digest
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *Platform         `json:"platform,omitempty"`
+}
+
+// Index is an OCI image index (used both as "index.json" and as a content of index blobs)
+type Index struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+// Manifest is an OCI (or Docker v2) image manifest
+type Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Config        Descriptor   `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+}
+
+// ImageConfig is an image configuration (only the parts we care about: platform and labels)
+type ImageConfig struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+	Config       struct {
+		Labels map[string]string `json:"Labels,omitempty"`
+	} `json:"config"`
+}
+
+// Platform gets platform image is built for
+func (c ImageConfig) Platform() Platform {
+	return Platform{Architecture: c.Architecture, OS: c.OS, Variant: c.Variant}
+}
+
+// IsIndex tells us if media type passed is a type of index (manifest list)
+func IsIndex(mediaType string) bool {
+	return mediaType == MediaTypeOCIIndex || mediaType == MediaTypeDockerList
+}
+
+// IsManifest tells us if media type passed is a type of image manifest
+func IsManifest(mediaType string) bool {
+	return mediaType == MediaTypeOCIManifest || mediaType == MediaTypeDockerManifest
+}
+
+// Children gets descriptors of all blobs referenced by manifest or index passed
+func Children(mediaType string, data []byte) ([]Descriptor, error) {
+	if IsIndex(mediaType) {
+		var index Index
+		if err := json.Unmarshal(data, &index); err != nil {
+			return nil, err
+		}
+
+		return index.Manifests, nil
+	}
+
+	if IsManifest(mediaType) {
+		var manifest Manifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return nil, err
+		}
+
+		return append([]Descriptor{manifest.Config}, manifest.Layers...), nil
+	}
+
+	return nil, fmt.Errorf("unsupported manifest media type: %s", mediaType)
+}
+
+// Digest calculates sha256 digest of the data passed
+func Digest(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
+// Layout is an OCI image layout directory
+type Layout struct {
+	path string
+	mux  sync.Mutex
+}
+
+// Open opens OCI image layout directory, initializing it if it does not exist or is empty
+func Open(path string) (*Layout, error) {
+	l := &Layout{path: fix.Path(path)}
+
+	if err := os.MkdirAll(filepath.Join(l.path, "blobs", "sha256"), 0755); err != nil {
+		return nil, err
+	}
+
+	layoutFile := filepath.Join(l.path, "oci-layout")
+	if _, err := os.Stat(layoutFile); os.IsNotExist(err) {
+		data, _ := json.Marshal(struct {
+			Version string `json:"imageLayoutVersion"`
+		}{ImageLayoutVersion})
+
+		if err := ioutil.WriteFile(layoutFile, data, 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	indexFile := filepath.Join(l.path, "index.json")
+	if _, err := os.Stat(indexFile); os.IsNotExist(err) {
+		if err := l.writeIndex(&Index{SchemaVersion: 2, Manifests: []Descriptor{}}); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := l.readIndex(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Path gets path of the layout directory
+func (l *Layout) Path() string {
+	return l.path
+}
+
+func (l *Layout) blobPath(digest string) (string, error) {
+	parts := strings.SplitN(digest, ":", 2)
+	if len(parts) != 2 || parts[1] == "" || strings.ContainsAny(parts[1], "/\\.") {
+		return "", fmt.Errorf("invalid digest: %s", digest)
+	}
+
+	return filepath.Join(l.path, "blobs", parts[0], parts[1]), nil
+}
+
+func (l *Layout) readIndex() (*Index, error) {
+	data, err := ioutil.ReadFile(filepath.Join(l.path, "index.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var index Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, err
+	}
+
+	return &index, nil
+}
+
+func (l *Layout) writeIndex(index *Index) error {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(l.path, "index.json.tmp")
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filepath.Join(l.path, "index.json"))
+}
+
+// HasBlob tells us if blob with digest passed is present in the layout
+func (l *Layout) HasBlob(digest string) bool {
+	path, err := l.blobPath(digest)
+	if err != nil {
+		return false
+	}
+
+	_, err = os.Stat(path)
+
+	return err == nil
+}
+
+// ReadBlob opens blob with digest passed for reading
+func (l *Layout) ReadBlob(digest string) (io.ReadCloser, error) {
+	path, err := l.blobPath(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Open(path)
+}
+
+// ReadBlobBytes reads the whole blob with digest passed
+func (l *Layout) ReadBlobBytes(digest string) ([]byte, error) {
+	r, err := l.ReadBlob(digest)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
+
+// WriteBlob writes blob, verifying it matches the digest passed
+func (l *Layout) WriteBlob(digest string, r io.Reader) error {
+	path, err := l.blobPath(digest)
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(digest, "sha256:") {
+		return fmt.Errorf("unsupported digest algorithm: %s", digest)
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if actual := fmt.Sprintf("sha256:%x", h.Sum(nil)); actual != digest {
+		return fmt.Errorf("blob digest mismatch: %s (expected: %s)", actual, digest)
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// WriteBlobBytes writes blob from data passed, calculating its digest
+func (l *Layout) WriteBlobBytes(data []byte) (string, error) {
+	digest := Digest(data)
+
+	if l.HasBlob(digest) {
+		return digest, nil
+	}
+
+	return digest, l.WriteBlob(digest, strings.NewReader(string(data)))
+}
+
+// RefName makes a reference name from repository name and tag, e.g. "quay.io/coreos/flannel:v0.10.0"
+func RefName(name, tag string) string {
+	return name + ":" + tag
+}
+
+// SplitRefName splits reference name into repository name and tag
+func SplitRefName(refName string) (string, string, error) {
+	i := strings.LastIndex(refName, ":")
+	if i <= 0 || i == len(refName)-1 || strings.Contains(refName[i:], "/") {
+		return "", "", fmt.Errorf("invalid reference name: %s", refName)
+	}
+
+	return refName[:i], refName[i+1:], nil
+}
+
+// Tags gets descriptors of all images tagged for repository name passed (keyed by tag)
+func (l *Layout) Tags(name string) (map[string]Descriptor, error) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	index, err := l.readIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string]Descriptor)
+
+	for _, d := range index.Manifests {
+		n, t, err := SplitRefName(d.Annotations[RefNameAnnotation])
+		if err != nil || n != name {
+			continue
+		}
+
+		tags[t] = d
+	}
+
+	return tags, nil
+}
+
+// Resolve gets descriptor of the image tagged with the reference name passed
+func (l *Layout) Resolve(refName string) (*Descriptor, error) {
+	name, tag, err := SplitRefName(refName)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := l.Tags(name)
+	if err != nil {
+		return nil, err
+	}
+
+	d, defined := tags[tag]
+	if !defined {
+		return nil, errors.New("reference not found in OCI layout: " + refName)
+	}
+
+	return &d, nil
+}
+
+// Tag tags descriptor passed with reference name (replaces already existing tag, if any)
+func (l *Layout) Tag(refName string, d Descriptor, created time.Time) error {
+	if _, _, err := SplitRefName(refName); err != nil {
+		return err
+	}
+
+	if !l.HasBlob(d.Digest) {
+		return fmt.Errorf("could not tag absent blob: %s", d.Digest)
+	}
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	index, err := l.readIndex()
+	if err != nil {
+		return err
+	}
+
+	d.Annotations = map[string]string{RefNameAnnotation: refName}
+	if !created.IsZero() {
+		d.Annotations[CreatedAnnotation] = created.UTC().Format(time.RFC3339)
+	}
+
+	manifests := make([]Descriptor, 0, len(index.Manifests)+1)
+	for _, m := range index.Manifests {
+		if m.Annotations[RefNameAnnotation] != refName {
+			manifests = append(manifests, m)
+		}
+	}
+	index.Manifests = append(manifests, d)
+
+	return l.writeIndex(index)
+}
+
+// Untag removes reference name from the layout index (blobs are left in place)
+func (l *Layout) Untag(refName string) error {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	index, err := l.readIndex()
+	if err != nil {
+		return err
+	}
+
+	manifests := make([]Descriptor, 0, len(index.Manifests))
+	for _, m := range index.Manifests {
+		if m.Annotations[RefNameAnnotation] != refName {
+			manifests = append(manifests, m)
+		}
+	}
+	index.Manifests = manifests
+
+	return l.writeIndex(index)
+}
+
+// Created gets creation time stored for the descriptor passed (zero time if none stored)
+func Created(d Descriptor) time.Time {
+	t, err := time.Parse(time.RFC3339, d.Annotations[CreatedAnnotation])
+	if err != nil {
+		return time.Time{}
+	}
+
+	return t
+}
+
+// Walk calls function passed for the descriptor passed and for all descriptors it references (recursively),
+// every distinct digest is visited only once and children are always visited before their parents
+func (l *Layout) Walk(d Descriptor, walkFn func(Descriptor) error) error {
+	return l.walk(d, walkFn, make(map[string]bool))
+}
+
+func (l *Layout) walk(d Descriptor, walkFn func(Descriptor) error, visited map[string]bool) error {
+	if visited[d.Digest] {
+		return nil
+	}
+	visited[d.Digest] = true
+
+	if IsIndex(d.MediaType) || IsManifest(d.MediaType) {
+		data, err := l.ReadBlobBytes(d.Digest)
+		if err != nil {
+			return err
+		}
+
+		children, err := Children(d.MediaType, data)
+		if err != nil {
+			return err
+		}
+
+		for _, child := range children {
+			if err := l.walk(child, walkFn, visited); err != nil {
+				return err
+			}
+		}
+	}
+
+	return walkFn(d)
+}
@@ -0,0 +1,197 @@
+package layout
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const fixtureDir = "../../fixtures/oci/layout"
+
+func TestOpen_Fixture(t *testing.T) {
+	assert := assert.New(t)
+
+	l, err := Open(fixtureDir)
+	assert.Nil(err)
+
+	tags, err := l.Tags("quay.io/coreos/flannel")
+	assert.Nil(err)
+	assert.Equal(2, len(tags))
+	assert.Equal(MediaTypeOCIManifest, tags["v0.10.0"].MediaType)
+	assert.Equal(MediaTypeOCIIndex, tags["v0.11.0"].MediaType)
+	assert.Equal(int64(1516708800), Created(tags["v0.10.0"]).Unix())
+
+	tags, err = l.Tags("alpine")
+	assert.Nil(err)
+	assert.Equal(1, len(tags))
+
+	tags, err = l.Tags("nonexistent")
+	assert.Nil(err)
+	assert.Equal(0, len(tags))
+}
+
+func TestChildren_Fixture(t *testing.T) {
+	assert := assert.New(t)
+
+	l, err := Open(fixtureDir)
+	assert.Nil(err)
+
+	d, err := l.Resolve("quay.io/coreos/flannel:v0.11.0")
+	assert.Nil(err)
+
+	data, err := l.ReadBlobBytes(d.Digest)
+	assert.Nil(err)
+	assert.Equal(d.Digest, Digest(data))
+
+	manifests, err := Children(d.MediaType, data)
+	assert.Nil(err)
+	assert.Equal(2, len(manifests))
+
+	for _, m := range manifests {
+		assert.True(l.HasBlob(m.Digest))
+
+		data, err := l.ReadBlobBytes(m.Digest)
+		assert.Nil(err)
+
+		blobs, err := Children(m.MediaType, data)
+		assert.Nil(err)
+		assert.Equal(2, len(blobs))
+
+		for _, b := range blobs {
+			assert.True(l.HasBlob(b.Digest))
+		}
+	}
+
+	_, err = Children("application/vnd.docker.distribution.manifest.v1+prettyjws", data)
+	assert.NotNil(err)
+}
+
+func TestOpen_Empty(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "lstags-oci-layout-")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	l, err := Open(filepath.Join(dir, "store"))
+	assert.Nil(err)
+
+	for _, name := range []string{"oci-layout", "index.json", "blobs/sha256"} {
+		_, err := os.Stat(filepath.Join(l.Path(), name))
+		assert.Nil(err, name)
+	}
+}
+
+func TestWriteBlobAndTag(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "lstags-oci-layout-")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	l, err := Open(dir)
+	assert.Nil(err)
+
+	digest, err := l.WriteBlobBytes([]byte("blob data"))
+	assert.Nil(err)
+	assert.True(l.HasBlob(digest))
+
+	err = l.WriteBlob(Digest([]byte("other data")), strings.NewReader("tampered data"))
+	assert.NotNil(err)
+	assert.False(l.HasBlob(Digest([]byte("other data"))))
+
+	d := Descriptor{MediaType: MediaTypeOCIManifest, Digest: digest, Size: 9}
+	created := time.Unix(1500000000, 0)
+
+	assert.Nil(l.Tag("registry.company.io/repo:v1", d, created))
+	assert.Nil(l.Tag("registry.company.io/repo:v1", d, created))
+	assert.Nil(l.Tag("registry.company.io/repo:v2", d, time.Time{}))
+	assert.NotNil(l.Tag("registry.company.io/repo:v3", Descriptor{Digest: Digest([]byte("absent"))}, created))
+	assert.NotNil(l.Tag("registry.company.io/repo", d, created))
+
+	tags, err := l.Tags("registry.company.io/repo")
+	assert.Nil(err)
+	assert.Equal(2, len(tags))
+	assert.Equal(created.Unix(), Created(tags["v1"]).Unix())
+	assert.True(Created(tags["v2"]).IsZero())
+
+	assert.Nil(l.Untag("registry.company.io/repo:v1"))
+
+	_, err = l.Resolve("registry.company.io/repo:v1")
+	assert.NotNil(err)
+	_, err = l.Resolve("registry.company.io/repo:v2")
+	assert.Nil(err)
+}
+
+func TestSplitRefName(t *testing.T) {
+	assert := assert.New(t)
+
+	examples := map[string][]string{
+		"alpine:3.7":                         {"alpine", "3.7"},
+		"quay.io/coreos/flannel:v0.10.0":     {"quay.io/coreos/flannel", "v0.10.0"},
+		"localhost:5000/library/alpine:edge": {"localhost:5000/library/alpine", "edge"},
+	}
+
+	for refName, expected := range examples {
+		name, tag, err := SplitRefName(refName)
+
+		assert.Nil(err, refName)
+		assert.Equal(expected, []string{name, tag}, refName)
+	}
+
+	for _, refName := range []string{"alpine", "alpine:", ":latest", "localhost:5000/alpine"} {
+		_, _, err := SplitRefName(refName)
+
+		assert.NotNil(err, refName)
+	}
+}
+
+func TestPlatformString(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("linux/amd64", Platform{OS: "linux", Architecture: "amd64"}.String())
+	assert.Equal("linux/arm64/v8", Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}.String())
+}
+
+func TestImageConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	var c ImageConfig
+	assert.Nil(json.Unmarshal([]byte(`{"architecture":"arm","os":"linux","variant":"v7","config":{"Labels":{"team":"infra"}}}`), &c))
+
+	assert.Equal("linux/arm/v7", c.Platform().String())
+	assert.Equal(map[string]string{"team": "infra"}, c.Config.Labels)
+}
+
+func TestWalk_Fixture(t *testing.T) {
+	assert := assert.New(t)
+
+	l, err := Open(fixtureDir)
+	assert.Nil(err)
+
+	d, err := l.Resolve("quay.io/coreos/flannel:v0.11.0")
+	assert.Nil(err)
+
+	visited := make([]string, 0)
+	err = l.Walk(*d, func(d Descriptor) error {
+		assert.True(l.HasBlob(d.Digest), d.Digest)
+
+		visited = append(visited, d.Digest)
+
+		return nil
+	})
+	assert.Nil(err)
+
+	// index + 2 manifests + 2 configs + 2 layers
+	assert.Equal(7, len(visited))
+	assert.Equal(d.Digest, visited[len(visited)-1], "parent should be visited after its children")
+
+	err = l.Walk(Descriptor{MediaType: MediaTypeOCIManifest, Digest: Digest([]byte("absent"))}, func(Descriptor) error { return nil })
+	assert.NotNil(err)
+}
@@ -9,8 +9,10 @@ import (
 	"strings"
 )
 
-// InsecureRegistryEx contains a regex string to match insecure (non-HTTPS) registries
-var InsecureRegistryEx = `^(127\..*|::1|localhost)(:[0-9]+)?$`
+// DefaultInsecureRegistryEx contains a default regex string to match insecure (non-HTTPS) registries
+const DefaultInsecureRegistryEx = `^(127\..*|::1|localhost)(:[0-9]+)?$`
+
+var defaultInsecureRegistryRE = regexp.MustCompile(DefaultInsecureRegistryEx)
 
 // RefSpec is the description of a valid Docker repository specification
 const RefSpec = "[REGISTRY[:PORT]/]REPOSITORY[:TAG|=TAG1,TAG2,TAGn|~/FILTER_REGEXP/]"
@@ -133,10 +135,21 @@ func (r *Repository) Filter() string {
 }
 
 // IsSecure tells us if we use secure (HTTPS) connection for this registry/repository
+// (by default, i.e. if registry does not match DefaultInsecureRegistryEx)
 func (r *Repository) IsSecure() bool {
 	return r.isSecure
 }
 
+// IsSecureWith tells us if we use secure (HTTPS) connection for this registry/repository,
+// if insecure registries are matched by the expression passed (nil means "use default one")
+func (r *Repository) IsSecureWith(insecureRegistryRE *regexp.Regexp) bool {
+	if insecureRegistryRE == nil {
+		return r.IsSecure()
+	}
+
+	return !insecureRegistryRE.MatchString(r.registry)
+}
+
 // WebSchema tells us we use "http://" or "https://" to connect to this registry/repository
 func (r *Repository) WebSchema() string {
 	if !r.IsSecure() {
@@ -178,6 +191,39 @@ func (r *Repository) doesTagMatchesFilter(tag string) bool {
 	return r.filterRE.MatchString(tag)
 }
 
+// FilterCaptures gets capture groups of /FILTER/ regexp matched against the tag passed:
+// all of them (whole match goes first, like with regexp.FindStringSubmatch) and named ones
+// (nils are returned if repository has no filter or tag does not match it)
+func (r *Repository) FilterCaptures(tag string) ([]string, map[string]string) {
+	if !r.HasFilter() {
+		return nil, nil
+	}
+
+	captures := r.filterRE.FindStringSubmatch(tag)
+	if captures == nil {
+		return nil, nil
+	}
+
+	namedCaptures := make(map[string]string)
+	for i, name := range r.filterRE.SubexpNames() {
+		if name != "" {
+			namedCaptures[name] = captures[i]
+		}
+	}
+
+	return captures, namedCaptures
+}
+
+// FilterSubexpNames gets names of /FILTER/ regexp capture groups (like regexp.SubexpNames does:
+// whole match goes first, unnamed groups have empty names), nil is returned if repository has no filter
+func (r *Repository) FilterSubexpNames() []string {
+	if !r.HasFilter() {
+		return nil
+	}
+
+	return r.filterRE.SubexpNames()
+}
+
 // PushPrefix generates prefix path for repository in a "push" registry
 func (r *Repository) PushPrefix() string {
 	allParts := strings.Split(r.Registry(), ":")
@@ -276,7 +322,10 @@ func ParseRef(ref string) (*Repository, error) {
 	case refWithFilter:
 		refParts := strings.Split(fullRef, "~")
 		fullRepo = refParts[0]
-		filterRE = regexp.MustCompile(refParts[1][1 : len(refParts[1])-1])
+		filterRE, err = regexp.Compile(refParts[1][1 : len(refParts[1])-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter in repository reference '%s': %s", ref, err.Error())
+		}
 	default:
 		return nil, fmt.Errorf("unknown repository reference specification: %s", spec)
 	}
@@ -287,7 +336,7 @@ func ParseRef(ref string) (*Repository, error) {
 		fullRepo: fullRepo,
 		repoTags: repoTags,
 		filterRE: filterRE,
-		isSecure: !regexp.MustCompile(InsecureRegistryEx).MatchString(registry),
+		isSecure: !defaultInsecureRegistryRE.MatchString(registry),
 		isSingle: isSingle,
 	}, nil
 }
@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"regexp"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -31,6 +32,7 @@ func TestParseRef(t *testing.T) {
 		"registry.org/some/repo=lat!st,stable":            {"", true, "", "", "", []string{}, "", "", false, false},
 		"registry.org/some/repo~/^v1/":                    {"registry.org", false, "registry.org/some/repo", "registry.org/some/repo", "some/repo", []string{}, "^v1", "https://", false, true},
 		"registry.org/some/repo~|^v1|":                    {"", true, "", "", "", []string{}, "", "", false, false},
+		"registry.org/some/repo~/^v[1/":                   {"", true, "", "", "", []string{}, "", "", false, false},
 		"ivanilves/lstags":                                {"registry.hub.docker.com", true, "registry.hub.docker.com/ivanilves/lstags", "ivanilves/lstags", "ivanilves/lstags", []string{}, ".*", "https://", false, true},
 		"quay.io/coreos/flannel:v0.6.1-ppc64le":           {"quay.io", false, "quay.io/coreos/flannel", "quay.io/coreos/flannel", "coreos/flannel", []string{"v0.6.1-ppc64le"}, "", "https://", true, true},
 		"docker.io/bitnami/zookeeper:3.6.1-debian-10-r37": {"registry.hub.docker.com", true, "registry.hub.docker.com/docker.io/bitnami/zookeeper", "docker.io/bitnami/zookeeper", "bitnami/zookeeper", []string{"3.6.1-debian-10-r37"}, "", "https://", true, true},
@@ -185,6 +187,38 @@ func TestRepositoryMatchTag(t *testing.T) {
 	}
 }
 
+func TestRepositoryFilterCaptures(t *testing.T) {
+	assert := assert.New(t)
+
+	repo, _ := ParseRef(`quay.io/coreos/flannel~/^v(?P<major>[0-9]+)\.([0-9]+)\./`)
+
+	captures, namedCaptures := repo.FilterCaptures("v0.10.0")
+	assert.Equal([]string{"v0.10.", "0", "10"}, captures)
+	assert.Equal(map[string]string{"major": "0"}, namedCaptures)
+
+	captures, namedCaptures = repo.FilterCaptures("latest")
+	assert.Nil(captures)
+	assert.Nil(namedCaptures)
+
+	repo, _ = ParseRef("quay.io/coreos/flannel:v0.10.0")
+
+	captures, _ = repo.FilterCaptures("v0.10.0")
+	assert.Nil(captures, "should give nothing for repository with no filter")
+}
+
+func TestRepositoryFilterSubexpNames(t *testing.T) {
+	assert := assert.New(t)
+
+	repo, _ := ParseRef(`quay.io/coreos/flannel~/^v(?P<major>[0-9]+)\.([0-9]+)\./`)
+	assert.Equal([]string{"", "major", ""}, repo.FilterSubexpNames())
+
+	repo, _ = ParseRef("quay.io/coreos/flannel")
+	assert.Equal([]string{""}, repo.FilterSubexpNames(), "should give whole match only for repository with default filter")
+
+	repo, _ = ParseRef("quay.io/coreos/flannel:v0.10.0")
+	assert.Nil(repo.FilterSubexpNames(), "should give nothing for repository with no filter")
+}
+
 func TestRepositoryPushPrefix(t *testing.T) {
 	testCases := map[string]string{
 		"alpine":                                  "/registry/hub/docker/com/",
@@ -235,3 +269,18 @@ func TestParseRefs(t *testing.T) {
 		assert.Equal(refs, expected.refs, "passed references should be the same as parsed ones")
 	}
 }
+
+func TestIsSecureWith(t *testing.T) {
+	assert := assert.New(t)
+
+	local, _ := ParseRef("localhost:5000/alpine")
+	company, _ := ParseRef("registry.company.io/alpine")
+
+	assert.False(local.IsSecureWith(nil))
+	assert.True(company.IsSecureWith(nil))
+
+	ex := regexp.MustCompile(`^registry\.company\.io$`)
+
+	assert.True(local.IsSecureWith(ex))
+	assert.False(company.IsSecureWith(ex))
+}
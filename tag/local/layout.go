@@ -0,0 +1,59 @@
+package local
+
+import (
+	"encoding/json"
+
+	"github.com/ivanilves/lstags/oci/layout"
+	"github.com/ivanilves/lstags/repository"
+	"github.com/ivanilves/lstags/tag"
+)
+
+// FetchLayoutTags looks up Docker repo tags and digests present in the OCI image layout directory
+func FetchLayoutTags(repo *repository.Repository, l *layout.Layout) (map[string]*tag.Tag, error) {
+	descriptors, err := l.Tags(repo.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string]*tag.Tag)
+
+	for tagName, d := range descriptors {
+		if !repo.MatchTag(tagName) {
+			continue
+		}
+
+		tagOptions := tag.Options{Digest: d.Digest, ImageID: layoutImageID(l, d)}
+		if created := layout.Created(d); !created.IsZero() {
+			tagOptions.Created = created.Unix()
+		}
+
+		tg, err := tag.New(tagName, tagOptions)
+		if err != nil {
+			return nil, err
+		}
+
+		tags[tg.Name()] = tg
+	}
+
+	return tags, nil
+}
+
+// layoutImageID gets image ID (digest of the image config) for the image manifest descriptor passed,
+// falls back to the descriptor digest itself for indexes and unreadable manifests
+func layoutImageID(l *layout.Layout, d layout.Descriptor) string {
+	if !layout.IsManifest(d.MediaType) {
+		return d.Digest
+	}
+
+	data, err := l.ReadBlobBytes(d.Digest)
+	if err != nil {
+		return d.Digest
+	}
+
+	var manifest layout.Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil || manifest.Config.Digest == "" {
+		return d.Digest
+	}
+
+	return manifest.Config.Digest
+}
@@ -0,0 +1,50 @@
+package local
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ivanilves/lstags/oci/layout"
+	"github.com/ivanilves/lstags/repository"
+)
+
+func TestFetchLayoutTags(t *testing.T) {
+	assert := assert.New(t)
+
+	l, err := layout.Open("../../fixtures/oci/layout")
+	assert.Nil(err)
+
+	examples := map[string]map[string]string{
+		"quay.io/coreos/flannel": {
+			"v0.10.0": "c5b1d63604f2",
+			"v0.11.0": "0e2a986782bd",
+		},
+		"quay.io/coreos/flannel:v0.10.0": {
+			"v0.10.0": "c5b1d63604f2",
+		},
+		"alpine~/^3/": {
+			"3.7": "04c343465ae7",
+		},
+		"nginx": {},
+	}
+
+	for ref, expectedImageIDs := range examples {
+		repo, err := repository.ParseRef(ref)
+		assert.Nil(err, ref)
+
+		tags, err := FetchLayoutTags(repo, l)
+		assert.Nil(err, ref)
+		assert.Equal(len(expectedImageIDs), len(tags), ref)
+
+		for name, imageID := range expectedImageIDs {
+			tg, defined := tags[name]
+			if !assert.True(defined, ref+":"+name) {
+				continue
+			}
+
+			assert.Equal(imageID, tg.GetImageID(), ref+":"+name)
+			assert.NotEqual(int64(0), tg.GetCreated(), ref+":"+name)
+		}
+	}
+}
@@ -1,63 +1,173 @@
 package remote
 
 import (
-	"strings"
+	"errors"
+	"regexp"
 	"time"
 
+	log "github.com/sirupsen/logrus"
+
 	"github.com/ivanilves/lstags/repository"
 	"github.com/ivanilves/lstags/tag"
-	"github.com/ivanilves/lstags/tag/manifest"
+	"github.com/ivanilves/lstags/util/pool"
+	"github.com/ivanilves/lstags/util/ratelimit"
 
 	"github.com/ivanilves/lstags/api/v1/registry/client"
+	basicstore "github.com/ivanilves/lstags/api/v1/registry/client/auth/basic/store"
+	"github.com/ivanilves/lstags/api/v1/registry/client/cache"
+	"github.com/ivanilves/lstags/api/v1/registry/client/request"
 )
 
-// ConcurrentRequests defines maximum number of concurrent requests we could maintain against the registry
-var ConcurrentRequests = 32
+// DefaultConcurrentRequests defines default maximum number of concurrent requests we could maintain against the registry
+const DefaultConcurrentRequests = 32
+
+// DefaultRetryDelay is a default delay between retries of failed requests to the registry
+const DefaultRetryDelay = 5 * time.Second
+
+// Config holds configuration of the remote tag fetcher
+type Config struct {
+	// ConcurrentRequests defines maximum number of concurrent requests we could maintain against the registry
+	ConcurrentRequests int
+	// WaitBetween defines how much we will wait before retrying login with less permissions
+	WaitBetween time.Duration
+	// RetryRequests is a number of retries we do in case of request failure
+	RetryRequests int
+	// RetryDelay is a delay between retries of failed requests to the registry
+	RetryDelay time.Duration
+	// TraceRequests defines if we should print out HTTP request URLs and response headers/bodies
+	TraceRequests bool
+	// InsecureRegistryEx is a regex string to match insecure (non-HTTPS) registries (repository default, if empty)
+	InsecureRegistryEx string
+	// RegistryConfigs are registry-specific client configurations (keyed by registry ADDR[:PORT]),
+	// their non-zero values take precedence over the general ones defined above
+	RegistryConfigs map[string]client.Config
+	// Limiters hold per-registry limiters for registries having no specific configuration,
+	// so every registry host gets its own request rate and concurrency limits
+	Limiters *ratelimit.Set
+	// Tokens hold authentication tokens obtained (shared by all clients created)
+	Tokens *cache.Token
+	// BasicStore holds explicitly set BASIC auth logins (could be nil)
+	BasicStore *basicstore.Store
+	// OnRetry is called before every retry of the failed registry request (could be nil)
+	OnRetry request.RetryFunc
+	// Metadata is a persistent cache of tag metadata (could be nil)
+	Metadata *cache.Metadata
+}
+
+// Remote fetches tags from remote registries, holding all the configuration and caches needed to do it
+type Remote struct {
+	config             Config
+	insecureRegistryRE *regexp.Regexp
+}
+
+// New creates a new remote tag fetcher instance
+func New(config Config) (*Remote, error) {
+	if config.ConcurrentRequests == 0 {
+		config.ConcurrentRequests = DefaultConcurrentRequests
+	}
+	if config.RetryDelay == 0 {
+		config.RetryDelay = DefaultRetryDelay
+	}
+	if config.RegistryConfigs == nil {
+		config.RegistryConfigs = make(map[string]client.Config)
+	}
+	if config.Limiters == nil {
+		config.Limiters = ratelimit.NewSet(0, config.ConcurrentRequests)
+	}
+	if config.Tokens == nil {
+		config.Tokens = cache.NewToken(config.WaitBetween)
+	}
+
+	var insecureRegistryRE *regexp.Regexp
+	if config.InsecureRegistryEx != "" {
+		var err error
 
-// WaitBetween defines how much we will wait between batches of requests
-var WaitBetween time.Duration
+		insecureRegistryRE, err = regexp.Compile(config.InsecureRegistryEx)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-// RetryRequests is a number of retries we do in case of request failure
-var RetryRequests = 0
+	return &Remote{config: config, insecureRegistryRE: insecureRegistryRE}, nil
+}
 
-// RetryDelay is a delay between retries of failed requests to the registry
-var RetryDelay = 5 * time.Second
+func (r *Remote) getClientConfig(repo *repository.Repository) client.Config {
+	config := client.Config{
+		ConcurrentRequests: r.config.ConcurrentRequests,
+		WaitBetween:        r.config.WaitBetween,
+		RetryRequests:      r.config.RetryRequests,
+		RetryDelay:         r.config.RetryDelay,
+		TraceRequests:      r.config.TraceRequests,
+		IsInsecure:         !repo.IsSecureWith(r.insecureRegistryRE),
+		Tokens:             r.config.Tokens,
+		BasicStore:         r.config.BasicStore,
+		OnRetry:            r.config.OnRetry,
+		Metadata:           r.config.Metadata,
+	}
 
-// TraceRequests defines if we should print out HTTP request URLs and response headers/bodies
-var TraceRequests = false
+	rc, defined := r.config.RegistryConfigs[repo.Registry()]
+	if !defined {
+		config.Limiter = r.getLimiter(repo.Registry())
 
-func calculateBatchSteps(count, limit int) (int, int) {
-	total := count / limit
-	remain := count % limit
+		return config
+	}
 
-	if remain == 0 {
-		return total, 0
+	if rc.ConcurrentRequests != 0 {
+		config.ConcurrentRequests = rc.ConcurrentRequests
+	}
+	if rc.RetryRequests != 0 {
+		config.RetryRequests = rc.RetryRequests
+	}
+	if rc.RetryDelay != 0 {
+		config.RetryDelay = rc.RetryDelay
 	}
 
-	return total + 1, remain
+	config.IsInsecure = rc.IsInsecure
+	config.TLSConfig = rc.TLSConfig
+	config.Limiter = r.getLimiter(repo.Registry())
+	config.Mirrors = rc.Mirrors
+
+	return config
 }
 
-func calculateBatchStepSize(stepNumber, stepsTotal, remain, limit int) int {
-	if remain != 0 && stepNumber == stepsTotal {
-		return remain
+// getLimiter gets limiter for the registry passed: either registry-specific or the one created on demand
+func (r *Remote) getLimiter(registry string) *ratelimit.Limiter {
+	if rc, defined := r.config.RegistryConfigs[registry]; defined && rc.Limiter != nil {
+		return rc.Limiter
 	}
 
-	return limit
+	return r.config.Limiters.Get(registry)
 }
 
 // FetchTags looks up Docker repoPath tags present on remote Docker registry
-func FetchTags(repo *repository.Repository, username, password string) (map[string]*tag.Tag, error) {
-	cli, err := client.New(
-		repo.Registry(),
-		client.Config{
-			ConcurrentRequests: ConcurrentRequests,
-			WaitBetween:        WaitBetween,
-			RetryRequests:      RetryRequests,
-			RetryDelay:         RetryDelay,
-			TraceRequests:      TraceRequests,
-			IsInsecure:         !repo.IsSecure(),
-		},
-	)
+// (if registry has mirrors configured, they are tried first)
+func (r *Remote) FetchTags(repo *repository.Repository, username, password string) (map[string]*tag.Tag, error) {
+	config := r.getClientConfig(repo)
+
+	var tags map[string]*tag.Tag
+	var err error
+
+	for _, mirror := range config.Mirrors {
+		mirrorConfig := config
+		mirrorConfig.Limiter = r.getLimiter(mirror)
+		tags, err = fetchTags(mirror, repo, mirrorConfig, username, password)
+		if err == nil {
+			return tags, nil
+		}
+
+		log.Warnf("Unable to fetch tags of '%s' from mirror '%s': %s", repo.Ref(), mirror, err.Error())
+	}
+
+	return fetchTags(repo.Registry(), repo, config, username, password)
+}
+
+func fetchTags(
+	endpoint string,
+	repo *repository.Repository,
+	config client.Config,
+	username, password string,
+) (map[string]*tag.Tag, error) {
+	cli, err := client.New(endpoint, config)
 	if err != nil {
 		return nil, err
 	}
@@ -78,56 +188,53 @@ func FetchTags(repo *repository.Repository, username, password string) (map[stri
 		}
 	}
 
-	tags := make(map[string]*tag.Tag)
-
-	batchSteps, batchRemain := calculateBatchSteps(len(tagNames), ConcurrentRequests)
+	responses := make([]*tag.Tag, len(tagNames))
 
-	var stepSize int
-	var tagIndex = 0
-	for b := 1; b <= batchSteps; b++ {
-		stepSize = calculateBatchStepSize(b, batchSteps, batchRemain, ConcurrentRequests)
+	jobs := make([]pool.Job, len(tagNames))
+	for i, tagName := range tagNames {
+		i, tagName := i, tagName
 
-		type response struct {
-			Tag *tag.Tag
-			Err error
-		}
+		jobs[i] = func() error {
+			tg, err := cli.Tag(repo.Path(), tagName, allTagManifests[tagName])
+			if err != nil {
+				if errors.Is(err, request.ErrNotFound) {
+					return nil
+				}
 
-		rc := make(chan response, stepSize)
+				return err
+			}
 
-		for s := 1; s <= stepSize; s++ {
-			go func(
-				repo *repository.Repository,
-				tagName string,
-				tagManifest manifest.Manifest,
-				rc chan response,
-			) {
-				tg, err := cli.Tag(repo.Path(), tagName, tagManifest)
+			responses[i] = tg
 
-				rc <- response{Tag: tg, Err: err}
-			}(repo, tagNames[tagIndex], allTagManifests[tagNames[tagIndex]], rc)
+			return nil
+		}
+	}
 
-			tagIndex++
+	if err := pool.New(config.ConcurrentRequests).Run(jobs); err != nil {
+		return nil, err
+	}
 
-			time.Sleep(WaitBetween)
+	tags := make(map[string]*tag.Tag)
+	for _, tg := range responses {
+		if tg != nil {
+			tags[tg.Name()] = tg
 		}
+	}
 
-		var i = 0
-		for r := range rc {
-			if r.Err != nil {
-				if !strings.Contains(r.Err.Error(), "404 Not Found") {
-					return nil, r.Err
-				}
-			} else {
-				tags[r.Tag.Name()] = r.Tag
-			}
+	return tags, nil
+}
 
-			i++
+// NewClient creates registry client for the repository passed and logs it in to the registry
+// (used to transfer images, so mirrors are not taken into account)
+func (r *Remote) NewClient(repo *repository.Repository, username, password string) (*client.RegistryClient, error) {
+	cli, err := client.New(repo.Registry(), r.getClientConfig(repo))
+	if err != nil {
+		return nil, err
+	}
 
-			if i >= cap(rc) {
-				close(rc)
-			}
-		}
+	if err := cli.Login(username, password); err != nil {
+		return nil, err
 	}
 
-	return tags, nil
+	return cli, nil
 }
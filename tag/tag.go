@@ -45,13 +45,18 @@ func (tg *Tag) GetDigest() string {
 
 // GetShortDigest gets shorter form of tagged image's digest
 func (tg *Tag) GetShortDigest() string {
+	return ShortDigest(tg.digest)
+}
+
+// ShortDigest gets shorter form of the image digest passed (the one we show for tags)
+func ShortDigest(digest string) string {
 	const limit = 40
 
-	if len(tg.digest) < limit {
-		return tg.digest
+	if len(digest) < limit {
+		return digest
 	}
 
-	return tg.digest[0:limit]
+	return digest[0:limit]
 }
 
 func cutImageID(s string) string {
@@ -106,6 +111,7 @@ func (tg *Tag) NeedsPull() bool {
 }
 
 // NeedsPush tells us if tag/image needs push to a registry
+// NB! Protected tags (see Protect()) having different digest in registry are in "CONFLICT" state and never need push
 func (tg *Tag) NeedsPush(doUpdate bool) bool {
 	if tg.state == "ABSENT" || (tg.state == "CHANGED" && doUpdate) {
 		return true
@@ -114,6 +120,19 @@ func (tg *Tag) NeedsPush(doUpdate bool) bool {
 	return false
 }
 
+// Protect protects tag from being overwritten in a registry: if tag is "CHANGED" (has different digest there),
+// it gets "CONFLICT" state, so it will never be pushed, even if we update changed tags
+func (tg *Tag) Protect() {
+	if tg.state == "CHANGED" {
+		tg.setState("CONFLICT")
+	}
+}
+
+// IsConflict tells us if tag is protected, but has different digest in a registry (so it could not be pushed)
+func (tg *Tag) IsConflict() bool {
+	return tg.state == "CONFLICT"
+}
+
 // GetCreated gets image creation timestamp
 func (tg *Tag) GetCreated() int64 {
 	return tg.created
@@ -304,6 +304,35 @@ func TestJoin_NeedsPush_WithPushUpdate(t *testing.T) {
 	}
 }
 
+func TestJoin_Protect(t *testing.T) {
+	examples := map[string]string{
+		"v1.3.1": "CONFLICT",
+		"v1.3.2": "PRESENT",
+	}
+	_, _, tags := Join(getRemoteTags(), getLocalTags(), nil)
+
+	for name, expected := range examples {
+		tags[name].Protect()
+
+		if tags[name].GetState() != expected {
+			t.Fatalf(
+				"Unexpected state of protected tag [%s]: %s (expected: %s)",
+				name,
+				tags[name].GetState(),
+				expected,
+			)
+		}
+
+		if tags[name].NeedsPush(true) {
+			t.Fatalf("Protected tag [%s] should not need push", name)
+		}
+
+		if tags[name].IsConflict() != (expected == "CONFLICT") {
+			t.Fatalf("Unexpected conflict of protected tag [%s]", name)
+		}
+	}
+}
+
 func TestCollect(t *testing.T) {
 	keys, tagNames, tagMap := Join(getRemoteTags(), getLocalTags(), nil)
 
@@ -382,3 +411,17 @@ func TestCreated(t *testing.T) {
 		)
 	}
 }
+
+func TestShortDigest(t *testing.T) {
+	var testCases = map[string]string{
+		"sha256:c92260fe6357ac1cdd79e86e23fa287701c5edd2921d243a253fd21c9f0012ae": "sha256:c92260fe6357ac1cdd79e86e23fa28770",
+		"sha256:c92260fe6357":                                                     "sha256:c92260fe6357",
+		"": "",
+	}
+
+	for digest, expected := range testCases {
+		if ShortDigest(digest) != expected {
+			t.Fatalf("Unexpected short digest: '%s' (expected '%s')", ShortDigest(digest), expected)
+		}
+	}
+}
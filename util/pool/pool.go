@@ -0,0 +1,156 @@
+// Package pool provides a worker pool: a bounded number of workers (no more than the pool size) running jobs
+// and waiting for all of them to finish (instead of spawning goroutine per job or batching them),
+// pool size limit is shared by everyone using the same pool
+package pool
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+// Job is a single unit of work to be run on the pool
+type Job func() error
+
+// Pool runs jobs concurrently, but no more than its size at the same time
+// NB! nil *Pool is valid and runs all jobs at once, without any limit
+type Pool struct {
+	slots chan struct{}
+}
+
+// New creates a new Pool of the size passed (zero or negative size means "no limit")
+func New(size int) *Pool {
+	if size <= 0 {
+		return nil
+	}
+
+	return &Pool{slots: make(chan struct{}, size)}
+}
+
+// Size gets size of the pool (0 means "no limit")
+func (p *Pool) Size() int {
+	if p == nil {
+		return 0
+	}
+
+	return cap(p.slots)
+}
+
+func (p *Pool) acquire() {
+	if p != nil {
+		p.slots <- struct{}{}
+	}
+}
+
+func (p *Pool) release() {
+	if p != nil {
+		<-p.slots
+	}
+}
+
+// RunAll runs all jobs passed and waits for them to finish, returning their errors (in order of jobs passed)
+// NB! Jobs are run by no more workers than the pool size, every worker takes jobs one by one from the queue
+// (and still waits for a free pool slot, as the pool could be used by other callers at the same time)
+func (p *Pool) RunAll(jobs []Job) []error {
+	errs := make([]error, len(jobs))
+
+	workers := len(jobs)
+	if size := p.Size(); size > 0 && size < workers {
+		workers = size
+	}
+
+	queue := make(chan int, len(jobs))
+	for i := range jobs {
+		queue <- i
+	}
+	close(queue)
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+
+			for i := range queue {
+				p.acquire()
+				errs[i] = jobs[i]()
+				p.release()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return errs
+}
+
+// Run runs all jobs passed and waits for them to finish, returning the first error (in order of jobs passed), if any
+func (p *Pool) Run(jobs []Job) error {
+	for _, err := range p.RunAll(jobs) {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Errors is a "composite" error holding all errors happened while running jobs,
+// every one of them could be checked with errors.Is() and errors.As()
+type Errors []error
+
+// Error gives us messages of all errors, one per line
+func (errs Errors) Error() string {
+	var errMessage string
+
+	for _, err := range errs {
+		errMessage = errMessage + strings.TrimSuffix(err.Error(), "\n") + "\n"
+	}
+
+	return errMessage
+}
+
+// Unwrap gets all errors held (used by errors.Is and errors.As in Go 1.20+)
+func (errs Errors) Unwrap() []error {
+	return errs
+}
+
+// Is tells us if any of errors held matches the target error
+func (errs Errors) Is(target error) bool {
+	for _, err := range errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// As finds the first of errors held matching the target and sets target to it
+func (errs Errors) As(target interface{}) bool {
+	for _, err := range errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RunWithTolerance is the same as Run, but it returns "composite" error with all errors happened (see Errors)
+func (p *Pool) RunWithTolerance(jobs []Job) error {
+	errs := make(Errors, 0)
+
+	for _, err := range p.RunAll(jobs) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errs
+}
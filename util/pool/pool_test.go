@@ -0,0 +1,157 @@
+package pool
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func makeJobs(n int, running, maxRunning *int, mux *sync.Mutex, fail map[int]bool) []Job {
+	jobs := make([]Job, n)
+
+	for i := range jobs {
+		i := i
+
+		jobs[i] = func() error {
+			mux.Lock()
+			*running++
+			if *running > *maxRunning {
+				*maxRunning = *running
+			}
+			mux.Unlock()
+
+			time.Sleep(10 * time.Millisecond)
+
+			mux.Lock()
+			*running--
+			mux.Unlock()
+
+			if fail[i] {
+				return errors.New("job failed: " + string(rune('a'+i)))
+			}
+
+			return nil
+		}
+	}
+
+	return jobs
+}
+
+func TestRunLimitsConcurrency(t *testing.T) {
+	assert := assert.New(t)
+
+	var running, maxRunning int
+	var mux sync.Mutex
+
+	p := New(3)
+
+	assert.Nil(p.Run(makeJobs(10, &running, &maxRunning, &mux, nil)))
+	assert.Equal(3, maxRunning)
+	assert.Equal(3, p.Size())
+}
+
+func TestRunWithNoLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	var running, maxRunning int
+	var mux sync.Mutex
+
+	p := New(0)
+
+	assert.Nil(p)
+	assert.Nil(p.Run(makeJobs(10, &running, &maxRunning, &mux, nil)))
+	assert.Equal(10, maxRunning)
+	assert.Equal(0, p.Size())
+}
+
+func TestRunErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	var running, maxRunning int
+	var mux sync.Mutex
+
+	p := New(2)
+	fail := map[int]bool{1: true, 3: true}
+
+	errs := p.RunAll(makeJobs(5, &running, &maxRunning, &mux, fail))
+	assert.Equal(5, len(errs))
+	for i, err := range errs {
+		assert.Equal(fail[i], err != nil)
+	}
+
+	err := p.Run(makeJobs(5, &running, &maxRunning, &mux, fail))
+	assert.EqualError(err, "job failed: b")
+
+	err = p.RunWithTolerance(makeJobs(5, &running, &maxRunning, &mux, fail))
+	assert.EqualError(err, "job failed: b\njob failed: d\n")
+
+	assert.Nil(p.RunWithTolerance(nil))
+}
+
+func TestErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	errNotFound := errors.New("not found")
+
+	p := New(2)
+
+	err := p.RunWithTolerance([]Job{
+		func() error { return nil },
+		func() error { return &jobError{name: "b", err: errNotFound} },
+		func() error { return errors.New("job failed: c") },
+	})
+
+	assert.EqualError(err, "job b failed: not found\njob failed: c\n")
+	assert.True(errors.Is(err, errNotFound))
+
+	var e *jobError
+	if assert.True(errors.As(err, &e)) {
+		assert.Equal("b", e.name)
+	}
+
+	assert.False(errors.Is(err, errors.New("not found")))
+}
+
+type jobError struct {
+	name string
+	err  error
+}
+
+func (e *jobError) Error() string {
+	return "job " + e.name + " failed: " + e.err.Error()
+}
+
+func (e *jobError) Unwrap() error {
+	return e.err
+}
+
+func TestRunStartsNoMoreWorkersThanSize(t *testing.T) {
+	assert := assert.New(t)
+
+	var maxGoroutines int
+	var mux sync.Mutex
+
+	jobs := make([]Job, 100)
+	for i := range jobs {
+		jobs[i] = func() error {
+			mux.Lock()
+			if n := runtime.NumGoroutine(); n > maxGoroutines {
+				maxGoroutines = n
+			}
+			mux.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			return nil
+		}
+	}
+
+	goroutines := runtime.NumGoroutine()
+
+	assert.Nil(New(3).Run(jobs))
+	assert.True(maxGoroutines <= goroutines+3, "should run jobs on 3 workers, got %d goroutines", maxGoroutines-goroutines)
+}
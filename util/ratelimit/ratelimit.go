@@ -0,0 +1,115 @@
+// Package ratelimit limits registry requests: both their rate (token bucket) and number of them run in parallel,
+// every registry (keyed by its hostname) could have its own limits, so busy registries do not slow down the rest.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter limits both request rate (token bucket) and number of concurrent requests
+// NB! nil *Limiter is valid and does not limit anything at all
+type Limiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	slots  chan struct{}
+	mux    sync.Mutex
+}
+
+// New creates a new Limiter allowing "rate" requests per second and "concurrency" requests in parallel
+// (zero or negative values mean "no limit")
+func New(rate float64, concurrency int) *Limiter {
+	l := &Limiter{rate: rate, burst: 1, last: time.Now()}
+
+	if rate > 1 {
+		l.burst = rate
+	}
+	l.tokens = l.burst
+
+	if concurrency > 0 {
+		l.slots = make(chan struct{}, concurrency)
+	}
+
+	return l
+}
+
+// reserve takes a token from bucket and tells us how long we need to wait before using it
+func (l *Limiter) reserve() time.Duration {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	now := time.Now()
+
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	l.tokens--
+
+	if l.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// Acquire blocks until request is allowed both by rate and concurrency limits
+func (l *Limiter) Acquire() {
+	if l == nil {
+		return
+	}
+
+	if l.slots != nil {
+		l.slots <- struct{}{}
+	}
+
+	if l.rate > 0 {
+		time.Sleep(l.reserve())
+	}
+}
+
+// Release frees concurrency slot taken by Acquire
+func (l *Limiter) Release() {
+	if l == nil || l.slots == nil {
+		return
+	}
+
+	<-l.slots
+}
+
+// Set holds limiters keyed by name (e.g. registry hostname), creating them on demand with the same limits,
+// so every name gets its own token bucket and concurrency limit
+// NB! nil *Set is valid and gives nil (non-limiting) limiters
+type Set struct {
+	rate        float64
+	concurrency int
+	limiters    map[string]*Limiter
+	mux         sync.Mutex
+}
+
+// NewSet creates a new Set of limiters allowing "rate" requests per second and "concurrency" requests in parallel
+func NewSet(rate float64, concurrency int) *Set {
+	return &Set{rate: rate, concurrency: concurrency, limiters: make(map[string]*Limiter)}
+}
+
+// Get gets limiter by name passed, creating it, if not created yet
+func (s *Set) Get(name string) *Limiter {
+	if s == nil {
+		return nil
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	l, defined := s.limiters[name]
+	if !defined {
+		l = New(s.rate, s.concurrency)
+		s.limiters[name] = l
+	}
+
+	return l
+}
@@ -0,0 +1,82 @@
+package ratelimit
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNilLimiter(t *testing.T) {
+	var l *Limiter
+
+	l.Acquire()
+	l.Release()
+}
+
+func TestRate(t *testing.T) {
+	l := New(20, 0)
+
+	start := time.Now()
+	for i := 0; i < 30; i++ {
+		l.Acquire()
+		l.Release()
+	}
+	elapsed := time.Since(start)
+
+	// 20 requests go immediately (burst), then 10 more at 20 req/s
+	assert.True(t, elapsed >= 400*time.Millisecond, "too fast: %v", elapsed)
+	assert.True(t, elapsed < 1500*time.Millisecond, "too slow: %v", elapsed)
+}
+
+func TestConcurrency(t *testing.T) {
+	const concurrency = 3
+
+	l := New(0, concurrency)
+
+	var mux sync.Mutex
+	var current, peak int
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			l.Acquire()
+			defer l.Release()
+
+			mux.Lock()
+			current++
+			if current > peak {
+				peak = current
+			}
+			mux.Unlock()
+
+			time.Sleep(5 * time.Millisecond)
+
+			mux.Lock()
+			current--
+			mux.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, concurrency, peak)
+}
+
+func TestSet(t *testing.T) {
+	s := NewSet(10, 2)
+
+	l1 := s.Get("registry.company.io")
+	l2 := s.Get("quay.io")
+
+	assert.NotNil(t, l1)
+	assert.True(t, l1 == s.Get("registry.company.io"), "should give the same limiter for the same name")
+	assert.False(t, l1 == l2, "should give different limiters for different names")
+
+	var nilSet *Set
+	assert.Nil(t, nilSet.Get("registry.company.io"))
+}
@@ -0,0 +1,178 @@
+// Package redact hides credentials and tokens in everything we log or trace:
+// HTTP headers, URLs (user info and query parameters) and JSON documents (auth strings, token responses).
+// Secrets could be revealed for local debugging by setting LSTAGS_REVEAL_SECRETS=true environment variable.
+package redact
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Mask is what we put instead of any secret
+const Mask = "REDACTED"
+
+// RevealEnv is a name of environment variable to reveal secrets with (explicit opt-in, for local debugging only!)
+const RevealEnv = "LSTAGS_REVEAL_SECRETS"
+
+// sensitive HTTP headers (canonical form)
+var headers = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"X-Registry-Auth":     true,
+	"X-Registry-Config":   true,
+}
+
+// sensitive URL query parameters and JSON keys (lowercase)
+var keys = map[string]bool{
+	"auth":                 true,
+	"password":             true,
+	"secret":               true,
+	"token":                true,
+	"access_token":         true,
+	"refresh_token":        true,
+	"identitytoken":        true,
+	"registrytoken":        true,
+	"sig":                  true,
+	"signature":            true,
+	"x-amz-signature":      true,
+	"x-amz-credential":     true,
+	"x-amz-security-token": true,
+}
+
+// IsRevealed tells us if secrets are explicitly revealed (not redacted)
+func IsRevealed() bool {
+	switch strings.ToLower(os.Getenv(RevealEnv)) {
+	case "true", "yes", "1":
+		return true
+	}
+
+	return false
+}
+
+// HeaderValue redacts value of the HTTP header passed, if header is a sensitive one
+// NB! For "Authorization" headers we keep authentication method, e.g. "Bearer REDACTED"
+func HeaderValue(name, value string) string {
+	name = http.CanonicalHeaderKey(name)
+
+	if IsRevealed() || !headers[name] || value == "" {
+		return value
+	}
+
+	if name == "Authorization" || name == "Proxy-Authorization" {
+		if fields := strings.SplitN(value, " ", 2); len(fields) == 2 {
+			return fields[0] + " " + Mask
+		}
+	}
+
+	return Mask
+}
+
+// Header makes a copy of HTTP headers passed with values of all sensitive headers redacted
+func Header(h http.Header) http.Header {
+	redacted := make(http.Header, len(h))
+
+	for k, vv := range h {
+		redacted[k] = make([]string, len(vv))
+
+		for i, v := range vv {
+			redacted[k][i] = HeaderValue(k, v)
+		}
+	}
+
+	return redacted
+}
+
+// URL redacts password in URL user info and values of sensitive URL query parameters
+func URL(rawurl string) string {
+	if IsRevealed() {
+		return rawurl
+	}
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return rawurl
+	}
+
+	if u.User != nil {
+		if _, defined := u.User.Password(); defined {
+			u.User = url.UserPassword(u.User.Username(), Mask)
+		}
+	}
+
+	if u.RawQuery != "" {
+		q := u.Query()
+
+		isRedacted := false
+		for k := range q {
+			if keys[strings.ToLower(k)] {
+				q.Set(k, Mask)
+				isRedacted = true
+			}
+		}
+
+		if isRedacted {
+			u.RawQuery = q.Encode()
+		}
+	}
+
+	return u.String()
+}
+
+// Secret redacts the secret passed as a whole (e.g. auth string or password), empty secrets are kept empty
+func Secret(s string) string {
+	if IsRevealed() || s == "" {
+		return s
+	}
+
+	return Mask
+}
+
+// JSON redacts values of sensitive keys (at any depth) of the JSON document passed
+// NB! Non-JSON input is returned as is, so it is safe to pass any response body here
+func JSON(s string) string {
+	if IsRevealed() {
+		return s
+	}
+
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		return s
+	}
+
+	b, err := json.Marshal(redactValue(v))
+	if err != nil {
+		return s
+	}
+
+	return string(b)
+}
+
+func redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, value := range v {
+			if keys[strings.ToLower(k)] {
+				if s, isString := value.(string); isString && s == "" {
+					continue
+				}
+
+				v[k] = Mask
+
+				continue
+			}
+
+			v[k] = redactValue(value)
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = redactValue(value)
+		}
+	}
+
+	return v
+}